@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+)
+
+var (
+	errSignedOut = errors.New("you must be signed in")
+	errNotOwner  = errors.New("only the owner may change this")
+	errNotAuthor = errors.New("only the author may change this post")
+	errNoName    = errors.New("you need a display name to post, ask an admin to set one")
+)
+
+// Permission is something a role allows its users to do. Routers declare
+// the permission each route needs with Require and RequireOwner.
+type Permission string
+
+const (
+	// PermWritePosts allows creating posts, and updating and deleting your
+	// own.
+	PermWritePosts Permission = "posts:write"
+	// PermWriteAnyPost allows posting as any author, and updating and
+	// deleting anyone's posts.
+	PermWriteAnyPost Permission = "posts:write-any"
+	// PermWriteComments allows commenting and replying, and changing your
+	// own comments and replies.
+	PermWriteComments Permission = "comments:write"
+	// PermModerate allows changing anyone's comments and replies.
+	PermModerate Permission = "comments:moderate"
+	// PermWriteUsers allows creating users.
+	PermWriteUsers Permission = "users:write"
+	// PermReact allows reacting to posts and comments.
+	PermReact Permission = "reactions:write"
+)
+
+// rolePermissions lists what each role may do. Banned users may only read.
+var rolePermissions = map[string][]Permission{
+	models.RoleAdmin:     {PermWritePosts, PermWriteAnyPost, PermWriteComments, PermModerate, PermWriteUsers, PermReact},
+	models.RoleAuthor:    {PermWritePosts, PermWriteComments, PermReact},
+	models.RoleCommenter: {PermWriteComments, PermReact},
+	models.RoleBanned:    {},
+}
+
+// CurrentUserCtx puts the signed in user in the request context, see
+// currentUser. Who they are comes from their bearer token, and what they may
+// do and what they are called from the user stored for them by POST /user.
+// Signed in users nobody has stored are commenters, going by the name in
+// their token.
+func (env *Env) CurrentUserCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		id := ht.CurrentIdentity(rq)
+		if id == nil {
+			next.ServeHTTP(w, rq)
+			return
+		}
+
+		user := &models.User{
+			UID:         id.Subject,
+			ProviderID:  id.Provider,
+			Email:       id.Email,
+			DisplayName: id.Name,
+			PhotoURL:    id.Picture,
+		}
+		stored, err := env.db.DBGetUserByOwner(rq.Context(), user)
+		switch err {
+		case nil:
+			user.ID = stored.ID
+			user.Role = stored.Role
+			// users choose the name in their token themselves, so it
+			// must not pass for the name an author's posts are under
+			user.DisplayName = stored.DisplayName
+		case models.ErrNotFound:
+		default:
+			render.Render(w, rq, ErrDatastore(err), ht.Logger(rq))
+			return
+		}
+
+		ctx := context.WithValue(rq.Context(), "currentUser", user)
+		next.ServeHTTP(w, rq.WithContext(ctx))
+	})
+}
+
+// currentUser returns the user who signed in to make rq, or nil if it is
+// anonymous.
+func currentUser(rq *http.Request) *models.User {
+	return userFromContext(rq.Context())
+}
+
+// userFromContext returns the signed in user ctx carries, or nil if there is
+// none.
+func userFromContext(ctx context.Context) *models.User {
+	user, _ := ctx.Value("currentUser").(*models.User)
+	return user
+}
+
+// can reports whether user's role grants p. Signed in users without a role
+// are commenters.
+func can(user *models.User, p Permission) bool {
+	if user == nil {
+		return false
+	}
+	role := user.Role
+	if role == "" {
+		role = models.RoleCommenter
+	}
+	for _, granted := range rolePermissions[role] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// owns reports whether owner is the same identity as user. Resources without
+// an owner belong to nobody.
+func owns(user, owner *models.User) bool {
+	return user != nil && owner != nil && owner.UID != "" &&
+		owner.UID == user.UID && owner.ProviderID == user.ProviderID
+}
+
+// storedName returns the display name stored for user, which their posts
+// are listed under, or "" when nobody stored them. Only stored users have
+// an ID.
+func storedName(user *models.User) string {
+	if user == nil || user.ID == "" {
+		return ""
+	}
+	return user.DisplayName
+}
+
+// wrote reports whether user is the author of post. Posts made before their
+// authors were recorded with them are matched by the stored display name.
+func wrote(user *models.User, post *models.Post) bool {
+	if user == nil {
+		return false
+	}
+	if post.User != nil {
+		return owns(user, post.User)
+	}
+	name := storedName(user)
+	return name != "" && post.Author == name
+}
+
+// Require returns middleware that only lets through requests from users
+// whose role grants p. Anonymous requests get a 401, everyone else a 403.
+func Require(p Permission) ht.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+			user := currentUser(rq)
+			if user == nil {
+				render.Render(w, rq, render.ErrUnauthorized(errSignedOut), ht.Logger(rq))
+				return
+			}
+			if !can(user, p) {
+				render.Render(w, rq, render.ErrForbidden(fmt.Errorf("permission %s is required", p)), ht.Logger(rq))
+				return
+			}
+			next.ServeHTTP(w, rq)
+		})
+	}
+}
+
+// RequireOwner returns middleware that only lets through the owner of the
+// resource a request is for, or users whose role grants override. owner
+// returns the resource's owner, and runs after the middleware that loads
+// the resource into the request context.
+func RequireOwner(owner func(rq *http.Request) *models.User, override Permission) ht.Middleware {
+	return requireOwner(func(rq *http.Request, user *models.User) bool {
+		return owns(user, owner(rq))
+	}, override, errNotOwner)
+}
+
+// RequireAuthor returns middleware that only lets through the author of the
+// post in the request context, or users whose role grants PermWriteAnyPost.
+// It runs after PostCtx.
+func RequireAuthor() ht.Middleware {
+	return requireOwner(func(rq *http.Request, user *models.User) bool {
+		return wrote(user, rq.Context().Value("post").(*models.Post))
+	}, PermWriteAnyPost, errNotAuthor)
+}
+
+// requireOwner returns middleware that only lets through users for whom
+// owned reports true, or whose role grants override. Everyone else gets
+// denied.
+func requireOwner(owned func(rq *http.Request, user *models.User) bool, override Permission, denied error) ht.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+			user := currentUser(rq)
+			if user == nil {
+				render.Render(w, rq, render.ErrUnauthorized(errSignedOut), ht.Logger(rq))
+				return
+			}
+			if !owned(rq, user) && !can(user, override) {
+				render.Render(w, rq, render.ErrForbidden(denied), ht.Logger(rq))
+				return
+			}
+			next.ServeHTTP(w, rq)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/corymhall/blog-backend-go/cmd/api/models"
@@ -10,17 +11,114 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// commentRouter serves the flat /comments routes, kept for existing clients.
+// New clients should use the routes under /posts/{postID}/comments.
 func commentRouter(env *Env) chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+	r.With(Require(PermWriteComments)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
 		logger := ht.Logger(rq)
 		env.CreateComment(w, rq, logger)
 	})
 
+	r.With(Require(PermWriteComments), env.CommentCtx, RequireOwner(commentOwner, PermModerate)).
+		Delete("/{postID}/{commentID}", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.DeleteComment(w, rq, logger)
+		})
+
 	return r
 }
 
+// postCommentRouter serves the comments on the post named by the postID url
+// parameter, and the replies to them.
+func postCommentRouter(env *Env) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.GetComments(w, rq, logger)
+	})
+	r.With(Require(PermWriteComments)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.CreateComment(w, rq, logger)
+	})
+
+	r.Route("/{commentID}", func(r chi.Router) {
+		r.Use(env.CommentCtx)
+		r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.GetComment(w, rq, logger)
+		})
+		r.With(Require(PermWriteComments), RequireOwner(commentOwner, PermModerate)).Delete("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.DeleteComment(w, rq, logger)
+		})
+		r.Mount("/replies", commentReplyRouter(env))
+		r.Mount("/reactions", reactionRouter(env, commentTarget))
+	})
+
+	return r
+}
+
+// CommentCtx loads the comment named by the postID and commentID url
+// parameters into the request context.
+func (env *Env) CommentCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		postID := chi.URLParam(rq, "postID")
+		commentID := chi.URLParam(rq, "commentID")
+
+		comments, err := env.db.DBGetComments(rq.Context(), postID)
+		if err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		comment := findComment(comments, commentID)
+		if comment == nil {
+			render.Render(w, rq, render.ErrNotFound, logger)
+			return
+		}
+		ctx := context.WithValue(rq.Context(), "comment", comment)
+		next.ServeHTTP(w, rq.WithContext(ctx))
+	})
+}
+
+// commentOwner returns who made the comment loaded by CommentCtx.
+func commentOwner(rq *http.Request) *models.User {
+	return rq.Context().Value("comment").(*models.Comment).User
+}
+
+func (env *Env) GetComments(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	comments, err := env.db.DBGetComments(rq.Context(), chi.URLParam(rq, "postID"))
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, comment := range NewCommentListPayloadResponse(rq.Context(), comments, env) {
+		list = append(list, comment)
+	}
+	if err := render.RenderList(w, rq, list, logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
+func (env *Env) GetComment(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	comment := rq.Context().Value("comment").(*models.Comment)
+	if !visible(rq.Context(), comment.Status) {
+		render.Render(w, rq, render.ErrNotFound, logger)
+		return
+	}
+
+	if err := render.Render(w, rq, NewCommentPayloadResponse(rq.Context(), comment, env), logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
 func (env *Env) CreateComment(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
 
 	data := &CommentPayload{}
@@ -30,19 +128,48 @@ func (env *Env) CreateComment(w http.ResponseWriter, rq *http.Request, logger ze
 	}
 
 	comment := data.Comment
-	if err := env.db.DBCreateComment(comment); err != nil {
-		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+	if comment.ID != "" || !comment.CommentDate.IsZero() || comment.Status != "" || comment.Reactions != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(errServerAssigned("id, comment_date, status and reactions")), logger)
+		return
+	}
+	comment.CommentDate = env.now()
+	comment.ID = env.newID(comment.CommentDate)
+	// comments belong to whoever is signed in, whatever the payload says
+	comment.User = currentUser(rq)
+
+	status, err := env.initialStatus(rq.Context(), comment.User)
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	comment.Spam, comment.Status = env.checkSpam(rq.Context(), comment.User, comment.CommentText, status, logger)
+
+	if err := env.db.DBCreateComment(rq.Context(), comment); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
 		return
 	}
 
 	render.Status(rq, http.StatusCreated)
-	render.Render(w, rq, NewCommentPayloadResponse(comment, env), logger)
+	render.Render(w, rq, NewCommentPayloadResponse(rq.Context(), comment, env), logger)
 
 }
 
+func (env *Env) DeleteComment(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	postID := chi.URLParam(rq, "postID")
+	commentID := chi.URLParam(rq, "commentID")
+
+	if err := env.db.DBDeleteComment(rq.Context(), postID, commentID); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	render.NoContent(w, rq)
+}
+
 type CommentPayload struct {
 	Comment *models.Comment `json:"comment"`
 	Replies []*ReplyPayload `json:"replies,omitempty"`
+	// Reaction is how the current user reacted to the comment, if they did.
+	Reaction string `json:"reaction,omitempty"`
 }
 
 type CommentListPayload []*CommentPayload
@@ -51,28 +178,55 @@ func (p *CommentPayload) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Bind takes the post being commented on from the url when there is one.
 func (p *CommentPayload) Bind(r *http.Request) error {
+	if postID := chi.URLParam(r, "postID"); postID != "" && p.Comment != nil {
+		p.Comment.PostID = postID
+	}
 	return nil
 }
 
-func NewCommentPayloadResponse(comment *models.Comment, env *Env) *CommentPayload {
+func (p *CommentPayload) Validate(v *render.Validation) {
+	if !v.Present("comment", p.Comment != nil) {
+		return
+	}
+	v.Required("comment.post_id", p.Comment.PostID)
+	v.Required("comment.comment_text", p.Comment.CommentText)
+	v.MaxLength("comment.comment_text", p.Comment.CommentText, 5000)
+}
+
+func NewCommentPayloadResponse(ctx context.Context, comment *models.Comment, env *Env) *CommentPayload {
+	resp := newCommentPayload(ctx, comment, env)
+	env.markReactions(ctx, nil, []*CommentPayload{resp})
+	return resp
+}
+
+// newCommentPayload is NewCommentPayloadResponse without the current user's
+// reaction, so lists can look those up all at once.
+func newCommentPayload(ctx context.Context, comment *models.Comment, env *Env) *CommentPayload {
 	resp := &CommentPayload{
-		Comment: comment,
+		Comment: redactComment(ctx, comment),
 	}
 
 	if resp.Replies == nil {
-		if replies, _ := env.db.DBGetReplies(comment.PostID, comment.ID); replies != nil {
-			resp.Replies = NewReplyListPayloadResponse(replies)
+		if replies, _ := env.db.DBGetReplies(ctx, comment.PostID, comment.ID); replies != nil {
+			resp.Replies = NewReplyListPayloadResponse(ctx, replies)
 		}
 	}
 
 	return resp
 }
 
-func NewCommentListPayloadResponse(comments []*models.Comment, env *Env) []*CommentPayload {
+// NewCommentListPayloadResponse leaves out the comments the user ctx belongs
+// to may not see.
+func NewCommentListPayloadResponse(ctx context.Context, comments []*models.Comment, env *Env) []*CommentPayload {
 	list := []*CommentPayload{}
 	for _, comment := range comments {
-		list = append(list, NewCommentPayloadResponse(comment, env))
+		if !visible(ctx, comment.Status) {
+			continue
+		}
+		list = append(list, newCommentPayload(ctx, comment, env))
 	}
+	env.markReactions(ctx, nil, list)
 	return list
 }
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+)
+
+// ErrDatastore returns the Renderer for an error returned by the datastore,
+// so the client can tell a missing resource or a lost race from a failure
+// on our side.
+func ErrDatastore(err error) render.Renderer {
+	switch err {
+	case models.ErrNotFound:
+		return render.ErrNotFound
+	case models.ErrConflict:
+		return render.ErrConflict(err)
+	case models.ErrUnavailable:
+		return render.ErrServiceUnavailable(err)
+	case models.ErrInvalidCursor:
+		return render.ErrInvalidRequest(err)
+	}
+	return render.ErrInternalServerError(err)
+}
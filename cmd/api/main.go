@@ -4,16 +4,24 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	"github.com/corymhall/blog-backend-go/pkg/blob"
 	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/corymhall/blog-backend-go/pkg/spam"
+	"github.com/corymhall/blog-backend-go/pkg/trace"
+	"github.com/corymhall/blog-backend-go/pkg/ulid"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
@@ -23,22 +31,176 @@ import (
 
 type Env struct {
 	db models.Datastore
+
+	// author is whose posts GET /posts lists when no author is requested.
+	author string
+
+	// clock and ids stamp resources created through the api. When nil the
+	// wall clock and random ULIDs are used.
+	clock func() time.Time
+	ids   func(time.Time) string
+
+	// auth authenticates bearer tokens. When nil no token can be verified,
+	// so the api is read only.
+	auth *ht.Authenticator
+
+	// moderation decides whether new comments and replies go live straight
+	// away. When nil everything is approved.
+	moderation ModerationPolicy
+
+	// spam checks new comments and replies before they are stored. When nil
+	// nothing is checked.
+	spam SpamChecker
+
+	// limiter limits how many requests each client may make. When nil
+	// clients are not limited.
+	limiter *ht.RateLimiter
+
+	// proxies are the networks of the proxies trusted to say which client
+	// a request is from. When empty the address a request came from is the
+	// client's.
+	proxies []*net.IPNet
+
+	// maxReplyDepth bounds how deep replies to replies may nest. When zero
+	// defaultMaxReplyDepth is used.
+	maxReplyDepth int
+
+	// blobs stores uploaded media. When nil uploads are refused.
+	blobs BlobStore
+
+	// maxMediaSize is the largest upload accepted in bytes. When zero
+	// defaultMaxMediaSize is used.
+	maxMediaSize int64
+
+	// metrics are served at /metrics. When nil nothing is measured.
+	metrics *Metrics
+
+	// tracer records a trace of each request. When nil nothing is traced.
+	tracer *trace.Tracer
+
+	// errorSink is told about requests that panic. When nil they are only
+	// logged.
+	errorSink ht.ErrorSink
+
+	// cacheControl is the Cache-Control policy of the routes mounted at
+	// each path, e.g. "/posts". Routes without one send no Cache-Control.
+	cacheControl cachePolicies
+}
+
+var defaultIDs = ulid.New(nil)
+
+// now returns the time a resource created by the current request is stamped with.
+func (env *Env) now() time.Time {
+	if env.clock != nil {
+		return env.clock()
+	}
+	return time.Now().UTC()
+}
+
+// newID returns a unique ID for a resource created at t. IDs sort by creation time.
+func (env *Env) newID(t time.Time) string {
+	if env.ids != nil {
+		return env.ids(t)
+	}
+	return defaultIDs.Make(t)
+}
+
+// defaultMaxReplyDepth is how deep replies nest unless configured otherwise.
+const defaultMaxReplyDepth = 8
+
+// maxDepth returns how deep replies may nest.
+func (env *Env) maxDepth() int {
+	if env.maxReplyDepth > 0 {
+		return env.maxReplyDepth
+	}
+	return defaultMaxReplyDepth
+}
+
+// cachePolicies maps the path routes are mounted at to their Cache-Control
+// policy. As a flag it is set with path=policy, once for each path.
+type cachePolicies map[string]string
+
+// defaultCachePolicies make clients revalidate every response. Responses
+// depend on who is signed in, so shared caches must not keep them, and
+// revalidating with an ETag is cheap.
+var defaultCachePolicies = cachePolicies{
+	"/posts":      "private, no-cache",
+	"/comments":   "private, no-cache",
+	"/replies":    "private, no-cache",
+	"/user":       "private, no-cache",
+	"/moderation": "private, no-store",
+	"/media":      "private, no-cache",
+}
+
+func (c cachePolicies) String() string {
+	paths := []string{}
+	for path, policy := range c {
+		paths = append(paths, path+"="+policy)
+	}
+	sort.Strings(paths)
+	return strings.Join(paths, " ")
+}
+
+func (c cachePolicies) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i < 1 || !strings.HasPrefix(v, "/") {
+		return fmt.Errorf("expected path=policy, got %q", v)
+	}
+	c[v[:i]] = strings.TrimSpace(v[i+1:])
+	return nil
+}
+
+// errServerAssigned is returned when a client sets fields only the server may set.
+func errServerAssigned(fields string) error {
+	return fmt.Errorf("%s cannot be set, the server assigns them", fields)
 }
 
 func main() {
 	var (
-		httpAddr = flag.String("http.addr", fmt.Sprintf(":%s", os.Getenv("PORT")), "HTTP listen address")
+		httpAddr    = flag.String("http.addr", fmt.Sprintf(":%s", os.Getenv("PORT")), "HTTP listen address")
+		author      = flag.String("posts.author", models.DefaultAuthor, "author listed by GET /posts when none is requested")
+		store       = flag.String("db", "dynamodb", "datastore to use: dynamodb or memory")
+		timeout     = flag.Duration("db.timeout", 5*time.Second, "timeout for each datastore operation, 0 for none")
+		withMetrics = flag.Bool("metrics", true, "serve Prometheus metrics at /metrics")
+		traceExport = flag.String("trace.exporter", "none", "where traces are exported to: none, file or otlp")
+		traceFile   = flag.String("trace.file", "traces.jsonl", "file the file trace exporter appends spans to as JSON lines")
+		otlpURL     = flag.String("trace.otlp.endpoint", trace.DefaultOTLPEndpoint, "URL the otlp trace exporter posts spans to")
+		otlpHeaders = flag.String("trace.otlp.headers", "", "comma separated name=value headers sent with each otlp export")
+		traceSample = flag.Float64("trace.sample", 1, "share of requests traced, from 0 to 1, when the caller has not decided")
+		panicHook   = flag.String("panic.webhook", "", "URL requests that panic are posted to as JSON, e.g. an error tracker's webhook")
+		cacheSize   = flag.Int("db.cache-size", models.DefaultCacheSize, "how many datastore reads to cache, 0 to cache none")
+		postsTTL    = flag.Duration("db.cache-ttl.posts", models.DefaultCacheTTLs.Posts, "how long posts are cached")
+		commentsTTL = flag.Duration("db.cache-ttl.comments", models.DefaultCacheTTLs.Comments, "how long comments and replies are cached")
+		usersTTL    = flag.Duration("db.cache-ttl.users", models.DefaultCacheTTLs.Users, "how long users are cached")
+		mediaTTL    = flag.Duration("db.cache-ttl.media", models.DefaultCacheTTLs.Media, "how long media is cached")
+		jwks        = flag.String("auth.jwks", "", "file or URL of the JSON Web Key Set that signs bearer tokens")
+		refresh     = flag.Duration("auth.jwks-refresh", time.Hour, "how often to reload the JSON Web Key Set")
+		issuer      = flag.String("auth.issuer", "", "required iss claim of bearer tokens, must be set with -auth.jwks")
+		audience    = flag.String("auth.audience", "", "required aud claim of bearer tokens, must be set with -auth.jwks")
+		moderate    = flag.String("moderation", "returning", "which comments and replies to hold for moderation: none, all or returning, which holds them until their author has had a comment approved")
+		checkSpam   = flag.Bool("spam", true, "check new comments and replies for spam")
+		spamModel   = flag.String("spam.model", "", "file the spam model is kept in, it is only kept in memory when empty")
+		spamBlocked = flag.String("spam.blocked", "", "comma separated words that mark a comment or reply as spam")
+		replyDepth  = flag.Int("replies.max-depth", defaultMaxReplyDepth, "how deep replies to replies may nest")
+		readLimit   = flag.String("ratelimit.read", "300/m", "reads each client may make per s, m or h, 0 for no limit")
+		writeLimit  = flag.String("ratelimit.write", "30/m", "writes each client may make per s, m or h, 0 for no limit")
+		proxies     = flag.String("http.trusted-proxies", ht.DefaultTrustedProxies, "comma separated addresses or networks of proxies trusted to name the client in X-Forwarded-For or X-Real-IP")
+		mediaStore  = flag.String("media.store", "", "where uploaded media is kept: local or s3, uploads are refused when empty")
+		mediaDir    = flag.String("media.dir", "media", "directory the local media store writes to, created on the first upload")
+		mediaBucket = flag.String("media.bucket", "", "S3 bucket the s3 media store writes to")
+		mediaRegion = flag.String("media.region", "us-east-2", "region of the S3 media bucket")
+		mediaURL    = flag.String("media.base-url", "", "URL media is served from, by default the api for local and the bucket for s3")
+		mediaSize   = flag.Int64("media.max-size", defaultMaxMediaSize, "largest media upload accepted in bytes")
+		cache       = cachePolicies{}
 	)
+	for path, policy := range defaultCachePolicies {
+		cache[path] = policy
+	}
+	flag.Var(cache, "cache-control", "Cache-Control policy of the routes under a path, as path=policy, e.g. \"/posts=public, max-age=60\", an empty policy sends none")
 
 	flag.Parse()
 	valv := valve.New()
 	baseCtx := valv.Context()
-	sess := session.Must(session.NewSession(&aws.Config{
-		Region: aws.String("us-east-2"),
-	}))
-	env := &Env{db: &models.DB{
-		Svc: dynamodb.New(sess),
-	}}
 
 	var logger zerolog.Logger
 	{
@@ -48,6 +210,150 @@ func main() {
 			Logger()
 	}
 
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region: aws.String("us-east-2"),
+	}))
+
+	env := &Env{
+		author:        *author,
+		maxReplyDepth: *replyDepth,
+		maxMediaSize:  *mediaSize,
+		cacheControl:  cache,
+	}
+	if env.moderation = ModerationPolicies[*moderate]; env.moderation == nil {
+		logger.Fatal().Str("moderation", *moderate).Msg("unknown moderation policy")
+	}
+	switch *store {
+	case "dynamodb":
+		env.db = &models.DB{
+			Svc:     dynamodb.New(sess),
+			Timeout: *timeout,
+		}
+	case "memory":
+		// nothing is persisted, useful for running the api without aws
+		env.db = models.NewMemoryDB()
+	default:
+		logger.Fatal().Str("db", *store).Msg("unknown datastore")
+	}
+	logger.Info().Str("db", *store).Msg("using datastore")
+	if *withMetrics {
+		env.metrics = NewMetrics()
+		// inside the cache, so only calls that reach the datastore count
+		env.db = env.metrics.Instrument(env.db)
+	}
+	if *cacheSize > 0 {
+		cache := models.NewCache(env.db, models.CacheConfig{
+			Size: *cacheSize,
+			TTL: models.CacheTTLs{
+				Posts:    *postsTTL,
+				Post:     *postsTTL,
+				Comments: *commentsTTL,
+				Users:    *usersTTL,
+				Media:    *mediaTTL,
+			},
+		})
+		if env.metrics != nil {
+			env.metrics.ObserveCache(cache)
+		}
+		env.db = cache
+	}
+
+	tracer, err := NewTracer(TracingConfig{
+		Exporter:    *traceExport,
+		File:        *traceFile,
+		Endpoint:    *otlpURL,
+		Headers:     *otlpHeaders,
+		SampleRatio: *traceSample,
+		OnError: func(err error) {
+			logger.Error().Err(err).Msg("unable to export traces")
+		},
+	})
+	if err != nil {
+		logger.Fatal().Err(err).Msg("unable to set up tracing")
+	}
+	if tracer != nil {
+		// outside the cache, so each call a request makes has a span
+		env.tracer = tracer
+		env.db = traceDatastore(env.db)
+		defer tracer.Close()
+	}
+
+	switch *mediaStore {
+	case "":
+		// read only hosts such as Lambda have nowhere to keep uploads
+	case "local":
+		base := *mediaURL
+		if base == "" {
+			base = "/media/files/"
+		}
+		env.blobs = blob.NewDir(*mediaDir, base)
+	case "s3":
+		if *mediaBucket == "" {
+			logger.Fatal().Msg("the s3 media store needs -media.bucket")
+		}
+		env.blobs = blob.NewS3(blob.S3Config{
+			Bucket:      *mediaBucket,
+			Region:      *mediaRegion,
+			Credentials: sess.Config.Credentials,
+			BaseURL:     *mediaURL,
+		})
+	default:
+		logger.Fatal().Str("media.store", *mediaStore).Msg("unknown media store")
+	}
+
+	if *checkSpam {
+		filter, err := spam.NewFilter(spam.Config{
+			Blocked:   strings.Split(*spamBlocked, ","),
+			ModelPath: *spamModel,
+		})
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to load spam model")
+		}
+		env.spam = filter
+	}
+
+	{
+		read, err := ht.ParseLimit(*readLimit)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid read rate limit")
+		}
+		write, err := ht.ParseLimit(*writeLimit)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("invalid write rate limit")
+		}
+		env.limiter = ht.NewRateLimiter(ht.RateLimitConfig{Read: read, Write: write})
+
+		if env.proxies, err = ht.ParseProxies(*proxies); err != nil {
+			logger.Fatal().Err(err).Msg("invalid trusted proxies")
+		}
+	}
+
+	if *jwks == "" {
+		logger.Warn().Msg("no JSON Web Key Set configured, the api is read only")
+	} else {
+		// a key set may sign tokens for other issuers and audiences too
+		if *issuer == "" || *audience == "" {
+			logger.Fatal().Msg("-auth.issuer and -auth.audience are required with -auth.jwks")
+		}
+		keys, err := ht.NewKeySet(*jwks, *refresh)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("unable to load JSON Web Key Set")
+		}
+		env.auth = ht.NewAuthenticator(ht.AuthConfig{
+			Keys:     keys,
+			Issuer:   *issuer,
+			Audience: *audience,
+		})
+	}
+
+	if *panicHook != "" {
+		sink := ht.NewWebhookSink(*panicHook)
+		sink.OnError = func(err error) {
+			logger.Error().Err(err).Msg("unable to report panic")
+		}
+		env.errorSink = sink
+	}
+
 	var srv http.Server
 
 	logger = logger.With().Str("transport", "http").Logger()
@@ -98,6 +404,15 @@ func (env *Env) Handler(logger zerolog.Logger) http.Handler {
 
 	r := chi.NewRouter()
 
+	// first, so every request is counted
+	if env.metrics != nil {
+		r.Use(env.metrics.http.Handler)
+	}
+	// before the logger, so log lines have the trace id
+	if env.tracer != nil {
+		r.Use(ht.Tracing(env.tracer))
+	}
+
 	// Basic CORS
 	// for more ideas, see: https://developer.github.com/v3/#cross-origin-resource-sharing
 	cors := cors.New(cors.Options{
@@ -105,32 +420,67 @@ func (env *Env) Handler(logger zerolog.Logger) http.Handler {
 		AllowedOrigins: []string{"https://www.pleasantplacesblog.com", "https://pleasantplacesblog.com"},
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", "If-None-Match", "Traceparent", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Link", "ETag", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Traceparent"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	})
 	r.Use(cors.Handler)
 
-	r.Use(ht.NewLogger(logger))
+	// before the logger and limiter, so both see the client's address
+	r.Use(ht.ClientIP(env.proxies))
+	// before the logger, so log lines have the request id
 	r.Use(middleware.RequestID)
+	r.Use(ht.NewLogger(logger))
+	// after both, so panics are logged and answered with the request id
+	r.Use(ht.Recoverer(env.errorSink))
+
+	auth := env.auth
+	if auth == nil {
+		auth = ht.NewAuthenticator(ht.AuthConfig{})
+	}
+	r.Use(auth.Handler)
 	/*r.Use(hlog.RemoteAddrHandler("ip"))
 	r.Use(hlog.RequestIDHandler("req_id","Request-Id"))
 	r.Use(hlog.MethodHandler("method"))
 	r.Use(hlog.UserAgentHandler("user_agent"))
 	r.Use(hlog.URLHandler("url"))*/
 
+	// health checks and scrapes are not limited, so monitoring keeps working
+	// for clients that are
 	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("healthy"))
 	})
 
-	r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("test"))
+	if env.metrics != nil {
+		r.Method(http.MethodGet, "/metrics", env.metrics.registry)
+	}
+
+	r.Group(func(r chi.Router) {
+		// after auth, so signed in users are limited by who they are
+		if env.limiter != nil {
+			r.Use(env.limiter.Handler)
+		}
+		// after the limiter, so requests over budget are not looked up
+		r.Use(env.CurrentUserCtx)
+
+		r.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("test"))
+		})
+		mount := func(path string, h http.Handler) {
+			r.Mount(path, render.CacheControl(env.cacheControl[path])(h))
+		}
+		mount("/posts", postRouter(env))
+		mount("/user", userRouter(env))
+		mount("/comments", commentRouter(env))
+		mount("/replies", replyRouter(env))
+		mount("/moderation", moderationRouter(env))
+		// the local store is served by the api itself
+		if dir, ok := env.blobs.(*blob.Dir); ok {
+			r.Handle("/media/files/*", http.StripPrefix("/media/files/", dir.Handler()))
+		}
+		mount("/media", mediaRouter(env))
 	})
-	r.Mount("/posts", postRouter(env))
-	r.Mount("/user", userRouter(env))
-	r.Mount("/comments", commentRouter(env))
-	r.Mount("/replies", replyRouter(env))
 	return r
 
 }
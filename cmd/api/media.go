@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/corymhall/blog-backend-go/pkg/thumbnail"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+)
+
+// BlobStore keeps the bytes of uploaded media. pkg/blob has a local
+// directory store for development and an S3 store for production.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns where clients fetch the blob key from.
+	URL(key string) string
+}
+
+const (
+	// defaultMaxMediaSize is the largest upload accepted unless configured
+	// otherwise.
+	defaultMaxMediaSize = 10 << 20
+	// maxMediaPixels bounds the dimensions of an upload, so a small file
+	// cannot decode to an enormous image.
+	maxMediaPixels = 50000000
+	// thumbnailSize is the longest side of a thumbnail.
+	thumbnailSize = 320
+)
+
+// mediaTypes maps the content types accepted for upload to the extension
+// they are stored with.
+var mediaTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+var (
+	errNoMediaStore   = errors.New("media uploads are not configured")
+	errMediaTooLarge  = errors.New("upload is too large")
+	errMediaType      = errors.New("file must be a jpeg, png or gif image")
+	errMediaDimension = fmt.Errorf("image may have at most %d pixels", maxMediaPixels)
+)
+
+func mediaRouter(env *Env) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.GetMediaList(w, rq, logger)
+	})
+	r.With(Require(PermWritePosts)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.UploadMedia(w, rq, logger)
+	})
+
+	r.Route("/{mediaID}", func(r chi.Router) {
+		r.Use(env.MediaCtx)
+		r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			GetMedia(w, rq, logger)
+		})
+		r.With(Require(PermWritePosts)).Delete("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.DeleteMedia(w, rq, logger)
+		})
+	})
+
+	return r
+}
+
+func (env *Env) MediaCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		var media *models.Media
+		var err error
+
+		if mediaID := chi.URLParam(rq, "mediaID"); mediaID != "" {
+			media, err = env.db.DBGetMedia(rq.Context(), mediaID)
+		} else {
+			render.Render(w, rq, render.ErrNotFound, logger)
+			return
+		}
+		if err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		ctx := context.WithValue(rq.Context(), "media", media)
+		next.ServeHTTP(w, rq.WithContext(ctx))
+	})
+}
+
+// maxUpload returns the largest upload accepted.
+func (env *Env) maxUpload() int64 {
+	if env.maxMediaSize > 0 {
+		return env.maxMediaSize
+	}
+	return defaultMaxMediaSize
+}
+
+// UploadMedia stores the image sent as the multipart field file, along with
+// a thumbnail of it. The optional fields alt and caption describe it.
+func (env *Env) UploadMedia(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	if env.blobs == nil {
+		render.Render(w, rq, render.ErrServiceUnavailable(errNoMediaStore), logger)
+		return
+	}
+
+	// leave room for the other fields and the multipart framing
+	max := env.maxUpload()
+	body := &limitedBody{ReadCloser: rq.Body, n: max + 64<<10}
+	rq.Body = body
+	if err := rq.ParseMultipartForm(1 << 20); err != nil {
+		// the multipart reader may wrap what the body returned
+		if body.exceeded {
+			err = errMediaTooLarge
+		}
+		render.Render(w, rq, errUpload(err, max), logger)
+		return
+	}
+	defer rq.MultipartForm.RemoveAll()
+
+	file, header, err := rq.FormFile("file")
+	if err != nil {
+		render.Render(w, rq, errUpload(err, max), logger)
+		return
+	}
+	defer file.Close()
+	if header.Size > max {
+		render.Render(w, rq, errUpload(errMediaTooLarge, max), logger)
+		return
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		render.Render(w, rq, errUpload(err, max), logger)
+		return
+	}
+
+	media := &models.Media{
+		Alt:     rq.FormValue("alt"),
+		Caption: rq.FormValue("caption"),
+		Size:    int64(len(data)),
+	}
+	var v render.Validation
+	v.MaxLength("alt", media.Alt, 500)
+	v.MaxLength("caption", media.Caption, 1000)
+	if err := v.Err(); err != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+		return
+	}
+
+	// the type is sniffed from the content, whatever the client claims
+	media.ContentType = http.DetectContentType(data)
+	ext, ok := mediaTypes[media.ContentType]
+	if !ok {
+		render.Render(w, rq, render.ErrUnsupportedMediaType(errMediaType), logger)
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		render.Render(w, rq, render.ErrUnsupportedMediaType(errMediaType), logger)
+		return
+	}
+	if config.Width*config.Height > maxMediaPixels {
+		render.Render(w, rq, render.ErrRequestEntityTooLarge(errMediaDimension), logger)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		render.Render(w, rq, render.ErrUnsupportedMediaType(errMediaType), logger)
+		return
+	}
+	media.Width, media.Height = config.Width, config.Height
+
+	thumb, thumbType, err := encodeThumbnail(img, media.ContentType)
+	if err != nil {
+		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+		return
+	}
+
+	media.UploadedDate = env.now()
+	media.ID = env.newID(media.UploadedDate)
+	media.User = currentUser(rq)
+	media.Key = "media/" + media.ID + ext
+	media.ThumbnailKey = "media/" + media.ID + "_thumb" + mediaTypes[thumbType]
+	media.URL = env.blobs.URL(media.Key)
+	media.ThumbnailURL = env.blobs.URL(media.ThumbnailKey)
+
+	ctx := rq.Context()
+	if err := env.blobs.Put(ctx, media.Key, data, media.ContentType); err != nil {
+		render.Render(w, rq, render.ErrServiceUnavailable(err), logger)
+		return
+	}
+	if err := env.blobs.Put(ctx, media.ThumbnailKey, thumb, thumbType); err != nil {
+		env.deleteBlobs(ctx, logger, media.Key)
+		render.Render(w, rq, render.ErrServiceUnavailable(err), logger)
+		return
+	}
+	if err := env.db.DBCreateMedia(ctx, media); err != nil {
+		// nothing refers to the blobs without their record
+		env.deleteBlobs(ctx, logger, media.Key, media.ThumbnailKey)
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	render.Status(rq, http.StatusCreated)
+	render.Render(w, rq, &MediaPayload{Media: media}, logger)
+}
+
+// limitedBody reads at most n bytes of a request body, failing with
+// errMediaTooLarge once the body turns out to be longer.
+type limitedBody struct {
+	io.ReadCloser
+	n        int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errMediaTooLarge
+	}
+	// read one byte more than allowed to tell a body of exactly n bytes
+	// from a longer one
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.n {
+		b.n -= int64(n)
+		return n, err
+	}
+	n, b.n, b.exceeded = int(b.n), 0, true
+	return n, errMediaTooLarge
+}
+
+// errUpload returns the response for an upload that could not be read.
+func errUpload(err error, max int64) render.Renderer {
+	if err == errMediaTooLarge {
+		return render.ErrRequestEntityTooLarge(fmt.Errorf("file may be at most %d bytes", max))
+	}
+	if err == http.ErrMissingFile {
+		var v render.Validation
+		v.Add("file", "is required")
+		return render.ErrInvalidRequest(v.Err())
+	}
+	return render.ErrInvalidRequest(err)
+}
+
+// encodeThumbnail returns a thumbnail of img and its content type. Photos
+// stay jpegs, anything else becomes a png to keep its transparency.
+func encodeThumbnail(img image.Image, contentType string) ([]byte, string, error) {
+	thumb := thumbnail.Fit(img, thumbnailSize)
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err := png.Encode(&buf, thumb)
+	return buf.Bytes(), "image/png", err
+}
+
+// deleteBlobs removes blobs left behind by a failed upload or delete. It is
+// best effort, so failures are only logged.
+func (env *Env) deleteBlobs(ctx context.Context, logger zerolog.Logger, keys ...string) {
+	for _, key := range keys {
+		if err := env.blobs.Delete(ctx, key); err != nil {
+			logger.Warn().Err(err).Str("key", key).Msg("unable to delete blob")
+		}
+	}
+}
+
+func GetMedia(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	media := rq.Context().Value("media").(*models.Media)
+
+	if err := render.Render(w, rq, &MediaPayload{Media: media}, logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
+func (env *Env) GetMediaList(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	media, err := env.db.DBListMedia(rq.Context())
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, m := range media {
+		list = append(list, &MediaPayload{Media: m})
+	}
+	if err := render.RenderList(w, rq, list, logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
+// DeleteMedia removes the media record and then its blobs. Posts showing it
+// keep their image_location until they are next updated.
+func (env *Env) DeleteMedia(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	media := rq.Context().Value("media").(*models.Media)
+
+	if err := env.db.DBDeleteMedia(rq.Context(), media.ID); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	if env.blobs != nil {
+		env.deleteBlobs(rq.Context(), logger, media.Key, media.ThumbnailKey)
+	}
+	render.NoContent(w, rq)
+}
+
+// attachMedia checks the media post names exists and points the post's
+// image_location at it. It returns a validation error when it does not.
+func (env *Env) attachMedia(ctx context.Context, post *models.Post) error {
+	if post.MediaID == "" {
+		return nil
+	}
+	media, err := env.db.DBGetMedia(ctx, post.MediaID)
+	if err == models.ErrNotFound {
+		var v render.Validation
+		v.Add("post.media_id", "must name uploaded media")
+		return v.Err()
+	}
+	if err != nil {
+		return err
+	}
+	post.ImageLocation = media.URL
+	return nil
+}
+
+type MediaPayload struct {
+	Media *models.Media `json:"media"`
+}
+
+func (p *MediaPayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/metrics"
+)
+
+// Metrics are what the api exports at /metrics: requests by route, calls to
+// the datastore by method and how well the datastore cache does.
+type Metrics struct {
+	registry *metrics.Registry
+	http     *ht.HTTPMetrics
+
+	datastoreDuration *metrics.HistogramVec
+	datastoreErrors   *metrics.Vec
+}
+
+// NewMetrics returns the api's metrics, all at zero.
+func NewMetrics() *Metrics {
+	reg := metrics.NewRegistry()
+	return &Metrics{
+		registry: reg,
+		http:     ht.NewHTTPMetrics(reg),
+		datastoreDuration: reg.NewHistogram("datastore_request_duration_seconds",
+			"How long calls to the datastore took, by Datastore method.",
+			metrics.DefBuckets, "method"),
+		datastoreErrors: reg.NewCounter("datastore_errors_total",
+			"Calls to the datastore that failed, by Datastore method and kind of error.",
+			"method", "kind"),
+	}
+}
+
+// Instrument returns db reporting the latency and errors of each call to m.
+func (m *Metrics) Instrument(db models.Datastore) models.Datastore {
+	return &models.Instrumented{Datastore: db, Observe: m.observeDatastore}
+}
+
+func (m *Metrics) observeDatastore(ctx context.Context, method string, start time.Time, err error) {
+	m.datastoreDuration.With(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.datastoreErrors.With(method, errorKind(err)).Inc()
+	}
+}
+
+// errorKind names the kind of a datastore error. Errors are not labeled by
+// their message, as messages may hold IDs.
+func errorKind(err error) string {
+	switch err {
+	case models.ErrNotFound:
+		return "not_found"
+	case models.ErrConflict:
+		return "conflict"
+	case models.ErrUnavailable:
+		return "unavailable"
+	case models.ErrInvalidCursor:
+		return "invalid_cursor"
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "timeout"
+	}
+	return "internal"
+}
+
+// ObserveCache exports the hit and miss counters of c.
+func (m *Metrics) ObserveCache(c *models.Cache) {
+	counter := func(name, help string, count func(models.CacheStats) uint64) {
+		m.registry.NewCounterFunc(name, help, []string{"method"}, func(emit func(float64, ...string)) {
+			for method, stats := range c.Stats() {
+				emit(float64(count(stats)), method)
+			}
+		})
+	}
+	counter("datastore_cache_hits_total", "Datastore reads served from the cache, by Datastore method.",
+		func(s models.CacheStats) uint64 { return s.Hits })
+	counter("datastore_cache_misses_total", "Datastore reads the cache could not serve, by Datastore method.",
+		func(s models.CacheStats) uint64 { return s.Misses })
+	counter("datastore_cache_shared_total", "Datastore reads that missed and waited for an identical read, by Datastore method.",
+		func(s models.CacheStats) uint64 { return s.Shared })
+}
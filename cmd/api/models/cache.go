@@ -0,0 +1,573 @@
+package models
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is how many results a Cache keeps unless configured
+// otherwise.
+const DefaultCacheSize = 1000
+
+// CacheTTLs sets how long each kind of read is cached. A zero TTL turns
+// caching off for those reads.
+type CacheTTLs struct {
+	// Posts is for pages of posts, DBGetPosts.
+	Posts time.Duration
+	// Post is for single posts, DBGetPost.
+	Post time.Duration
+	// Comments is for the comments and replies of a post, DBGetComments and
+	// DBGetReplies.
+	Comments time.Duration
+	// Users is for DBGetUser and DBGetUserByOwner.
+	Users time.Duration
+	// Media is for DBGetMedia and DBListMedia.
+	Media time.Duration
+}
+
+// DefaultCacheTTLs suit a blog whose posts change a few times a week. Writes
+// made through the Cache invalidate what they change straight away, so
+// TTLs only bound how long writes made elsewhere, e.g. by another instance
+// of the api, take to show.
+var DefaultCacheTTLs = CacheTTLs{
+	Posts:    5 * time.Minute,
+	Post:     5 * time.Minute,
+	Comments: 30 * time.Second,
+	Users:    10 * time.Minute,
+	Media:    10 * time.Minute,
+}
+
+// CacheConfig configures a Cache.
+type CacheConfig struct {
+	// Size bounds how many results are kept, the least recently used are
+	// evicted first. When zero DefaultCacheSize is used.
+	Size int
+	TTL  CacheTTLs
+	// Now is the clock entries expire by. When nil time.Now is used.
+	Now func() time.Time
+}
+
+// CacheStats counts how the reads of one Datastore method were served.
+type CacheStats struct {
+	// Hits were served from the cache.
+	Hits uint64 `json:"hits"`
+	// Misses were not. Each either loaded the result from the wrapped
+	// Datastore or, when Shared, waited for an identical read already
+	// loading it.
+	Misses uint64 `json:"misses"`
+	Shared uint64 `json:"shared"`
+}
+
+// Cache is a read-through cache in front of another Datastore. Reads of
+// posts, comments, replies, users and media are cached for their TTL in a
+// size bounded LRU, and concurrent identical reads that miss share a single
+// call to the wrapped Datastore. Writes invalidate exactly the cached reads
+// they change, whether they succeed or not, as a failed write may mean the
+// cache is stale. Everything else goes straight to the wrapped Datastore.
+//
+// Like MemoryDB, items are copied on the way in and out so callers never
+// share state with the cache.
+type Cache struct {
+	Datastore
+
+	cfg CacheConfig
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List
+	flights map[cacheKey]*flight
+	stats   map[string]*CacheStats
+	// gen counts invalidations, so a load that raced with one does not
+	// store what may be a stale result.
+	gen uint64
+}
+
+var _ Datastore = (*Cache)(nil)
+
+// NewCache returns a Cache in front of db.
+func NewCache(db Datastore, cfg CacheConfig) *Cache {
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultCacheSize
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Cache{
+		Datastore: db,
+		cfg:       cfg,
+		entries:   map[cacheKey]*list.Element{},
+		lru:       list.New(),
+		flights:   map[cacheKey]*flight{},
+		stats:     map[string]*CacheStats{},
+	}
+}
+
+// cacheKey identifies a read by the method and its arguments.
+type cacheKey struct {
+	method string
+	a, b   string
+	n      int
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	value   interface{}
+	expires time.Time
+}
+
+var errAbandonedLoad = errors.New("models: cache load was abandoned")
+
+// flight is a load of a missing result that identical reads wait for.
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// Stats returns the counters of each cached method, by method name.
+func (c *Cache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := map[string]CacheStats{}
+	for method, s := range c.stats {
+		stats[method] = *s
+	}
+	return stats
+}
+
+// Len returns how many results are cached.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) counters(method string) *CacheStats {
+	s, ok := c.stats[method]
+	if !ok {
+		s = &CacheStats{}
+		c.stats[method] = s
+	}
+	return s
+}
+
+// load returns the cached result of key, or calls fetch and caches what it
+// returns for ttl. Errors are not cached. The result is owned by the cache,
+// callers must copy it before handing it out.
+func (c *Cache) load(ctx context.Context, key cacheKey, ttl time.Duration, fetch func() (interface{}, error)) (interface{}, error) {
+	if ttl <= 0 {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	stats := c.counters(key.method)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.cfg.Now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			stats.Hits++
+			c.mu.Unlock()
+			return e.value, nil
+		}
+		c.remove(el)
+	}
+	stats.Misses++
+
+	if f, ok := c.flights[key]; ok {
+		stats.Shared++
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		// the read that loaded it gave up, which is no reason for this one to
+		if (f.err == context.Canceled || f.err == context.DeadlineExceeded) && ctx.Err() == nil {
+			return c.load(ctx, key, ttl, fetch)
+		}
+		return f.value, f.err
+	}
+	f := &flight{done: make(chan struct{}), err: errAbandonedLoad}
+	c.flights[key] = f
+	gen := c.gen
+	c.mu.Unlock()
+
+	// release those waiting even if fetch panics
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, key)
+		if f.err == nil && gen == c.gen {
+			c.add(key, f.value, ttl)
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+	f.value, f.err = fetch()
+
+	return f.value, f.err
+}
+
+// add caches value under key, evicting the least recently used results to
+// stay within size. c.mu must be held.
+func (c *Cache) add(key cacheKey, value interface{}, ttl time.Duration) {
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: c.cfg.Now().Add(ttl)})
+	for c.lru.Len() > c.cfg.Size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops a cached result. c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// invalidate drops the results of the reads keys name.
+func (c *Cache) invalidate(keys ...cacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for _, key := range keys {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// invalidateWhere drops the results of method that match.
+func (c *Cache) invalidateWhere(method string, match func(key cacheKey, value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	for key, el := range c.entries {
+		if key.method == method && match(key, el.Value.(*cacheEntry).value) {
+			c.remove(el)
+		}
+	}
+}
+
+func postsKey(q PostQuery) cacheKey {
+	author := q.Author
+	if author == "" {
+		author = DefaultAuthor
+	}
+	return cacheKey{method: "DBGetPosts", a: author, b: q.Cursor, n: q.Limit}
+}
+
+func postKey(postID string) cacheKey {
+	return cacheKey{method: "DBGetPost", a: postID}
+}
+
+func commentsKey(postID string) cacheKey {
+	return cacheKey{method: "DBGetComments", a: postID}
+}
+
+func repliesKey(postID, commentID string) cacheKey {
+	return cacheKey{method: "DBGetReplies", a: postID, b: commentID}
+}
+
+func userKey(userID string) cacheKey {
+	return cacheKey{method: "DBGetUser", a: userID}
+}
+
+func ownerUserKey(owner *User) cacheKey {
+	return cacheKey{method: "DBGetUserByOwner", a: ownerKey(owner)}
+}
+
+func mediaKey(mediaID string) cacheKey {
+	return cacheKey{method: "DBGetMedia", a: mediaID}
+}
+
+var mediaListKey = cacheKey{method: "DBListMedia"}
+
+// invalidatePost drops the post and every page of posts it is on. When
+// listed is set it also drops every page of its author's posts, as a new
+// or removed post moves the others between pages.
+func (c *Cache) invalidatePost(postID, author string, listed bool) {
+	if author == "" {
+		author = DefaultAuthor
+	}
+	c.invalidate(postKey(postID))
+	c.invalidateWhere("DBGetPosts", func(key cacheKey, value interface{}) bool {
+		if listed && key.a == author {
+			return true
+		}
+		for _, post := range value.(*PostPage).Posts {
+			if post.ID == postID {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (c *Cache) DBGetPosts(ctx context.Context, q PostQuery) (*PostPage, error) {
+	v, err := c.load(ctx, postsKey(q), c.cfg.TTL.Posts, func() (interface{}, error) {
+		return c.Datastore.DBGetPosts(ctx, q)
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := *v.(*PostPage)
+	page.Posts = clonePosts(page.Posts)
+	return &page, nil
+}
+
+func (c *Cache) DBGetPost(ctx context.Context, postID string) (*Post, error) {
+	v, err := c.load(ctx, postKey(postID), c.cfg.TTL.Post, func() (interface{}, error) {
+		return c.Datastore.DBGetPost(ctx, postID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clonePost(v.(*Post)), nil
+}
+
+func (c *Cache) DBCreatePost(ctx context.Context, post *Post) error {
+	defer c.invalidatePost(post.ID, post.Author, true)
+	return c.Datastore.DBCreatePost(ctx, post)
+}
+
+func (c *Cache) DBUpdatePost(ctx context.Context, post *Post) error {
+	defer c.invalidatePost(post.ID, post.Author, false)
+	return c.Datastore.DBUpdatePost(ctx, post)
+}
+
+func (c *Cache) DBDeletePost(ctx context.Context, post *Post) error {
+	defer func() {
+		c.invalidatePost(post.ID, post.Author, true)
+		c.invalidate(commentsKey(post.ID))
+		c.invalidateWhere("DBGetReplies", func(key cacheKey, _ interface{}) bool { return key.a == post.ID })
+	}()
+	return c.Datastore.DBDeletePost(ctx, post)
+}
+
+func (c *Cache) DBGetUser(ctx context.Context, userID string) (*User, error) {
+	v, err := c.load(ctx, userKey(userID), c.cfg.TTL.Users, func() (interface{}, error) {
+		return c.Datastore.DBGetUser(ctx, userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneUser(v.(*User)), nil
+}
+
+func (c *Cache) DBGetUserByOwner(ctx context.Context, owner *User) (*User, error) {
+	v, err := c.load(ctx, ownerUserKey(owner), c.cfg.TTL.Users, func() (interface{}, error) {
+		return c.Datastore.DBGetUserByOwner(ctx, owner)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneUser(v.(*User)), nil
+}
+
+func (c *Cache) DBCreateUser(ctx context.Context, user *User) error {
+	defer func() {
+		c.invalidate(userKey(user.ID), ownerUserKey(user))
+		// the user may have been stored under another identity before
+		c.invalidateWhere("DBGetUserByOwner", func(_ cacheKey, value interface{}) bool { return value.(*User).ID == user.ID })
+	}()
+	return c.Datastore.DBCreateUser(ctx, user)
+}
+
+func (c *Cache) DBGetComments(ctx context.Context, postID string) ([]*Comment, error) {
+	v, err := c.load(ctx, commentsKey(postID), c.cfg.TTL.Comments, func() (interface{}, error) {
+		return c.Datastore.DBGetComments(ctx, postID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneComments(v.([]*Comment)), nil
+}
+
+func (c *Cache) DBCreateComment(ctx context.Context, comment *Comment) error {
+	defer c.invalidate(commentsKey(comment.PostID))
+	return c.Datastore.DBCreateComment(ctx, comment)
+}
+
+func (c *Cache) DBDeleteComment(ctx context.Context, postID, commentID string) error {
+	defer c.invalidate(commentsKey(postID), repliesKey(postID, commentID))
+	return c.Datastore.DBDeleteComment(ctx, postID, commentID)
+}
+
+func (c *Cache) DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (*Comment, error) {
+	defer c.invalidate(commentsKey(postID))
+	return c.Datastore.DBSetCommentStatus(ctx, postID, commentID, status)
+}
+
+func (c *Cache) DBGetReplies(ctx context.Context, postID, commentID string) ([]*Reply, error) {
+	v, err := c.load(ctx, repliesKey(postID, commentID), c.cfg.TTL.Comments, func() (interface{}, error) {
+		return c.Datastore.DBGetReplies(ctx, postID, commentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneReplies(v.([]*Reply)), nil
+}
+
+func (c *Cache) DBCreateReply(ctx context.Context, reply *Reply) error {
+	defer c.invalidate(repliesKey(reply.PostID, reply.CommentID))
+	return c.Datastore.DBCreateReply(ctx, reply)
+}
+
+func (c *Cache) DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	defer c.invalidate(repliesKey(postID, commentID))
+	return c.Datastore.DBDeleteReply(ctx, postID, commentID, replyDate)
+}
+
+func (c *Cache) DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	defer c.invalidate(repliesKey(postID, commentID))
+	return c.Datastore.DBTombstoneReply(ctx, postID, commentID, replyDate)
+}
+
+func (c *Cache) DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (*Reply, error) {
+	defer c.invalidate(repliesKey(postID, commentID))
+	return c.Datastore.DBSetReplyStatus(ctx, postID, commentID, replyDate, status)
+}
+
+// invalidateTarget drops the cached reads that show the reaction counts of
+// target.
+func (c *Cache) invalidateTarget(target ReactionTarget) {
+	if target.CommentID != "" {
+		c.invalidate(commentsKey(target.PostID))
+		return
+	}
+	c.invalidatePost(target.PostID, "", false)
+}
+
+func (c *Cache) DBAddReaction(ctx context.Context, target ReactionTarget, user *User, kind string) error {
+	defer c.invalidateTarget(target)
+	return c.Datastore.DBAddReaction(ctx, target, user, kind)
+}
+
+func (c *Cache) DBRemoveReaction(ctx context.Context, target ReactionTarget, user *User) error {
+	defer c.invalidateTarget(target)
+	return c.Datastore.DBRemoveReaction(ctx, target, user)
+}
+
+func (c *Cache) DBGetMedia(ctx context.Context, mediaID string) (*Media, error) {
+	v, err := c.load(ctx, mediaKey(mediaID), c.cfg.TTL.Media, func() (interface{}, error) {
+		return c.Datastore.DBGetMedia(ctx, mediaID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneMedia(v.(*Media)), nil
+}
+
+func (c *Cache) DBListMedia(ctx context.Context) ([]*Media, error) {
+	v, err := c.load(ctx, mediaListKey, c.cfg.TTL.Media, func() (interface{}, error) {
+		return c.Datastore.DBListMedia(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := []*Media{}
+	for _, media := range v.([]*Media) {
+		list = append(list, cloneMedia(media))
+	}
+	return list, nil
+}
+
+func (c *Cache) DBCreateMedia(ctx context.Context, media *Media) error {
+	defer c.invalidate(mediaKey(media.ID), mediaListKey)
+	return c.Datastore.DBCreateMedia(ctx, media)
+}
+
+func (c *Cache) DBDeleteMedia(ctx context.Context, mediaID string) error {
+	defer c.invalidate(mediaKey(mediaID), mediaListKey)
+	return c.Datastore.DBDeleteMedia(ctx, mediaID)
+}
+
+// The clone functions deep copy cached and stored items, so callers may
+// change what they are handed, e.g. by binding a request body into it.
+
+func cloneUser(user *User) *User {
+	if user == nil {
+		return nil
+	}
+	u := *user
+	return &u
+}
+
+func cloneCounts(counts ReactionCounts) ReactionCounts {
+	if counts == nil {
+		return nil
+	}
+	c := ReactionCounts{}
+	for kind, n := range counts {
+		c[kind] = n
+	}
+	return c
+}
+
+func cloneSpam(spam *SpamCheck) *SpamCheck {
+	if spam == nil {
+		return nil
+	}
+	s := *spam
+	s.Reasons = append([]string(nil), spam.Reasons...)
+	return &s
+}
+
+func clonePost(post *Post) *Post {
+	p := *post
+	p.User = cloneUser(post.User)
+	p.Reactions = cloneCounts(post.Reactions)
+	return &p
+}
+
+func clonePosts(posts []*Post) []*Post {
+	list := make([]*Post, 0, len(posts))
+	for _, post := range posts {
+		list = append(list, clonePost(post))
+	}
+	return list
+}
+
+func cloneComment(comment *Comment) *Comment {
+	c := *comment
+	c.User = cloneUser(comment.User)
+	c.Spam = cloneSpam(comment.Spam)
+	c.Reactions = cloneCounts(comment.Reactions)
+	return &c
+}
+
+func cloneComments(comments []*Comment) []*Comment {
+	list := make([]*Comment, 0, len(comments))
+	for _, comment := range comments {
+		list = append(list, cloneComment(comment))
+	}
+	return list
+}
+
+func cloneReply(reply *Reply) *Reply {
+	r := *reply
+	r.User = cloneUser(reply.User)
+	r.Spam = cloneSpam(reply.Spam)
+	return &r
+}
+
+func cloneReplies(replies []*Reply) []*Reply {
+	list := make([]*Reply, 0, len(replies))
+	for _, reply := range replies {
+		list = append(list, cloneReply(reply))
+	}
+	return list
+}
+
+func cloneMedia(media *Media) *Media {
+	m := *media
+	m.User = cloneUser(media.User)
+	return &m
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingDB counts the reads that reach it. When block is set DBGetPost
+// waits for it to be closed.
+type countingDB struct {
+	*MemoryDB
+
+	mu    sync.Mutex
+	calls map[string]int
+	block chan struct{}
+}
+
+func newCountingDB() *countingDB {
+	return &countingDB{MemoryDB: NewMemoryDB(), calls: map[string]int{}}
+}
+
+func (db *countingDB) count(method string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.calls[method]++
+}
+
+func (db *countingDB) called(method string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.calls[method]
+}
+
+func (db *countingDB) DBGetPost(ctx context.Context, postID string) (*Post, error) {
+	db.count("DBGetPost")
+	if db.block != nil {
+		<-db.block
+	}
+	return db.MemoryDB.DBGetPost(ctx, postID)
+}
+
+func (db *countingDB) DBGetPosts(ctx context.Context, q PostQuery) (*PostPage, error) {
+	db.count("DBGetPosts")
+	return db.MemoryDB.DBGetPosts(ctx, q)
+}
+
+func (db *countingDB) DBGetComments(ctx context.Context, postID string) ([]*Comment, error) {
+	db.count("DBGetComments")
+	return db.MemoryDB.DBGetComments(ctx, postID)
+}
+
+func TestCacheHits(t *testing.T) {
+	ctx := context.Background()
+	db := newCountingDB()
+	db.DBCreatePost(ctx, &Post{ID: "1", Title: "Title 1", User: &User{DisplayName: "User 1"}, Reactions: ReactionCounts{"like": 1}})
+
+	now := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+	c := NewCache(db, CacheConfig{TTL: DefaultCacheTTLs, Now: func() time.Time { return now }})
+
+	post, err := c.DBGetPost(ctx, "1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// callers may change what they are handed
+	post.Title, post.User.DisplayName, post.Reactions["like"] = "changed", "changed", 100
+
+	post, _ = c.DBGetPost(ctx, "1")
+	if post.Title != "Title 1" || post.User.DisplayName != "User 1" || post.Reactions["like"] != 1 {
+		t.Errorf("expected the cached post to be unchanged, got %+v %+v %v", post, post.User, post.Reactions)
+	}
+	if calls := db.called("DBGetPost"); calls != 1 {
+		t.Errorf("expected 1 read of the datastore, got %d", calls)
+	}
+	if stats := c.Stats()["DBGetPost"]; stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got %+v", stats)
+	}
+
+	now = now.Add(DefaultCacheTTLs.Post)
+	c.DBGetPost(ctx, "1")
+	if calls := db.called("DBGetPost"); calls != 2 {
+		t.Errorf("expected an expired post to be read again, got %d reads", calls)
+	}
+
+	if _, err := c.DBGetPost(ctx, "2"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	c.DBGetPost(ctx, "2")
+	if calls := db.called("DBGetPost"); calls != 4 {
+		t.Errorf("expected errors not to be cached, got %d reads", calls)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	db := newCountingDB()
+	for _, id := range []string{"1", "2", "3"} {
+		db.DBCreatePost(ctx, &Post{ID: id})
+	}
+	c := NewCache(db, CacheConfig{Size: 2, TTL: DefaultCacheTTLs})
+
+	c.DBGetPost(ctx, "1")
+	c.DBGetPost(ctx, "2")
+	c.DBGetPost(ctx, "1")
+	c.DBGetPost(ctx, "3")
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cached results, got %d", c.Len())
+	}
+
+	c.DBGetPost(ctx, "1")
+	if calls := db.called("DBGetPost"); calls != 3 {
+		t.Errorf("expected the recently used post to stay cached, got %d reads", calls)
+	}
+	c.DBGetPost(ctx, "2")
+	if calls := db.called("DBGetPost"); calls != 4 {
+		t.Errorf("expected the least recently used post to be evicted, got %d reads", calls)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	db := newCountingDB()
+	db.DBCreatePost(ctx, &Post{ID: "1", Author: DefaultAuthor})
+	db.DBCreatePost(ctx, &Post{ID: "2", Author: "other"})
+	c := NewCache(db, CacheConfig{TTL: DefaultCacheTTLs})
+
+	// reads fills the cache, and returns how many reads of each method
+	// reached the datastore
+	reads := func() map[string]int {
+		before := map[string]int{}
+		for _, m := range []string{"DBGetPost", "DBGetPosts", "DBGetComments"} {
+			before[m] = db.called(m)
+		}
+		c.DBGetPosts(ctx, PostQuery{})
+		c.DBGetPosts(ctx, PostQuery{Author: "other"})
+		c.DBGetPost(ctx, "1")
+		c.DBGetPost(ctx, "2")
+		c.DBGetComments(ctx, "1")
+		c.DBGetComments(ctx, "2")
+		for m := range before {
+			before[m] = db.called(m) - before[m]
+		}
+		return before
+	}
+	reads()
+
+	cases := []struct {
+		Name     string
+		Write    func()
+		Expected map[string]int
+	}{
+		{"nothing changed", func() {}, map[string]int{"DBGetPost": 0, "DBGetPosts": 0, "DBGetComments": 0}},
+		{"comment", func() { c.DBCreateComment(ctx, &Comment{ID: "1", PostID: "1"}) }, map[string]int{"DBGetPost": 0, "DBGetPosts": 0, "DBGetComments": 1}},
+		{"update", func() { c.DBUpdatePost(ctx, &Post{ID: "1", Author: DefaultAuthor}) }, map[string]int{"DBGetPost": 1, "DBGetPosts": 1, "DBGetComments": 0}},
+		{"new post", func() { c.DBCreatePost(ctx, &Post{ID: "3", Author: "other"}) }, map[string]int{"DBGetPost": 0, "DBGetPosts": 1, "DBGetComments": 0}},
+		{"reaction", func() {
+			c.DBAddReaction(ctx, PostTarget(&Post{ID: "2"}), &User{ProviderID: "p", UID: "u"}, ReactionLike)
+		}, map[string]int{"DBGetPost": 1, "DBGetPosts": 1, "DBGetComments": 0}},
+		{"failed update", func() { c.DBUpdatePost(ctx, &Post{ID: "1", Author: DefaultAuthor, Version: 100}) }, map[string]int{"DBGetPost": 1, "DBGetPosts": 1, "DBGetComments": 0}},
+	}
+
+	for _, c := range cases {
+		c.Write()
+		got := reads()
+		for m, n := range c.Expected {
+			if got[m] != n {
+				t.Errorf("%s: expected %d reads of %s, got %d", c.Name, n, m, got[m])
+			}
+		}
+	}
+}
+
+func TestCacheSharesLoads(t *testing.T) {
+	ctx := context.Background()
+	db := newCountingDB()
+	db.DBCreatePost(ctx, &Post{ID: "1"})
+	db.block = make(chan struct{})
+	c := NewCache(db, CacheConfig{TTL: DefaultCacheTTLs})
+
+	const readers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if post, err := c.DBGetPost(ctx, "1"); err != nil || post.ID != "1" {
+				t.Errorf("expected post 1, got %v %v", post, err)
+			}
+		}()
+	}
+	for c.Stats()["DBGetPost"].Misses < readers {
+		time.Sleep(time.Millisecond)
+	}
+	close(db.block)
+	wg.Wait()
+
+	if calls := db.called("DBGetPost"); calls != 1 {
+		t.Errorf("expected the readers to share 1 read, got %d", calls)
+	}
+	if stats := c.Stats()["DBGetPost"]; stats.Shared != readers-1 {
+		t.Errorf("expected %d shared reads, got %+v", readers-1, stats)
+	}
+}
+
+func TestCacheInvalidatesLoads(t *testing.T) {
+	ctx := context.Background()
+	db := newCountingDB()
+	db.DBCreatePost(ctx, &Post{ID: "1", Title: "Title 1"})
+	db.block = make(chan struct{})
+	c := NewCache(db, CacheConfig{TTL: DefaultCacheTTLs})
+
+	done := make(chan struct{})
+	go func() {
+		c.DBGetPost(ctx, "1")
+		close(done)
+	}()
+	for c.Stats()["DBGetPost"].Misses < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// the update lands while the read is loading what may be the old post
+	c.DBUpdatePost(ctx, &Post{ID: "1", Title: "Title 2"})
+	close(db.block)
+	<-done
+
+	if post, _ := c.DBGetPost(ctx, "1"); post.Title != "Title 2" {
+		t.Errorf("expected the updated post, got %v", post.Title)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -9,14 +10,21 @@ import (
 )
 
 type Comment struct {
-	User        *User     `json:"user"`
-	ID          string    `json:"id"`
-	PostID      string    `json:"post_id"`
-	CommentText string    `json:"comment_text"`
-	CommentDate time.Time `json:"comment_date"`
+	User        *User      `json:"user"`
+	ID          string     `json:"id"`
+	PostID      string     `json:"post_id"`
+	CommentText string     `json:"comment_text"`
+	CommentDate time.Time  `json:"comment_date"`
+	Status      string     `json:"status,omitempty"`
+	Spam        *SpamCheck `json:"spam,omitempty"`
+
+	Reactions ReactionCounts `json:"reactions,omitempty"`
 }
 
-func (db *DB) DBGetComments(postID string) ([]*Comment, error) {
+func (db *DB) DBGetComments(ctx context.Context, postID string) ([]*Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	comments := []*Comment{}
 	input := &dynamodb.QueryInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
@@ -29,9 +37,9 @@ func (db *DB) DBGetComments(postID string) ([]*Comment, error) {
 		TableName: aws.String("Comments"),
 	}
 
-	result, err := db.Svc.Query(input)
+	result, err := db.Svc.QueryWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &comments); err != nil {
 		return nil, err
@@ -40,17 +48,70 @@ func (db *DB) DBGetComments(postID string) ([]*Comment, error) {
 	return comments, nil
 }
 
-func (db *DB) DBCreateComment(comment *Comment) error {
+// DBCreateComment stores comment. It also records who made it, so
+// DBHasApprovedComment can find their comments.
+func (db *DB) DBCreateComment(ctx context.Context, comment *Comment) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	item, err := dynamodbattribute.MarshalMap(comment)
 	if err != nil {
 		return err
 	}
-	_, err = db.Svc.PutItem(&dynamodb.PutItemInput{
+	if owner := ownerKey(comment.User); owner != "" {
+		item["owner"] = &dynamodb.AttributeValue{S: aws.String(owner)}
+	}
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		Item:      item,
 		TableName: aws.String("Comments"),
 	})
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
+
+// DBDeleteComment removes the comment with commentID from postID and every
+// reply and reaction made to it. Deleting a comment that does not exist is
+// not an error.
+func (db *DB) DBDeleteComment(ctx context.Context, postID, commentID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	comments, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(postID),
+			},
+			":v2": {
+				S: aws.String(commentID),
+			},
+		},
+		KeyConditionExpression: aws.String("post_id = :v1"),
+		FilterExpression:       aws.String("#id = :v2"),
+		ProjectionExpression:   aws.String("post_id, comment_date"),
+		TableName:              aws.String("Comments"),
+	})
+	if err != nil {
+		return err
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+
+	if err := db.deleteReplies(ctx, postID, commentID); err != nil {
+		return err
+	}
+	if err := db.deleteReactions(ctx, ReactionTarget{PostID: postID, CommentID: commentID}); err != nil {
+		return err
+	}
+
+	keys := []map[string]*dynamodb.AttributeValue{}
+	for _, comment := range comments {
+		keys = append(keys, keyOf(comment, "post_id", "comment_date"))
+	}
+	return db.batchDelete(ctx, "Comments", keys)
+}
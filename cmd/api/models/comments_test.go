@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -45,7 +46,7 @@ func TestDBGetComments(t *testing.T) {
 		d := DB{
 			Svc: mockedQuery{Resp: c.Resp},
 		}
-		items, err := d.DBGetComments("1")
+		items, err := d.DBGetComments(context.Background(), "1")
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
@@ -83,7 +84,7 @@ func TestDBCreateComment(t *testing.T) {
 		d := DB{
 			Svc: mockedPutItem{Resp: c.Resp},
 		}
-		err := d.DBCreateComment(&attribute)
+		err := d.DBCreateComment(context.Background(), &attribute)
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
@@ -1,22 +1,135 @@
 package models
 
 import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
 type DB struct {
 	Svc dynamodbiface.DynamoDBAPI
+
+	// Timeout bounds each Datastore call, including every DynamoDB request
+	// it makes. Zero leaves calls bounded only by the caller's context.
+	Timeout time.Duration
 }
 
+// Datastore is implemented by every storage backend. Each call takes the
+// context of the request it is made for, so a client going away or the server
+// shutting down abandons the work.
 type Datastore interface {
-	DBGetPosts() ([]*Post, error)
-	DBGetPost(postID string) (*Post, error)
-	DBCreatePost(post *Post) error
-	DBUpdatePost(post *Post) error
-	DBGetUser(userID string) (*User, error)
-	DBCreateUser(user *User) error
-	DBGetComments(postID string) ([]*Comment, error)
-	DBGetReplies(postID, commentID string) ([]*Reply, error)
-	DBCreateComment(comment *Comment) error
-	DBCreateReply(reply *Reply) error
+	DBGetPosts(ctx context.Context, q PostQuery) (*PostPage, error)
+	DBGetPost(ctx context.Context, postID string) (*Post, error)
+	DBCreatePost(ctx context.Context, post *Post) error
+	DBUpdatePost(ctx context.Context, post *Post) error
+	DBDeletePost(ctx context.Context, post *Post) error
+	DBGetUser(ctx context.Context, userID string) (*User, error)
+	DBGetUserByOwner(ctx context.Context, owner *User) (*User, error)
+	DBCreateUser(ctx context.Context, user *User) error
+	DBGetComments(ctx context.Context, postID string) ([]*Comment, error)
+	DBGetReplies(ctx context.Context, postID, commentID string) ([]*Reply, error)
+	DBCreateComment(ctx context.Context, comment *Comment) error
+	DBDeleteComment(ctx context.Context, postID, commentID string) error
+	DBCreateReply(ctx context.Context, reply *Reply) error
+	DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) error
+	DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) error
+	DBGetCommentsByStatus(ctx context.Context, status string) ([]*Comment, error)
+	DBGetRepliesByStatus(ctx context.Context, status string) ([]*Reply, error)
+	DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (*Comment, error)
+	DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (*Reply, error)
+	DBHasApprovedComment(ctx context.Context, user *User) (bool, error)
+	DBAddReaction(ctx context.Context, target ReactionTarget, user *User, kind string) error
+	DBRemoveReaction(ctx context.Context, target ReactionTarget, user *User) error
+	DBGetUserReactions(ctx context.Context, user *User, targets []ReactionTarget) ([]string, error)
+	DBGetMedia(ctx context.Context, mediaID string) (*Media, error)
+	DBListMedia(ctx context.Context) ([]*Media, error)
+	DBCreateMedia(ctx context.Context, media *Media) error
+	DBDeleteMedia(ctx context.Context, mediaID string) error
+}
+
+// withTimeout derives the context a single Datastore call runs under.
+func (db *DB) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.Timeout)
+}
+
+// maxBatchWrite is the most requests DynamoDB accepts in one BatchWriteItem call.
+const maxBatchWrite = 25
+
+// maxBatchAttempts bounds how often unprocessed items are resubmitted before
+// batchDelete gives up.
+const maxBatchAttempts = 8
+
+var errUnprocessedItems = errors.New("models: batch write left unprocessed items")
+
+// queryAll runs input until DynamoDB stops returning a LastEvaluatedKey and
+// returns every item it read.
+func (db *DB) queryAll(ctx context.Context, input *dynamodb.QueryInput) ([]map[string]*dynamodb.AttributeValue, error) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for {
+		res, err := db.Svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		items = append(items, res.Items...)
+		if len(res.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
+
+// batchDelete removes every item identified by keys from table, splitting the
+// work into BatchWriteItem calls and resubmitting anything DynamoDB reports as
+// unprocessed.
+func (db *DB) batchDelete(ctx context.Context, table string, keys []map[string]*dynamodb.AttributeValue) error {
+	for start := 0; start < len(keys); start += maxBatchWrite {
+		end := start + maxBatchWrite
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		requests := []*dynamodb.WriteRequest{}
+		for _, key := range keys[start:end] {
+			requests = append(requests, &dynamodb.WriteRequest{
+				DeleteRequest: &dynamodb.DeleteRequest{Key: key},
+			})
+		}
+
+		pending := map[string][]*dynamodb.WriteRequest{table: requests}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == maxBatchAttempts {
+				return errUnprocessedItems
+			}
+			if attempt > 0 {
+				select {
+				case <-time.After(time.Duration(attempt*50) * time.Millisecond):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			res, err := db.Svc.BatchWriteItemWithContext(ctx, &dynamodb.BatchWriteItemInput{
+				RequestItems: pending,
+			})
+			if err != nil {
+				return translateError(err)
+			}
+			pending = res.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+// keyOf copies the named key attributes out of item.
+func keyOf(item map[string]*dynamodb.AttributeValue, names ...string) map[string]*dynamodb.AttributeValue {
+	key := map[string]*dynamodb.AttributeValue{}
+	for _, name := range names {
+		key[name] = item[name]
+	}
+	return key
 }
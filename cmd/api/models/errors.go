@@ -0,0 +1,49 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+var (
+	// ErrNotFound is returned when the requested item does not exist.
+	ErrNotFound = errors.New("models: item not found")
+
+	// ErrConflict is returned when a conditional write fails because the item
+	// was changed by someone else since it was read.
+	ErrConflict = errors.New("models: item was modified by another request")
+
+	// ErrUnavailable is returned when the datastore cannot serve the request
+	// right now, e.g. because it is throttling us. Retrying later may succeed.
+	ErrUnavailable = errors.New("models: datastore unavailable")
+)
+
+// isConditionFailed reports whether err is DynamoDB rejecting a conditional write.
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// translateError maps the DynamoDB errors callers can act on to the errors
+// above. Anything else is returned unchanged.
+func translateError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	switch aerr.Code() {
+	case dynamodb.ErrCodeConditionalCheckFailedException:
+		return ErrConflict
+	case dynamodb.ErrCodeProvisionedThroughputExceededException,
+		"RequestLimitExceeded",
+		"ThrottlingException":
+		return ErrUnavailable
+	case dynamodb.ErrCodeResourceNotFoundException:
+		// the table or index itself is missing, which no client can fix
+		return ErrUnavailable
+	}
+	return err
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestTranslateError(t *testing.T) {
+	other := errors.New("some other error")
+	cases := []struct {
+		Err      error
+		Expected error
+	}{
+		{awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil), ErrConflict},
+		{awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "", nil), ErrUnavailable},
+		{awserr.New("ThrottlingException", "", nil), ErrUnavailable},
+		{awserr.New(dynamodb.ErrCodeResourceNotFoundException, "", nil), ErrUnavailable},
+		{other, other},
+	}
+
+	for _, c := range cases {
+		if got := translateError(c.Err); got != c.Expected {
+			t.Errorf("expected %v, got %v", c.Expected, got)
+		}
+	}
+}
+
+func TestDBNotFound(t *testing.T) {
+	d := DB{
+		Svc: mockedQuery{},
+	}
+	if _, err := d.DBGetPost(context.Background(), "1"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+
+	d = DB{
+		Svc: mockedGetItem{},
+	}
+	if _, err := d.DBGetUser(context.Background(), "1"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
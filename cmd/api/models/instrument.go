@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// Instrumented is a Datastore that reports each call to the Datastore it
+// wraps to Observe, with the call's context, the name of the method, when it
+// started and the error it returned, if any.
+type Instrumented struct {
+	Datastore Datastore
+	Observe   func(ctx context.Context, method string, start time.Time, err error)
+}
+
+var _ Datastore = (*Instrumented)(nil)
+
+// observe reports a call that started at start. It is deferred, so err
+// points at the call's result.
+func (i *Instrumented) observe(ctx context.Context, method string, start time.Time, err *error) {
+	i.Observe(ctx, method, start, *err)
+}
+
+func (i *Instrumented) DBGetPosts(ctx context.Context, q PostQuery) (page *PostPage, err error) {
+	defer i.observe(ctx, "DBGetPosts", time.Now(), &err)
+	return i.Datastore.DBGetPosts(ctx, q)
+}
+
+func (i *Instrumented) DBGetPost(ctx context.Context, postID string) (post *Post, err error) {
+	defer i.observe(ctx, "DBGetPost", time.Now(), &err)
+	return i.Datastore.DBGetPost(ctx, postID)
+}
+
+func (i *Instrumented) DBCreatePost(ctx context.Context, post *Post) (err error) {
+	defer i.observe(ctx, "DBCreatePost", time.Now(), &err)
+	return i.Datastore.DBCreatePost(ctx, post)
+}
+
+func (i *Instrumented) DBUpdatePost(ctx context.Context, post *Post) (err error) {
+	defer i.observe(ctx, "DBUpdatePost", time.Now(), &err)
+	return i.Datastore.DBUpdatePost(ctx, post)
+}
+
+func (i *Instrumented) DBDeletePost(ctx context.Context, post *Post) (err error) {
+	defer i.observe(ctx, "DBDeletePost", time.Now(), &err)
+	return i.Datastore.DBDeletePost(ctx, post)
+}
+
+func (i *Instrumented) DBGetUser(ctx context.Context, userID string) (user *User, err error) {
+	defer i.observe(ctx, "DBGetUser", time.Now(), &err)
+	return i.Datastore.DBGetUser(ctx, userID)
+}
+
+func (i *Instrumented) DBGetUserByOwner(ctx context.Context, owner *User) (user *User, err error) {
+	defer i.observe(ctx, "DBGetUserByOwner", time.Now(), &err)
+	return i.Datastore.DBGetUserByOwner(ctx, owner)
+}
+
+func (i *Instrumented) DBCreateUser(ctx context.Context, user *User) (err error) {
+	defer i.observe(ctx, "DBCreateUser", time.Now(), &err)
+	return i.Datastore.DBCreateUser(ctx, user)
+}
+
+func (i *Instrumented) DBGetComments(ctx context.Context, postID string) (comments []*Comment, err error) {
+	defer i.observe(ctx, "DBGetComments", time.Now(), &err)
+	return i.Datastore.DBGetComments(ctx, postID)
+}
+
+func (i *Instrumented) DBGetReplies(ctx context.Context, postID, commentID string) (replies []*Reply, err error) {
+	defer i.observe(ctx, "DBGetReplies", time.Now(), &err)
+	return i.Datastore.DBGetReplies(ctx, postID, commentID)
+}
+
+func (i *Instrumented) DBCreateComment(ctx context.Context, comment *Comment) (err error) {
+	defer i.observe(ctx, "DBCreateComment", time.Now(), &err)
+	return i.Datastore.DBCreateComment(ctx, comment)
+}
+
+func (i *Instrumented) DBDeleteComment(ctx context.Context, postID, commentID string) (err error) {
+	defer i.observe(ctx, "DBDeleteComment", time.Now(), &err)
+	return i.Datastore.DBDeleteComment(ctx, postID, commentID)
+}
+
+func (i *Instrumented) DBCreateReply(ctx context.Context, reply *Reply) (err error) {
+	defer i.observe(ctx, "DBCreateReply", time.Now(), &err)
+	return i.Datastore.DBCreateReply(ctx, reply)
+}
+
+func (i *Instrumented) DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) (err error) {
+	defer i.observe(ctx, "DBDeleteReply", time.Now(), &err)
+	return i.Datastore.DBDeleteReply(ctx, postID, commentID, replyDate)
+}
+
+func (i *Instrumented) DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) (err error) {
+	defer i.observe(ctx, "DBTombstoneReply", time.Now(), &err)
+	return i.Datastore.DBTombstoneReply(ctx, postID, commentID, replyDate)
+}
+
+func (i *Instrumented) DBGetCommentsByStatus(ctx context.Context, status string) (comments []*Comment, err error) {
+	defer i.observe(ctx, "DBGetCommentsByStatus", time.Now(), &err)
+	return i.Datastore.DBGetCommentsByStatus(ctx, status)
+}
+
+func (i *Instrumented) DBGetRepliesByStatus(ctx context.Context, status string) (replies []*Reply, err error) {
+	defer i.observe(ctx, "DBGetRepliesByStatus", time.Now(), &err)
+	return i.Datastore.DBGetRepliesByStatus(ctx, status)
+}
+
+func (i *Instrumented) DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (_ *Comment, err error) {
+	defer i.observe(ctx, "DBSetCommentStatus", time.Now(), &err)
+	return i.Datastore.DBSetCommentStatus(ctx, postID, commentID, status)
+}
+
+func (i *Instrumented) DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (_ *Reply, err error) {
+	defer i.observe(ctx, "DBSetReplyStatus", time.Now(), &err)
+	return i.Datastore.DBSetReplyStatus(ctx, postID, commentID, replyDate, status)
+}
+
+func (i *Instrumented) DBHasApprovedComment(ctx context.Context, user *User) (ok bool, err error) {
+	defer i.observe(ctx, "DBHasApprovedComment", time.Now(), &err)
+	return i.Datastore.DBHasApprovedComment(ctx, user)
+}
+
+func (i *Instrumented) DBAddReaction(ctx context.Context, target ReactionTarget, user *User, kind string) (err error) {
+	defer i.observe(ctx, "DBAddReaction", time.Now(), &err)
+	return i.Datastore.DBAddReaction(ctx, target, user, kind)
+}
+
+func (i *Instrumented) DBRemoveReaction(ctx context.Context, target ReactionTarget, user *User) (err error) {
+	defer i.observe(ctx, "DBRemoveReaction", time.Now(), &err)
+	return i.Datastore.DBRemoveReaction(ctx, target, user)
+}
+
+func (i *Instrumented) DBGetUserReactions(ctx context.Context, user *User, targets []ReactionTarget) (kinds []string, err error) {
+	defer i.observe(ctx, "DBGetUserReactions", time.Now(), &err)
+	return i.Datastore.DBGetUserReactions(ctx, user, targets)
+}
+
+func (i *Instrumented) DBGetMedia(ctx context.Context, mediaID string) (media *Media, err error) {
+	defer i.observe(ctx, "DBGetMedia", time.Now(), &err)
+	return i.Datastore.DBGetMedia(ctx, mediaID)
+}
+
+func (i *Instrumented) DBListMedia(ctx context.Context) (media []*Media, err error) {
+	defer i.observe(ctx, "DBListMedia", time.Now(), &err)
+	return i.Datastore.DBListMedia(ctx)
+}
+
+func (i *Instrumented) DBCreateMedia(ctx context.Context, media *Media) (err error) {
+	defer i.observe(ctx, "DBCreateMedia", time.Now(), &err)
+	return i.Datastore.DBCreateMedia(ctx, media)
+}
+
+func (i *Instrumented) DBDeleteMedia(ctx context.Context, mediaID string) (err error) {
+	defer i.observe(ctx, "DBDeleteMedia", time.Now(), &err)
+	return i.Datastore.DBDeleteMedia(ctx, mediaID)
+}
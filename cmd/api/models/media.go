@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Media is an uploaded image. The image and its thumbnail are kept in blob
+// storage under Key and ThumbnailKey; the rest describes them. Posts show
+// one by naming it in their MediaID.
+type Media struct {
+	ID           string    `json:"id"`
+	Key          string    `json:"key"`
+	URL          string    `json:"url"`
+	ThumbnailKey string    `json:"thumbnail_key"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Alt          string    `json:"alt,omitempty"`
+	Caption      string    `json:"caption,omitempty"`
+	User         *User     `json:"user,omitempty"`
+	UploadedDate time.Time `json:"uploaded_date"`
+}
+
+func (db *DB) DBGetMedia(ctx context.Context, mediaID string) (*Media, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.Svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(mediaID),
+			},
+		},
+		TableName: aws.String("Media"),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(res.Item) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var media Media
+	if err := dynamodbattribute.UnmarshalMap(res.Item, &media); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+// DBListMedia returns every uploaded image, newest first. The library is
+// expected to stay small enough to be read in one go.
+func (db *DB) DBListMedia(ctx context.Context) ([]*Media, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	media := []*Media{}
+	input := &dynamodb.ScanInput{
+		TableName: aws.String("Media"),
+	}
+	for {
+		res, err := db.Svc.ScanWithContext(ctx, input)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		page := []*Media{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(res.Items, &page); err != nil {
+			return nil, err
+		}
+		media = append(media, page...)
+		if len(res.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+
+	sort.Slice(media, func(i, j int) bool { return media[i].UploadedDate.After(media[j].UploadedDate) })
+	return media, nil
+}
+
+func (db *DB) DBCreateMedia(ctx context.Context, media *Media) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	item, err := dynamodbattribute.MarshalMap(media)
+	if err != nil {
+		return err
+	}
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String("Media"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+func (db *DB) DBDeleteMedia(ctx context.Context, mediaID string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.Svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(mediaID),
+			},
+		},
+		TableName: aws.String("Media"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func TestDBGetMedia(t *testing.T) {
+	media := &Media{
+		ID:           "1",
+		Key:          "media/1.png",
+		ThumbnailKey: "media/1_thumb.png",
+		ContentType:  "image/png",
+		Width:        640,
+		Height:       480,
+		Alt:          "a view",
+		UploadedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+	}
+	item, _ := dynamodbattribute.MarshalMap(media)
+
+	d := DB{
+		Svc: mockedGetItem{Resp: dynamodb.GetItemOutput{Item: item}},
+	}
+	got, err := d.DBGetMedia(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(got, media) {
+		t.Errorf("expected %v, got %v", media, got)
+	}
+
+	d.Svc = mockedGetItem{}
+	if _, err := d.DBGetMedia(context.Background(), "1"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestDBListMedia(t *testing.T) {
+	date := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+	items := []map[string]*dynamodb.AttributeValue{}
+	for i, id := range []string{"1", "3", "2"} {
+		item, _ := dynamodbattribute.MarshalMap(&Media{ID: id, UploadedDate: date.Add(time.Duration([]int{1, 3, 2}[i]) * time.Hour)})
+		items = append(items, item)
+	}
+
+	d := DB{
+		Svc: mockedScan{Resp: dynamodb.ScanOutput{Items: items}},
+	}
+	media, err := d.DBListMedia(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ids := []string{}
+	for _, m := range media {
+		ids = append(ids, m.ID)
+	}
+	if want := []string{"3", "2", "1"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("expected newest first %v, got %v", want, ids)
+	}
+}
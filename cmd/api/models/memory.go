@@ -0,0 +1,644 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDB is a Datastore that keeps everything in process memory. It is
+// meant for local development and tests where running DynamoDB is not
+// worth the trouble, and mirrors the ordering and key semantics of DB.
+// Items are deep copied on the way in and out so callers never share state,
+// and calls made with a context that is already done fail with its error.
+type MemoryDB struct {
+	mu       sync.RWMutex
+	posts    map[string]*Post
+	users    map[string]*User
+	comments map[string][]*Comment
+	replies  map[string][]*Reply
+	// reactions maps the key of each reaction target to how each owner
+	// reacted to it.
+	reactions map[string]map[string]string
+	media     map[string]*Media
+}
+
+var _ Datastore = (*MemoryDB)(nil)
+
+// NewMemoryDB returns an empty MemoryDB ready for use.
+func NewMemoryDB() *MemoryDB {
+	return &MemoryDB{
+		posts:     map[string]*Post{},
+		users:     map[string]*User{},
+		comments:  map[string][]*Comment{},
+		replies:   map[string][]*Reply{},
+		reactions: map[string]map[string]string{},
+		media:     map[string]*Media{},
+	}
+}
+
+// newerPost reports whether a sorts before b in a newest first listing.
+func newerPost(a, b *Post) bool {
+	if !a.PostedDate.Equal(b.PostedDate) {
+		return a.PostedDate.After(b.PostedDate)
+	}
+	return a.ID > b.ID
+}
+
+func postCursorFor(post *Post, prev bool) string {
+	c := postCursor{
+		Prev: prev,
+		Key: map[string]string{
+			"id":          post.ID,
+			"posted_date": post.PostedDate.Format(time.RFC3339Nano),
+			"author":      post.Author,
+		},
+	}
+	return c.String()
+}
+
+func (m *MemoryDB) DBGetPosts(ctx context.Context, q PostQuery) (*PostPage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	author := q.Author
+	if author == "" {
+		author = DefaultAuthor
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPostLimit
+	}
+	if limit > MaxPostLimit {
+		limit = MaxPostLimit
+	}
+
+	var cursor *postCursor
+	var from *Post
+	if q.Cursor != "" {
+		var err error
+		if cursor, err = decodePostCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+		if cursor.Key["author"] != author {
+			return nil, ErrInvalidCursor
+		}
+		date, err := time.Parse(time.RFC3339Nano, cursor.Key["posted_date"])
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		from = &Post{ID: cursor.Key["id"], PostedDate: date}
+	}
+
+	m.mu.RLock()
+	all := []*Post{}
+	for _, post := range m.posts {
+		if post.Author == author {
+			all = append(all, clonePost(post))
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(all, func(i, j int) bool { return newerPost(all[i], all[j]) })
+
+	// start and end bound the page within the newest first listing
+	start, end := 0, len(all)
+	switch {
+	case cursor == nil:
+	case cursor.Prev:
+		end = sort.Search(len(all), func(i int) bool { return !newerPost(all[i], from) })
+		start = end - limit
+		if start < 0 {
+			start = 0
+		}
+	default:
+		start = sort.Search(len(all), func(i int) bool { return newerPost(from, all[i]) })
+	}
+	if end-start > limit {
+		end = start + limit
+	}
+
+	page := &PostPage{Posts: all[start:end]}
+	if start == end {
+		return page, nil
+	}
+	if start > 0 {
+		page.Prev = postCursorFor(all[start], true)
+	}
+	if end < len(all) {
+		page.Next = postCursorFor(all[end-1], false)
+	}
+	return page, nil
+}
+
+func (m *MemoryDB) DBGetPost(ctx context.Context, postID string) (*Post, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	post, ok := m.posts[postID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clonePost(post), nil
+}
+
+func (m *MemoryDB) DBCreatePost(ctx context.Context, post *Post) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.posts[post.ID] = clonePost(post)
+	return nil
+}
+
+// DBUpdatePost applies the same version check as DB.DBUpdatePost.
+func (m *MemoryDB) DBUpdatePost(ctx context.Context, post *Post) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.posts[post.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.Version != post.Version {
+		return ErrConflict
+	}
+
+	p := clonePost(stored)
+	p.PostText = post.PostText
+	p.Title = post.Title
+	p.ImageLocation = post.ImageLocation
+	p.HomeText = post.HomeText
+	p.MediaID = post.MediaID
+	p.Version++
+	m.posts[post.ID] = p
+
+	*post = *clonePost(p)
+	return nil
+}
+
+func (m *MemoryDB) DBDeletePost(ctx context.Context, post *Post) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, comment := range m.comments[post.ID] {
+		delete(m.replies, replyKey(post.ID, comment.ID))
+		delete(m.reactions, ReactionTarget{PostID: post.ID, CommentID: comment.ID}.key())
+	}
+	delete(m.comments, post.ID)
+	delete(m.reactions, ReactionTarget{PostID: post.ID}.key())
+	delete(m.posts, post.ID)
+	return nil
+}
+
+func (m *MemoryDB) DBGetUser(ctx context.Context, userID string) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	user, ok := m.users[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneUser(user), nil
+}
+
+func (m *MemoryDB) DBGetUserByOwner(ctx context.Context, owner *User) (*User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	key := ownerKey(owner)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, user := range m.users {
+		if ownerKey(user) == key {
+			return cloneUser(user), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryDB) DBCreateUser(ctx context.Context, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[user.ID] = cloneUser(user)
+	return nil
+}
+
+// DBGetComments returns the comments on postID, oldest first.
+func (m *MemoryDB) DBGetComments(ctx context.Context, postID string) ([]*Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return cloneComments(m.comments[postID]), nil
+}
+
+// DBCreateComment stores comment, replacing any comment made on the same
+// post at the same time just as a PutItem on the Comments table would.
+func (m *MemoryDB) DBCreateComment(ctx context.Context, comment *Comment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := cloneComment(comment)
+	comments := m.comments[comment.PostID]
+	i := sort.Search(len(comments), func(i int) bool { return !comments[i].CommentDate.Before(c.CommentDate) })
+	if i < len(comments) && comments[i].CommentDate.Equal(c.CommentDate) {
+		comments[i] = c
+		return nil
+	}
+	comments = append(comments, nil)
+	copy(comments[i+1:], comments[i:])
+	comments[i] = c
+	m.comments[comment.PostID] = comments
+	return nil
+}
+
+func (m *MemoryDB) DBDeleteComment(ctx context.Context, postID, commentID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	comments := []*Comment{}
+	for _, comment := range m.comments[postID] {
+		if comment.ID != commentID {
+			comments = append(comments, comment)
+		}
+	}
+	m.comments[postID] = comments
+	delete(m.replies, replyKey(postID, commentID))
+	delete(m.reactions, ReactionTarget{PostID: postID, CommentID: commentID}.key())
+	return nil
+}
+
+// DBGetReplies returns the replies to commentID on postID, oldest first.
+func (m *MemoryDB) DBGetReplies(ctx context.Context, postID, commentID string) ([]*Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return cloneReplies(m.replies[replyKey(postID, commentID)]), nil
+}
+
+// DBCreateReply stores reply with the replies to the comment named by its
+// PostID and CommentID, setting its ID just as DB.DBCreateReply does.
+func (m *MemoryDB) DBCreateReply(ctx context.Context, reply *Reply) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reply.ID = replyKey(reply.PostID, reply.CommentID)
+	r := cloneReply(reply)
+	replies := m.replies[reply.ID]
+	i := sort.Search(len(replies), func(i int) bool { return !replies[i].ReplyDate.Before(r.ReplyDate) })
+	if i < len(replies) && replies[i].ReplyDate.Equal(r.ReplyDate) {
+		replies[i] = r
+		return nil
+	}
+	replies = append(replies, nil)
+	copy(replies[i+1:], replies[i:])
+	replies[i] = r
+	m.replies[reply.ID] = replies
+	return nil
+}
+
+func (m *MemoryDB) DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := replyKey(postID, commentID)
+	replies := []*Reply{}
+	for _, reply := range m.replies[key] {
+		if !reply.ReplyDate.Equal(replyDate) {
+			replies = append(replies, reply)
+		}
+	}
+	m.replies[key] = replies
+	return nil
+}
+
+func (m *MemoryDB) DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := replyKey(postID, commentID)
+	for i, reply := range m.replies[key] {
+		if reply.ReplyDate.Equal(replyDate) {
+			r := *reply
+			r.Deleted, r.ReplyText, r.User, r.Spam = true, "", nil, nil
+			m.replies[key][i] = &r
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// DBGetCommentsByStatus returns every comment in status, oldest first.
+func (m *MemoryDB) DBGetCommentsByStatus(ctx context.Context, status string) ([]*Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	comments := []*Comment{}
+	for _, all := range m.comments {
+		for _, comment := range all {
+			if comment.Status == status {
+				comments = append(comments, cloneComment(comment))
+			}
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].CommentDate.Before(comments[j].CommentDate) })
+	return comments, nil
+}
+
+// DBGetRepliesByStatus returns every reply in status, oldest first.
+func (m *MemoryDB) DBGetRepliesByStatus(ctx context.Context, status string) ([]*Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	replies := []*Reply{}
+	for _, all := range m.replies {
+		for _, reply := range all {
+			if reply.Status == status {
+				replies = append(replies, cloneReply(reply))
+			}
+		}
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].ReplyDate.Before(replies[j].ReplyDate) })
+	return replies, nil
+}
+
+func (m *MemoryDB) DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (*Comment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, comment := range m.comments[postID] {
+		if comment.ID == commentID {
+			c := *comment
+			c.Status = status
+			m.comments[postID][i] = &c
+			return cloneComment(comment), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryDB) DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (*Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := replyKey(postID, commentID)
+	for i, reply := range m.replies[key] {
+		if reply.ReplyDate.Equal(replyDate) {
+			r := *reply
+			r.Status = status
+			m.replies[key][i] = &r
+			return cloneReply(reply), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryDB) DBHasApprovedComment(ctx context.Context, user *User) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	owner := ownerKey(user)
+	if owner == "" {
+		return false, nil
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, all := range m.comments {
+		for _, comment := range all {
+			if comment.Status == StatusApproved && ownerKey(comment.User) == owner {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryDB) DBAddReaction(ctx context.Context, target ReactionTarget, user *User, kind string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owner := ownerKey(user)
+	if owner == "" {
+		return errAnonymousReaction
+	}
+	if _, ok := m.reactions[target.key()][owner]; ok {
+		return ErrConflict
+	}
+	if err := m.countReaction(target, kind, 1); err != nil {
+		return err
+	}
+	if m.reactions[target.key()] == nil {
+		m.reactions[target.key()] = map[string]string{}
+	}
+	m.reactions[target.key()][owner] = kind
+	return nil
+}
+
+func (m *MemoryDB) DBRemoveReaction(ctx context.Context, target ReactionTarget, user *User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	owner := ownerKey(user)
+	kind, ok := m.reactions[target.key()][owner]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.reactions[target.key()], owner)
+	return m.countReaction(target, kind, -1)
+}
+
+// countReaction adds n to the count of kind reactions to target. m.mu must
+// be held.
+func (m *MemoryDB) countReaction(target ReactionTarget, kind string, n int) error {
+	count := func(counts ReactionCounts) ReactionCounts {
+		// stored items are never changed in place, as callers may hold copies
+		c := ReactionCounts{}
+		for k, v := range counts {
+			c[k] = v
+		}
+		c[kind] += n
+		return c
+	}
+
+	if target.CommentID == "" {
+		stored, ok := m.posts[target.PostID]
+		if !ok {
+			return ErrNotFound
+		}
+		p := *stored
+		p.Reactions = count(p.Reactions)
+		m.posts[target.PostID] = &p
+		return nil
+	}
+
+	for i, comment := range m.comments[target.PostID] {
+		if comment.ID == target.CommentID {
+			c := *comment
+			c.Reactions = count(c.Reactions)
+			m.comments[target.PostID][i] = &c
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (m *MemoryDB) DBGetUserReactions(ctx context.Context, user *User, targets []ReactionTarget) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	kinds := make([]string, len(targets))
+	if owner := ownerKey(user); owner != "" {
+		for i, target := range targets {
+			kinds[i] = m.reactions[target.key()][owner]
+		}
+	}
+	return kinds, nil
+}
+
+func (m *MemoryDB) DBGetMedia(ctx context.Context, mediaID string) (*Media, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	media, ok := m.media[mediaID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneMedia(media), nil
+}
+
+// DBListMedia returns every uploaded image, newest first.
+func (m *MemoryDB) DBListMedia(ctx context.Context) ([]*Media, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	list := []*Media{}
+	for _, media := range m.media {
+		list = append(list, cloneMedia(media))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].UploadedDate.After(list[j].UploadedDate) })
+	return list, nil
+}
+
+func (m *MemoryDB) DBCreateMedia(ctx context.Context, media *Media) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.media[media.ID] = cloneMedia(media)
+	return nil
+}
+
+func (m *MemoryDB) DBDeleteMedia(ctx context.Context, mediaID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.media, mediaID)
+	return nil
+}
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryDBPosts(t *testing.T) {
+	db := NewMemoryDB()
+	for i, id := range []string{"1", "2", "3", "4", "5"} {
+		db.DBCreatePost(context.Background(), &Post{
+			ID:         id,
+			Author:     "author",
+			PostedDate: time.Date(2018, time.November, 10+i, 23, 0, 0, 0, time.UTC),
+		})
+	}
+	db.DBCreatePost(context.Background(), &Post{ID: "6", Author: "someone else"})
+
+	cases := []struct {
+		Name     string
+		Query    func(prev *PostPage) PostQuery
+		Expected []string
+		Next     bool
+		Prev     bool
+	}{
+		{"first page", func(*PostPage) PostQuery { return PostQuery{Author: "author", Limit: 2} }, []string{"5", "4"}, true, false},
+		{"next page", func(p *PostPage) PostQuery { return PostQuery{Author: "author", Limit: 2, Cursor: p.Next} }, []string{"3", "2"}, true, true},
+		{"last page", func(p *PostPage) PostQuery { return PostQuery{Author: "author", Limit: 2, Cursor: p.Next} }, []string{"1"}, false, true},
+		{"back a page", func(p *PostPage) PostQuery { return PostQuery{Author: "author", Limit: 2, Cursor: p.Prev} }, []string{"3", "2"}, true, true},
+		{"back to the start", func(p *PostPage) PostQuery { return PostQuery{Author: "author", Limit: 2, Cursor: p.Prev} }, []string{"5", "4"}, true, false},
+	}
+
+	page := &PostPage{}
+	for _, c := range cases {
+		var err error
+		page, err = db.DBGetPosts(context.Background(), c.Query(page))
+		if err != nil {
+			t.Fatalf("%s: %v, unexpected error", c.Name, err)
+		}
+		ids := []string{}
+		for _, p := range page.Posts {
+			ids = append(ids, p.ID)
+		}
+		if len(ids) != len(c.Expected) {
+			t.Fatalf("%s: expected %v, got %v", c.Name, c.Expected, ids)
+		}
+		for i := range ids {
+			if ids[i] != c.Expected[i] {
+				t.Errorf("%s: expected %v, got %v", c.Name, c.Expected, ids)
+			}
+		}
+		if (page.Next != "") != c.Next || (page.Prev != "") != c.Prev {
+			t.Errorf("%s: unexpected cursors next '%s' prev '%s'", c.Name, page.Next, page.Prev)
+		}
+	}
+}
+
+func TestMemoryDBUpdatePost(t *testing.T) {
+	db := NewMemoryDB()
+	db.DBCreatePost(context.Background(), &Post{ID: "1", Title: "title"})
+
+	first, _ := db.DBGetPost(context.Background(), "1")
+	second, _ := db.DBGetPost(context.Background(), "1")
+
+	first.Title = "first edit"
+	if err := db.DBUpdatePost(context.Background(), first); err != nil {
+		t.Fatalf("%v, unexpected error", err)
+	}
+	if first.Version != 1 {
+		t.Errorf("expected version 1, got %d", first.Version)
+	}
+
+	second.Title = "second edit"
+	if err := db.DBUpdatePost(context.Background(), second); err != ErrConflict {
+		t.Errorf("expected %v, got %v", ErrConflict, err)
+	}
+
+	if err := db.DBUpdatePost(context.Background(), &Post{ID: "2"}); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestMemoryDBThreads(t *testing.T) {
+	db := NewMemoryDB()
+	post := &Post{ID: "1"}
+	db.DBCreatePost(context.Background(), post)
+	for i, id := range []string{"b", "a"} {
+		db.DBCreateComment(context.Background(), &Comment{
+			ID:          id,
+			PostID:      "1",
+			CommentDate: time.Date(2018, time.November, 10-i, 23, 0, 0, 0, time.UTC),
+		})
+		db.DBCreateReply(context.Background(), &Reply{
+			PostID:    "1",
+			CommentID: id,
+			ReplyDate: time.Date(2018, time.November, 11, 23, 0, 0, 0, time.UTC),
+		})
+	}
+
+	comments, _ := db.DBGetComments(context.Background(), "1")
+	if len(comments) != 2 || comments[0].ID != "a" {
+		t.Fatalf("expected comments oldest first, got %v", comments)
+	}
+	if others, _ := db.DBGetComments(context.Background(), "2"); len(others) != 0 {
+		t.Errorf("expected no comments on another post, got %v", others)
+	}
+
+	db.DBDeleteComment(context.Background(), "1", "a")
+	if replies, _ := db.DBGetReplies(context.Background(), "1", "a"); len(replies) != 0 {
+		t.Errorf("expected replies to be deleted with their comment, got %v", replies)
+	}
+	if replies, _ := db.DBGetReplies(context.Background(), "1", "b"); len(replies) != 1 {
+		t.Errorf("expected 1 reply, got %v", replies)
+	}
+
+	db.DBDeletePost(context.Background(), post)
+	if _, err := db.DBGetPost(context.Background(), "1"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	if replies, _ := db.DBGetReplies(context.Background(), "1", "b"); len(replies) != 0 {
+		t.Errorf("expected replies to be deleted with their post, got %v", replies)
+	}
+}
+
+func TestMemoryDBUser(t *testing.T) {
+	db := NewMemoryDB()
+	if _, err := db.DBGetUser(context.Background(), "1"); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	db.DBCreateUser(context.Background(), &User{ID: "1", DisplayName: "User 1"})
+	if user, err := db.DBGetUser(context.Background(), "1"); err != nil || user.DisplayName != "User 1" {
+		t.Errorf("expected User 1, got %v, %v", user, err)
+	}
+	db.DBCreateUser(context.Background(), &User{ID: "2", UID: "abc", ProviderID: "google.com", Role: RoleAuthor})
+	if user, err := db.DBGetUserByOwner(context.Background(), &User{UID: "abc", ProviderID: "google.com"}); err != nil || user.ID != "2" {
+		t.Errorf("expected user 2, got %v, %v", user, err)
+	}
+	if _, err := db.DBGetUserByOwner(context.Background(), &User{UID: "abc", ProviderID: "github.com"}); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := db.DBGetUser(ctx, "1"); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestMemoryDBCopies(t *testing.T) {
+	db := NewMemoryDB()
+	post := &Post{ID: "1", User: &User{ID: "u1"}, Reactions: ReactionCounts{"like": 1}}
+	db.DBCreatePost(context.Background(), post)
+	post.User.Role = "admin"
+	post.Reactions["like"] = 5
+
+	comment := &Comment{ID: "c1", PostID: "1", User: &User{ID: "u1"}, Spam: &SpamCheck{Reasons: []string{"links"}}}
+	db.DBCreateComment(context.Background(), comment)
+	comment.Spam.Reasons[0] = "changed"
+
+	got, _ := db.DBGetPost(context.Background(), "1")
+	if got.User.Role != "" || got.Reactions["like"] != 1 {
+		t.Errorf("expected the stored post to be unchanged, got %+v %v", got.User, got.Reactions)
+	}
+	got.Reactions["like"] = 7
+	if again, _ := db.DBGetPost(context.Background(), "1"); again.Reactions["like"] != 1 {
+		t.Errorf("expected 1 like, got %v", again.Reactions)
+	}
+
+	comments, _ := db.DBGetComments(context.Background(), "1")
+	if comments[0].Spam.Reasons[0] != "links" {
+		t.Errorf("expected the stored comment to be unchanged, got %v", comments[0].Spam.Reasons)
+	}
+	comments[0].User.DisplayName = "changed"
+	if again, _ := db.DBGetComments(context.Background(), "1"); again[0].User.DisplayName != "" {
+		t.Errorf("expected the stored comment to be unchanged, got %+v", again[0].User)
+	}
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
@@ -18,6 +20,9 @@ type mockedScan struct {
 type mockedPutItem struct {
 	dynamodbiface.DynamoDBAPI
 	Resp dynamodb.PutItemOutput
+
+	// Items, when set, records every item put.
+	Items *[]map[string]*dynamodb.AttributeValue
 }
 
 type mockedGetItem struct {
@@ -25,18 +30,70 @@ type mockedGetItem struct {
 	Resp dynamodb.GetItemOutput
 }
 
-func (m mockedQuery) Query(in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+// mockedContext records the context its request was made with.
+type mockedContext struct {
+	dynamodbiface.DynamoDBAPI
+	Ctx *aws.Context
+}
+
+type mockedUpdateItem struct {
+	dynamodbiface.DynamoDBAPI
+	Resp dynamodb.UpdateItemOutput
+	Err  error
+}
+
+// mockedTables answers queries per table and records every delete it is asked to perform.
+type mockedTables struct {
+	dynamodbiface.DynamoDBAPI
+	Items   map[string][]map[string]*dynamodb.AttributeValue
+	Deleted map[string][]map[string]*dynamodb.AttributeValue
+}
+
+func (m mockedQuery) QueryWithContext(ctx aws.Context, in *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
 	return &m.Resp, nil
 }
 
-func (m mockedScan) Scan(in *dynamodb.ScanInput) (*dynamodb.ScanOutput, error) {
+func (m mockedScan) ScanWithContext(ctx aws.Context, in *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
 	return &m.Resp, nil
 }
 
-func (m mockedPutItem) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+func (m mockedPutItem) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if m.Items != nil {
+		*m.Items = append(*m.Items, in.Item)
+	}
 	return &m.Resp, nil
 }
 
-func (m mockedGetItem) GetItem(in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (m mockedGetItem) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
 	return &m.Resp, nil
 }
+
+func (m mockedUpdateItem) UpdateItemWithContext(ctx aws.Context, in *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &m.Resp, nil
+}
+
+func (m *mockedTables) QueryWithContext(ctx aws.Context, in *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{Items: m.Items[*in.TableName]}, nil
+}
+
+func (m *mockedTables) BatchWriteItemWithContext(ctx aws.Context, in *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for table, requests := range in.RequestItems {
+		for _, r := range requests {
+			m.Deleted[table] = append(m.Deleted[table], r.DeleteRequest.Key)
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (m *mockedTables) DeleteItemWithContext(ctx aws.Context, in *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	m.Deleted[*in.TableName] = append(m.Deleted[*in.TableName], in.Key)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func (m mockedContext) GetItemWithContext(ctx aws.Context, in *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	*m.Ctx = ctx
+	return &dynamodb.GetItemOutput{}, ctx.Err()
+}
@@ -0,0 +1,246 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Moderation states of a Comment or Reply. Only approved items are shown to
+// readers. Items stored before moderation existed have no status and count
+// as approved.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusSpam     = "spam"
+)
+
+// Statuses lists every valid moderation state.
+var Statuses = []string{StatusPending, StatusApproved, StatusRejected, StatusSpam}
+
+// IsApproved reports whether an item with status may be shown to readers.
+func IsApproved(status string) bool {
+	return status == "" || status == StatusApproved
+}
+
+// SpamCheck records what the spam checker concluded about a comment or reply
+// when it was submitted, for moderators to review.
+type SpamCheck struct {
+	Score   float64  `json:"score"`
+	Verdict string   `json:"verdict"`
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// ownerKey identifies the user a comment belongs to across identity providers.
+func ownerKey(user *User) string {
+	if user == nil || user.UID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s#%s", user.ProviderID, user.UID)
+}
+
+// DBGetCommentsByStatus returns every comment in status, oldest first.
+func (db *DB) DBGetCommentsByStatus(ctx context.Context, status string) ([]*Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	items, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(status),
+			},
+		},
+		KeyConditionExpression: aws.String("#status = :status"),
+		IndexName:              aws.String("status-comment_date-index"),
+		TableName:              aws.String("Comments"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	comments := []*Comment{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// DBGetRepliesByStatus returns every reply in status, oldest first.
+func (db *DB) DBGetRepliesByStatus(ctx context.Context, status string) ([]*Reply, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	items, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(status),
+			},
+		},
+		KeyConditionExpression: aws.String("#status = :status"),
+		IndexName:              aws.String("status-reply_date-index"),
+		TableName:              aws.String("Reply"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	replies := []*Reply{}
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &replies); err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// DBSetCommentStatus moves the comment with commentID on postID to status
+// and returns the comment as it was before.
+func (db *DB) DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (*Comment, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	comments, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(postID),
+			},
+			":v2": {
+				S: aws.String(commentID),
+			},
+		},
+		KeyConditionExpression: aws.String("post_id = :v1"),
+		FilterExpression:       aws.String("#id = :v2"),
+		ProjectionExpression:   aws.String("post_id, comment_date"),
+		TableName:              aws.String("Comments"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(comments) == 0 {
+		return nil, ErrNotFound
+	}
+
+	old, err := db.setStatus(ctx, "Comments", keyOf(comments[0], "post_id", "comment_date"), "post_id", status)
+	if err != nil {
+		return nil, err
+	}
+	comment := &Comment{}
+	if err := dynamodbattribute.UnmarshalMap(old, comment); err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// DBSetReplyStatus moves the reply made at replyDate to commentID on postID
+// to status and returns the reply as it was before.
+func (db *DB) DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (*Reply, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	date, err := dynamodbattribute.Marshal(replyDate)
+	if err != nil {
+		return nil, err
+	}
+	key := map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(replyKey(postID, commentID)),
+		},
+		"reply_date": date,
+	}
+	old, err := db.setStatus(ctx, "Reply", key, "id", status)
+	if err != nil {
+		return nil, err
+	}
+	reply := &Reply{}
+	if err := dynamodbattribute.UnmarshalMap(old, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// setStatus sets the status of the item with key in table, which must exist,
+// and returns the item as it was before. hashKey names the table's partition
+// key.
+func (db *DB) setStatus(ctx context.Context, table string, key map[string]*dynamodb.AttributeValue, hashKey, status string) (map[string]*dynamodb.AttributeValue, error) {
+	out, err := db.Svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key: key,
+		ExpressionAttributeNames: map[string]*string{
+			"#key":    aws.String(hashKey),
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {
+				S: aws.String(status),
+			},
+		},
+		UpdateExpression:    aws.String("SET #status = :status"),
+		ConditionExpression: aws.String("attribute_exists(#key)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+		TableName:           aws.String(table),
+	})
+	if isConditionFailed(err) {
+		// the item was deleted after we looked it up, or never existed
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return out.Attributes, nil
+}
+
+// DBHasApprovedComment reports whether user has made a comment that was
+// approved.
+func (db *DB) DBHasApprovedComment(ctx context.Context, user *User) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	owner := ownerKey(user)
+	if owner == "" {
+		return false, nil
+	}
+
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#owner":  aws.String("owner"),
+			"#status": aws.String("status"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {
+				S: aws.String(owner),
+			},
+			":status": {
+				S: aws.String(StatusApproved),
+			},
+		},
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		FilterExpression:       aws.String("#status = :status"),
+		IndexName:              aws.String("owner-comment_date-index"),
+		TableName:              aws.String("Comments"),
+	}
+	// stop at the first page holding an approved comment
+	for {
+		res, err := db.Svc.QueryWithContext(ctx, input)
+		if err != nil {
+			return false, translateError(err)
+		}
+		if len(res.Items) > 0 {
+			return true, nil
+		}
+		if len(res.LastEvaluatedKey) == 0 {
+			return false, nil
+		}
+		input.ExclusiveStartKey = res.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDBSetReplyStatus(t *testing.T) {
+	old := map[string]*dynamodb.AttributeValue{
+		"status":     {S: aws.String(StatusPending)},
+		"reply_text": {S: aws.String("text")},
+	}
+	cases := []struct {
+		Name     string
+		Err      error
+		Expected error
+		Previous string
+	}{
+		{"sets the status", nil, nil, StatusPending},
+		{"missing reply", awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil), ErrNotFound, ""},
+		{"throttled", awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil), ErrUnavailable, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d := DB{
+				Svc: mockedUpdateItem{Err: c.Err, Resp: dynamodb.UpdateItemOutput{Attributes: old}},
+			}
+			reply, err := d.DBSetReplyStatus(context.Background(), "1", "2", time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC), StatusApproved)
+			if err != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, err)
+			}
+			if err == nil && reply.Status != c.Previous {
+				t.Errorf("expected previous status %v, got %v", c.Previous, reply.Status)
+			}
+		})
+	}
+}
+
+func TestDBCreateCommentOwner(t *testing.T) {
+	put := []map[string]*dynamodb.AttributeValue{}
+	d := DB{
+		Svc: mockedPutItem{Items: &put},
+	}
+	d.DBCreateComment(context.Background(), &Comment{ID: "1", PostID: "1", User: &User{UID: "2", ProviderID: "google"}})
+	d.DBCreateComment(context.Background(), &Comment{ID: "2", PostID: "1"})
+
+	if owner := aws.StringValue(put[0]["owner"].S); owner != "google#2" {
+		t.Errorf("expected owner google#2, got %v", owner)
+	}
+	if _, ok := put[1]["owner"]; ok {
+		t.Errorf("expected no owner for an anonymous comment, got %v", put[1]["owner"])
+	}
+}
+
+func TestMemoryModeration(t *testing.T) {
+	db := NewMemoryDB()
+	ctx := context.Background()
+	user := &User{UID: "2", ProviderID: "google"}
+	db.DBCreateComment(ctx, &Comment{ID: "1", PostID: "1", User: user, Status: StatusPending,
+		CommentDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)})
+	db.DBCreateReply(ctx, &Reply{PostID: "1", CommentID: "1", Status: StatusPending,
+		ReplyDate: time.Date(2018, time.November, 11, 23, 0, 0, 0, time.UTC)})
+
+	if comments, _ := db.DBGetCommentsByStatus(ctx, StatusPending); len(comments) != 1 {
+		t.Errorf("expected 1 pending comment, got %v", comments)
+	}
+	if returning, _ := db.DBHasApprovedComment(ctx, user); returning {
+		t.Errorf("expected no approved comments yet")
+	}
+
+	if comment, err := db.DBSetCommentStatus(ctx, "1", "1", StatusApproved); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	} else if comment.Status != StatusPending {
+		t.Errorf("expected previous status %v, got %v", StatusPending, comment.Status)
+	}
+	if _, err := db.DBSetCommentStatus(ctx, "1", "2", StatusApproved); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	if returning, _ := db.DBHasApprovedComment(ctx, user); !returning {
+		t.Errorf("expected an approved comment")
+	}
+	if comments, _ := db.DBGetCommentsByStatus(ctx, StatusPending); len(comments) != 0 {
+		t.Errorf("expected an empty queue, got %v", comments)
+	}
+
+	if _, err := db.DBSetReplyStatus(ctx, "1", "1", time.Date(2018, time.November, 11, 23, 0, 0, 0, time.UTC), StatusSpam); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if replies, _ := db.DBGetRepliesByStatus(ctx, StatusSpam); len(replies) != 1 {
+		t.Errorf("expected 1 spam reply, got %v", replies)
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -17,36 +21,186 @@ type Post struct {
 	ImageLocation string    `json:"image_location"`
 	HomeText      string    `json:"home_text"`
 	User          *User     `json:"user,omitempty"`
+	Version       int64     `json:"version"`
+	// MediaID names the uploaded image the post shows. ImageLocation is set
+	// to its URL.
+	MediaID string `json:"media_id,omitempty"`
+
+	Reactions ReactionCounts `json:"reactions,omitempty"`
+}
+
+const (
+	// DefaultAuthor is the author whose posts are listed when a PostQuery
+	// does not name one.
+	DefaultAuthor = "Pleasant Places"
+	// DefaultPostLimit is the page size used when a PostQuery has no limit.
+	DefaultPostLimit = 10
+	// MaxPostLimit is the largest page size a PostQuery may ask for.
+	MaxPostLimit = 100
+)
+
+// ErrInvalidCursor is returned when a page cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("models: invalid cursor")
+
+// PostQuery selects one page of an author's posts, newest first.
+type PostQuery struct {
+	Author string
+	Limit  int
+	Cursor string
 }
 
-func (db *DB) DBGetPosts() ([]*Post, error) {
+// PostPage is one page of posts along with the cursors for the pages on
+// either side of it. A cursor is empty when there is no page in that direction.
+type PostPage struct {
+	Posts []*Post
+	Next  string
+	Prev  string
+}
+
+// postCursor is the decoded form of the opaque cursor handed to clients. It
+// holds the index key of the item to continue from and whether to walk
+// towards older posts (next) or newer ones (prev).
+type postCursor struct {
+	Prev bool              `json:"p,omitempty"`
+	Key  map[string]string `json:"k"`
+}
+
+var postIndexKey = []string{"id", "posted_date", "author"}
+
+func (c *postCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func encodePostCursor(item map[string]*dynamodb.AttributeValue, prev bool) string {
+	c := postCursor{Prev: prev, Key: map[string]string{}}
+	for _, name := range postIndexKey {
+		if v := item[name]; v != nil {
+			c.Key[name] = aws.StringValue(v.S)
+		}
+	}
+	return c.String()
+}
+
+func decodePostCursor(cursor string) (*postCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c postCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	for _, name := range postIndexKey {
+		if c.Key[name] == "" {
+			return nil, ErrInvalidCursor
+		}
+	}
+	return &c, nil
+}
+
+// DBGetPosts returns a page of q.Author's posts from the
+// author-posted_date-index, newest first. The cursors on the returned page are opaque to callers and
+// can be passed back in a later PostQuery to continue in either direction.
+func (db *DB) DBGetPosts(ctx context.Context, q PostQuery) (*PostPage, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	posts := []*Post{}
 
+	author := q.Author
+	if author == "" {
+		author = DefaultAuthor
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPostLimit
+	}
+	if limit > MaxPostLimit {
+		limit = MaxPostLimit
+	}
+
 	input := &dynamodb.QueryInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":v1": {
-				S: aws.String("Pleasant Places"),
+				S: aws.String(author),
 			},
 		},
 		KeyConditionExpression: aws.String("author = :v1"),
 		IndexName:              aws.String("author-posted_date-index"),
 		TableName:              aws.String("Posts"),
 		ScanIndexForward:       aws.Bool(false),
+		Limit:                  aws.Int64(int64(limit)),
 	}
-	res, err := db.Svc.Query(input)
+
+	var cursor *postCursor
+	if q.Cursor != "" {
+		var err error
+		if cursor, err = decodePostCursor(q.Cursor); err != nil {
+			return nil, err
+		}
+		// a cursor only makes sense for the listing it was issued from
+		if cursor.Key["author"] != author {
+			return nil, ErrInvalidCursor
+		}
+		input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{}
+		for name, value := range cursor.Key {
+			input.ExclusiveStartKey[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+		// walking back towards newer posts means reading the index forwards
+		input.ScanIndexForward = aws.Bool(cursor.Prev)
+	}
+
+	res, err := db.Svc.QueryWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
+	}
+
+	items := res.Items
+	more := len(res.LastEvaluatedKey) != 0
+	if cursor != nil && cursor.Prev {
+		// put the page back in newest first order
+		items = make([]map[string]*dynamodb.AttributeValue, len(res.Items))
+		for i, item := range res.Items {
+			items[len(items)-1-i] = item
+		}
 	}
 
-	if err := dynamodbattribute.UnmarshalListOfMaps(res.Items, &posts); err != nil {
+	if err := dynamodbattribute.UnmarshalListOfMaps(items, &posts); err != nil {
 		return nil, err
 	}
 
-	return posts, nil
+	page := &PostPage{Posts: posts}
+	if len(items) == 0 {
+		return page, nil
+	}
+
+	first, last := items[0], items[len(items)-1]
+	switch {
+	case cursor == nil:
+		if more {
+			page.Next = encodePostCursor(last, false)
+		}
+	case cursor.Prev:
+		page.Next = encodePostCursor(last, false)
+		if more {
+			page.Prev = encodePostCursor(first, true)
+		}
+	default:
+		page.Prev = encodePostCursor(first, true)
+		if more {
+			page.Next = encodePostCursor(last, false)
+		}
+	}
 
+	return page, nil
 }
 
-func (db *DB) DBGetPost(postID string) (*Post, error) {
+func (db *DB) DBGetPost(ctx context.Context, postID string) (*Post, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var post Post
 
 	input := &dynamodb.QueryInput{
@@ -59,9 +213,12 @@ func (db *DB) DBGetPost(postID string) (*Post, error) {
 		TableName:              aws.String("Posts"),
 	}
 
-	res, err := db.Svc.Query(input)
+	res, err := db.Svc.QueryWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
+	}
+	if len(res.Items) == 0 {
+		return nil, ErrNotFound
 	}
 	if err := dynamodbattribute.UnmarshalMap(res.Items[0], &post); err != nil {
 		return nil, err
@@ -70,21 +227,150 @@ func (db *DB) DBGetPost(postID string) (*Post, error) {
 	return &post, nil
 }
 
-func (db *DB) DBCreatePost(post *Post) error {
+func (db *DB) DBCreatePost(ctx context.Context, post *Post) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	item, err := dynamodbattribute.MarshalMap(post)
 	if err != nil {
 		return err
 	}
-	_, err = db.Svc.PutItem(&dynamodb.PutItemInput{
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		Item:      item,
 		TableName: aws.String("Posts"),
 	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// DBUpdatePost writes the editable fields of post back to the Posts table.
+// The write only succeeds if the stored version still matches post.Version,
+// so concurrent edits fail with ErrConflict instead of overwriting each other.
+// On success post is refreshed with the stored item, including the new version.
+func (db *DB) DBUpdatePost(ctx context.Context, post *Post) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	postedDate, err := dynamodbattribute.Marshal(post.PostedDate)
+	if err != nil {
+		return err
+	}
+	values, err := dynamodbattribute.MarshalMap(map[string]interface{}{
+		":post_text":      post.PostText,
+		":title":          post.Title,
+		":image_location": post.ImageLocation,
+		":home_text":      post.HomeText,
+		":media_id":       post.MediaID,
+		":version":        post.Version,
+		":one":            1,
+		":zero":           0,
+	})
 	if err != nil {
 		return err
 	}
+
+	// posts written before versioning was added have no version attribute
+	condition := "attribute_exists(#id) AND #version = :version"
+	if post.Version == 0 {
+		condition = "attribute_exists(#id) AND (attribute_not_exists(#version) OR #version = :version)"
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(post.ID),
+			},
+			"posted_date": postedDate,
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#id":             aws.String("id"),
+			"#post_text":      aws.String("post_text"),
+			"#title":          aws.String("title"),
+			"#image_location": aws.String("image_location"),
+			"#home_text":      aws.String("home_text"),
+			"#media_id":       aws.String("media_id"),
+			"#version":        aws.String("version"),
+		},
+		ExpressionAttributeValues: values,
+		UpdateExpression: aws.String("SET #post_text = :post_text, #title = :title, " +
+			"#image_location = :image_location, #home_text = :home_text, #media_id = :media_id, " +
+			"#version = if_not_exists(#version, :zero) + :one"),
+		ConditionExpression: aws.String(condition),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllNew),
+		TableName:           aws.String("Posts"),
+	}
+
+	res, err := db.Svc.UpdateItemWithContext(ctx, input)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if err := dynamodbattribute.UnmarshalMap(res.Attributes, post); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func (db *DB) DBUpdatePost(post *Post) error {
+// DBDeletePost removes post from the Posts table together with every comment,
+// reply and reaction left on it, so no orphaned threads remain.
+func (db *DB) DBDeletePost(ctx context.Context, post *Post) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	comments, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(post.ID),
+			},
+		},
+		KeyConditionExpression: aws.String("post_id = :v1"),
+		ProjectionExpression:   aws.String("post_id, comment_date, #id"),
+		TableName:              aws.String("Comments"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+
+	keys := []map[string]*dynamodb.AttributeValue{}
+	for _, comment := range comments {
+		if id := comment["id"]; id != nil {
+			if err := db.deleteReplies(ctx, post.ID, aws.StringValue(id.S)); err != nil {
+				return err
+			}
+			if err := db.deleteReactions(ctx, ReactionTarget{PostID: post.ID, CommentID: aws.StringValue(id.S)}); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, keyOf(comment, "post_id", "comment_date"))
+	}
+	if err := db.batchDelete(ctx, "Comments", keys); err != nil {
+		return err
+	}
+	if err := db.deleteReactions(ctx, ReactionTarget{PostID: post.ID}); err != nil {
+		return err
+	}
+
+	postedDate, err := dynamodbattribute.Marshal(post.PostedDate)
+	if err != nil {
+		return err
+	}
+	_, err = db.Svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(post.ID),
+			},
+			"posted_date": postedDate,
+		},
+		TableName: aws.String("Posts"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
 	return nil
 }
@@ -1,9 +1,11 @@
 package models
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -48,7 +50,7 @@ func TestDBGetPost(t *testing.T) {
 		d := DB{
 			Svc: mockedQuery{Resp: c.Resp},
 		}
-		items, err := d.DBGetPost("1")
+		items, err := d.DBGetPost(context.Background(), "1")
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
@@ -101,11 +103,11 @@ func TestDBGetPosts(t *testing.T) {
 		d := DB{
 			Svc: mockedQuery{Resp: c.Resp},
 		}
-		items, err := d.DBGetPosts()
+		page, err := d.DBGetPosts(context.Background(), PostQuery{})
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
-		for i, p := range items {
+		for i, p := range page.Posts {
 			if !comparePost(p, c.Expected[i]) {
 				t.Errorf("expected %v message, got %v", p, c.Expected[i])
 			}
@@ -113,6 +115,57 @@ func TestDBGetPosts(t *testing.T) {
 	}
 }
 
+func TestDBGetPostsCursor(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{}
+	for _, id := range []string{"3", "2"} {
+		item, _ := dynamodbattribute.MarshalMap(Post{
+			ID:         id,
+			Author:     "author",
+			PostedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+		})
+		items = append(items, item)
+	}
+	more := map[string]*dynamodb.AttributeValue{"id": items[1]["id"]}
+
+	d := DB{
+		Svc: mockedQuery{Resp: dynamodb.QueryOutput{Items: items, LastEvaluatedKey: more}},
+	}
+	first, err := d.DBGetPosts(context.Background(), PostQuery{Author: "author", Limit: 2})
+	if err != nil {
+		t.Fatalf("%d, unexpected error", err)
+	}
+	if first.Prev != "" || first.Next == "" {
+		t.Fatalf("expected only a next cursor on the first page, got next '%s' prev '%s'", first.Next, first.Prev)
+	}
+
+	c, err := decodePostCursor(first.Next)
+	if err != nil {
+		t.Fatalf("%d, unexpected error", err)
+	}
+	if c.Prev || c.Key["id"] != "2" || c.Key["author"] != "author" {
+		t.Errorf("expected cursor after post 2, got %v", c)
+	}
+
+	// reading backwards returns the items oldest first
+	d.Svc = mockedQuery{Resp: dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{items[1], items[0]}}}
+	back, err := d.DBGetPosts(context.Background(), PostQuery{Author: "author", Limit: 2, Cursor: encodePostCursor(items[1], true)})
+	if err != nil {
+		t.Fatalf("%d, unexpected error", err)
+	}
+	if back.Posts[0].ID != "3" || back.Prev != "" || back.Next == "" {
+		t.Errorf("expected newest first page with only a next cursor, got %v", back)
+	}
+
+	for _, q := range []PostQuery{
+		{Author: "author", Cursor: "not-a-cursor"},
+		{Author: "someone else", Cursor: first.Next},
+	} {
+		if _, err := d.DBGetPosts(context.Background(), q); err != ErrInvalidCursor {
+			t.Errorf("expected %v, got %v", ErrInvalidCursor, err)
+		}
+	}
+}
+
 func TestDBCreatePost(t *testing.T) {
 	attribute := Post{
 		ID:            "1",
@@ -141,13 +194,102 @@ func TestDBCreatePost(t *testing.T) {
 		d := DB{
 			Svc: mockedPutItem{Resp: c.Resp},
 		}
-		err := d.DBCreatePost(&attribute)
+		err := d.DBCreatePost(context.Background(), &attribute)
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
 	}
 }
 
+func TestDBUpdatePost(t *testing.T) {
+	attribute := Post{
+		ID:            "1",
+		PostText:      "hello world",
+		PostedDate:    time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+		Author:        "author",
+		Title:         "new title",
+		ImageLocation: "abcd",
+		HomeText:      "home text",
+		Version:       2,
+	}
+	updated := attribute
+	updated.Version = 3
+	item, _ := dynamodbattribute.MarshalMap(updated)
+	cases := []struct {
+		Resp     dynamodb.UpdateItemOutput
+		Err      error
+		Expected *Post
+		ExpErr   error
+	}{
+		{
+			Resp: dynamodb.UpdateItemOutput{
+				Attributes: item,
+			},
+			Expected: &updated,
+		},
+		{
+			Err:    awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil),
+			ExpErr: ErrConflict,
+		},
+	}
+
+	for _, c := range cases {
+		d := DB{
+			Svc: mockedUpdateItem{Resp: c.Resp, Err: c.Err},
+		}
+		post := attribute
+		err := d.DBUpdatePost(context.Background(), &post)
+		if err != c.ExpErr {
+			t.Fatalf("expected error %v, got %v", c.ExpErr, err)
+		}
+		if c.Expected == nil {
+			continue
+		}
+		if !comparePost(&post, c.Expected) || post.Version != c.Expected.Version {
+			t.Errorf("expected %v message, got %v", c.Expected, post)
+		}
+	}
+}
+
+func TestDBDeletePost(t *testing.T) {
+	post := Post{
+		ID:         "1",
+		PostedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+	}
+	comment, _ := dynamodbattribute.MarshalMap(Comment{
+		ID:          "1",
+		PostID:      "1",
+		CommentDate: time.Date(2018, time.November, 11, 23, 0, 0, 0, time.UTC),
+	})
+	reply, _ := dynamodbattribute.MarshalMap(Reply{
+		ID:        "1#1",
+		ReplyDate: time.Date(2018, time.November, 12, 23, 0, 0, 0, time.UTC),
+	})
+	reaction, _ := dynamodbattribute.MarshalMap(Reaction{Target: "post#1", Owner: "google#2", Type: ReactionLike})
+
+	d := &mockedTables{
+		Items: map[string][]map[string]*dynamodb.AttributeValue{
+			"Comments":  {comment},
+			"Reply":     {reply},
+			"Reactions": {reaction},
+		},
+		Deleted: map[string][]map[string]*dynamodb.AttributeValue{},
+	}
+	db := DB{Svc: d}
+	if err := db.DBDeletePost(context.Background(), &post); err != nil {
+		t.Fatalf("%d, unexpected error", err)
+	}
+
+	// the mock answers the queries for the reactions to the post and to
+	// the comment alike
+	expected := map[string]int{"Posts": 1, "Comments": 1, "Reply": 1, "Reactions": 2}
+	for table, n := range expected {
+		if len(d.Deleted[table]) != n {
+			t.Errorf("expected %d items deleted from %s, got %d", n, table, len(d.Deleted[table]))
+		}
+	}
+}
+
 func comparePost(a, b *Post) bool {
 	if a.Author != b.Author {
 		return false
@@ -0,0 +1,314 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// Reactions readers may leave on a post or comment.
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+	ReactionSad   = "sad"
+)
+
+// ReactionTypes lists every valid reaction.
+var ReactionTypes = []string{ReactionLike, ReactionLove, ReactionLaugh, ReactionWow, ReactionSad}
+
+// ReactionCounts counts the reactions to a post or comment by type.
+type ReactionCounts map[string]int
+
+// maxBatchGet is the most keys DynamoDB accepts in one BatchGetItem call.
+const maxBatchGet = 100
+
+var (
+	errAnonymousReaction = errors.New("models: only signed in users can react")
+	errUnprocessedKeys   = errors.New("models: batch get left unprocessed keys")
+)
+
+// ReactionTarget is a post or comment that can be reacted to. Build one with
+// PostTarget or CommentTarget.
+type ReactionTarget struct {
+	PostID    string
+	CommentID string
+	// date is the sort key of the post or comment in its table.
+	date time.Time
+}
+
+// PostTarget returns the ReactionTarget for post.
+func PostTarget(post *Post) ReactionTarget {
+	return ReactionTarget{PostID: post.ID, date: post.PostedDate}
+}
+
+// CommentTarget returns the ReactionTarget for comment.
+func CommentTarget(comment *Comment) ReactionTarget {
+	return ReactionTarget{PostID: comment.PostID, CommentID: comment.ID, date: comment.CommentDate}
+}
+
+// key is the partition key the reactions to t are stored under.
+func (t ReactionTarget) key() string {
+	if t.CommentID == "" {
+		return "post#" + t.PostID
+	}
+	return "comment#" + t.PostID + "#" + t.CommentID
+}
+
+// Reaction is one user's reaction to a post or comment. A user reacts at
+// most once to each.
+type Reaction struct {
+	Target string `json:"target"`
+	Owner  string `json:"owner"`
+	Type   string `json:"type"`
+}
+
+func reactionKey(target ReactionTarget, owner string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"target": {
+			S: aws.String(target.key()),
+		},
+		"owner": {
+			S: aws.String(owner),
+		},
+	}
+}
+
+// DBAddReaction records user reacting to target with kind and counts it. It
+// returns ErrConflict if user already reacted to target and ErrNotFound if
+// target does not exist.
+func (db *DB) DBAddReaction(ctx context.Context, target ReactionTarget, user *User, kind string) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	owner := ownerKey(user)
+	if owner == "" {
+		return errAnonymousReaction
+	}
+	item, err := dynamodbattribute.MarshalMap(Reaction{Target: target.key(), Owner: owner, Type: kind})
+	if err != nil {
+		return err
+	}
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#target": aws.String("target"),
+		},
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#target)"),
+		TableName:           aws.String("Reactions"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+
+	if err := db.countReaction(ctx, target, kind, 1); err != nil {
+		// take the reaction back, so the user can retry and it is not
+		// recorded without being counted
+		_, derr := db.Svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			Key:       reactionKey(target, owner),
+			TableName: aws.String("Reactions"),
+		})
+		if derr != nil {
+			return fmt.Errorf("models: reaction recorded but not counted: %v, and it could not be taken back: %v", err, derr)
+		}
+		return err
+	}
+	return nil
+}
+
+// DBRemoveReaction removes the reaction user left on target and uncounts it.
+// It returns ErrNotFound if there is none.
+func (db *DB) DBRemoveReaction(ctx context.Context, target ReactionTarget, user *User) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	owner := ownerKey(user)
+	if owner == "" {
+		return ErrNotFound
+	}
+	res, err := db.Svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#target": aws.String("target"),
+		},
+		Key:                 reactionKey(target, owner),
+		ConditionExpression: aws.String("attribute_exists(#target)"),
+		ReturnValues:        aws.String(dynamodb.ReturnValueAllOld),
+		TableName:           aws.String("Reactions"),
+	})
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return translateError(err)
+	}
+
+	var old Reaction
+	if err := dynamodbattribute.UnmarshalMap(res.Attributes, &old); err != nil {
+		return err
+	}
+	return db.countReaction(ctx, target, old.Type, -1)
+}
+
+// deleteReactions removes every reaction left on target, once target itself
+// is deleted.
+func (db *DB) deleteReactions(ctx context.Context, target ReactionTarget) error {
+	reactions, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#target": aws.String("target"),
+			"#owner":  aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(target.key()),
+			},
+		},
+		KeyConditionExpression: aws.String("#target = :v1"),
+		ProjectionExpression:   aws.String("#target, #owner"),
+		TableName:              aws.String("Reactions"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+
+	keys := []map[string]*dynamodb.AttributeValue{}
+	for _, reaction := range reactions {
+		keys = append(keys, keyOf(reaction, "target", "owner"))
+	}
+	return db.batchDelete(ctx, "Reactions", keys)
+}
+
+// countReaction adds n to the count of kind reactions to target.
+func (db *DB) countReaction(ctx context.Context, target ReactionTarget, kind string, n int) error {
+	date, err := dynamodbattribute.Marshal(target.date)
+	if err != nil {
+		return err
+	}
+	table, hashKey := "Posts", "id"
+	key := map[string]*dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(target.PostID),
+		},
+		"posted_date": date,
+	}
+	if target.CommentID != "" {
+		table, hashKey = "Comments", "post_id"
+		key = map[string]*dynamodb.AttributeValue{
+			"post_id": {
+				S: aws.String(target.PostID),
+			},
+			"comment_date": date,
+		}
+	}
+
+	// ADD cannot create the map it adds to, so that is done first
+	_, err = db.Svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key: key,
+		ExpressionAttributeNames: map[string]*string{
+			"#key":       aws.String(hashKey),
+			"#reactions": aws.String("reactions"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":empty": {
+				M: map[string]*dynamodb.AttributeValue{},
+			},
+		},
+		UpdateExpression:    aws.String("SET #reactions = if_not_exists(#reactions, :empty)"),
+		ConditionExpression: aws.String("attribute_exists(#key)"),
+		TableName:           aws.String(table),
+	})
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return translateError(err)
+	}
+
+	_, err = db.Svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key: key,
+		ExpressionAttributeNames: map[string]*string{
+			"#reactions": aws.String("reactions"),
+			"#type":      aws.String(kind),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":n": {
+				N: aws.String(strconv.Itoa(n)),
+			},
+		},
+		UpdateExpression: aws.String("ADD #reactions.#type :n"),
+		TableName:        aws.String(table),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// DBGetUserReactions returns how user reacted to each of targets, in the
+// same order, with "" for targets user did not react to.
+func (db *DB) DBGetUserReactions(ctx context.Context, user *User, targets []ReactionTarget) ([]string, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	kinds := make([]string, len(targets))
+	owner := ownerKey(user)
+	if owner == "" || len(targets) == 0 {
+		return kinds, nil
+	}
+
+	found := map[string]string{}
+	for start := 0; start < len(targets); start += maxBatchGet {
+		end := start + maxBatchGet
+		if end > len(targets) {
+			end = len(targets)
+		}
+
+		keys := []map[string]*dynamodb.AttributeValue{}
+		seen := map[string]bool{}
+		for _, target := range targets[start:end] {
+			if !seen[target.key()] {
+				seen[target.key()] = true
+				keys = append(keys, reactionKey(target, owner))
+			}
+		}
+
+		pending := map[string]*dynamodb.KeysAndAttributes{"Reactions": {Keys: keys}}
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == maxBatchAttempts {
+				return nil, errUnprocessedKeys
+			}
+			if attempt > 0 {
+				select {
+				case <-time.After(time.Duration(attempt*50) * time.Millisecond):
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+			}
+			res, err := db.Svc.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: pending,
+			})
+			if err != nil {
+				return nil, translateError(err)
+			}
+			reactions := []Reaction{}
+			if err := dynamodbattribute.UnmarshalListOfMaps(res.Responses["Reactions"], &reactions); err != nil {
+				return nil, err
+			}
+			for _, r := range reactions {
+				found[r.Target] = r.Type
+			}
+			pending = res.UnprocessedKeys
+		}
+	}
+
+	for i, target := range targets {
+		kinds[i] = found[target.key()]
+	}
+	return kinds, nil
+}
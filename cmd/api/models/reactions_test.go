@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// mockedReactions records the writes made to react to something, failing
+// updates with UpdateErr.
+type mockedReactions struct {
+	dynamodbiface.DynamoDBAPI
+	PutErr    error
+	UpdateErr error
+	DeleteErr error
+	Updates   *[]string
+	Deletes   *int
+}
+
+func (m mockedReactions) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return &dynamodb.PutItemOutput{}, m.PutErr
+}
+
+func (m mockedReactions) UpdateItemWithContext(ctx aws.Context, in *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if m.UpdateErr != nil {
+		return nil, m.UpdateErr
+	}
+	*m.Updates = append(*m.Updates, aws.StringValue(in.UpdateExpression))
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m mockedReactions) DeleteItemWithContext(ctx aws.Context, in *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	*m.Deletes++
+	return &dynamodb.DeleteItemOutput{}, m.DeleteErr
+}
+
+func TestDBAddReaction(t *testing.T) {
+	failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil)
+	cases := []struct {
+		Name      string
+		PutErr    error
+		UpdateErr error
+		Expected  error
+		Updates   int
+		Deletes   int
+	}{
+		{"counts the reaction", nil, nil, nil, 2, 0},
+		{"already reacted", failed, nil, ErrConflict, 0, 0},
+		{"missing post", nil, failed, ErrNotFound, 0, 1},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			updates, deletes := []string{}, 0
+			d := DB{
+				Svc: mockedReactions{PutErr: c.PutErr, UpdateErr: c.UpdateErr, Updates: &updates, Deletes: &deletes},
+			}
+			post := &Post{ID: "1", PostedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)}
+			err := d.DBAddReaction(context.Background(), PostTarget(post), &User{UID: "2", ProviderID: "google"}, ReactionLike)
+			if err != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, err)
+			}
+			if len(updates) != c.Updates {
+				t.Errorf("expected %d updates, got %v", c.Updates, updates)
+			}
+			if deletes != c.Deletes {
+				t.Errorf("expected the reaction to be taken back %d times, got %d", c.Deletes, deletes)
+			}
+		})
+	}
+}
+
+func TestDBAddReactionTakeBackFails(t *testing.T) {
+	updates, deletes := []string{}, 0
+	d := DB{
+		Svc: mockedReactions{
+			UpdateErr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil),
+			DeleteErr: awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil),
+			Updates:   &updates,
+			Deletes:   &deletes,
+		},
+	}
+	post := &Post{ID: "1", PostedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)}
+	err := d.DBAddReaction(context.Background(), PostTarget(post), &User{UID: "2", ProviderID: "google"}, ReactionLike)
+	if err == nil || err == ErrNotFound {
+		t.Errorf("expected the reaction left behind to be reported, got %v", err)
+	}
+}
+
+func TestMemoryReactions(t *testing.T) {
+	db := NewMemoryDB()
+	ctx := context.Background()
+	user := &User{UID: "2", ProviderID: "google"}
+	db.DBCreatePost(ctx, &Post{ID: "1"})
+	db.DBCreateComment(ctx, &Comment{ID: "1", PostID: "1"})
+	post, _ := db.DBGetPost(ctx, "1")
+	comments, _ := db.DBGetComments(ctx, "1")
+	targets := []ReactionTarget{PostTarget(post), CommentTarget(comments[0])}
+
+	if err := db.DBAddReaction(ctx, targets[0], user, ReactionLike); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := db.DBAddReaction(ctx, targets[0], user, ReactionLove); err != ErrConflict {
+		t.Errorf("expected %v, got %v", ErrConflict, err)
+	}
+	if err := db.DBAddReaction(ctx, targets[1], user, ReactionLaugh); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if post, _ := db.DBGetPost(ctx, "1"); post.Reactions[ReactionLike] != 1 {
+		t.Errorf("expected 1 like, got %v", post.Reactions)
+	}
+	if kinds, _ := db.DBGetUserReactions(ctx, user, targets); kinds[0] != ReactionLike || kinds[1] != ReactionLaugh {
+		t.Errorf("expected like and laugh, got %v", kinds)
+	}
+
+	if err := db.DBRemoveReaction(ctx, targets[0], user); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := db.DBRemoveReaction(ctx, targets[0], user); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	if post, _ := db.DBGetPost(ctx, "1"); post.Reactions[ReactionLike] != 0 {
+		t.Errorf("expected no likes, got %v", post.Reactions)
+	}
+
+	// reactions go with what they were left on
+	db.DBAddReaction(ctx, targets[0], user, ReactionLike)
+	db.DBDeletePost(ctx, post)
+	if len(db.reactions) != 0 {
+		t.Errorf("expected the reactions to be deleted with the post, got %v", db.reactions)
+	}
+}
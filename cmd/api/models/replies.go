@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -9,47 +10,174 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
+// Reply is a reply to a comment, or to another reply to it. Replies to the
+// same comment share an ID, built from PostID and CommentID by
+// DBCreateReply, and are told apart by their ReplyDate.
 type Reply struct {
-	User      *User     `json:"user"`
-	ID        string    `json:"id"`
-	ReplyText string    `json:"reply_text"`
-	ReplyDate time.Time `json:"reply_date"`
+	User      *User      `json:"user"`
+	ID        string     `json:"id"`
+	PostID    string     `json:"post_id"`
+	CommentID string     `json:"comment_id"`
+	ReplyText string     `json:"reply_text"`
+	ReplyDate time.Time  `json:"reply_date"`
+	Status    string     `json:"status,omitempty"`
+	Spam      *SpamCheck `json:"spam,omitempty"`
+
+	// ReplyID identifies the reply within its thread and ParentID names the
+	// reply it answers, if any. Replies stored before threading have no
+	// ReplyID and cannot be answered.
+	ReplyID  string `json:"reply_id,omitempty"`
+	ParentID string `json:"parent_id,omitempty"`
+	// Depth counts the replies above this one, 0 for a reply to the comment.
+	Depth int `json:"depth"`
+	// Deleted marks a reply that was deleted while it had replies of its
+	// own. It is kept without its text and author to hold the thread
+	// together.
+	Deleted bool `json:"deleted,omitempty"`
 }
 
-func (db *DB) DBGetReplies(postID, commentID string) ([]*Reply, error) {
+func (db *DB) DBGetReplies(ctx context.Context, postID, commentID string) ([]*Reply, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	replies := []*Reply{}
 	input := &dynamodb.QueryInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
 			":v1": {
-				S: aws.String(fmt.Sprintf("%s#%s", postID, commentID)),
+				S: aws.String(replyKey(postID, commentID)),
 			},
 		},
 		KeyConditionExpression: aws.String("id = :v1"),
 		TableName:              aws.String("Reply"),
 	}
 
-	result, err := db.Svc.Query(input)
+	result, err := db.Svc.QueryWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	if err := dynamodbattribute.UnmarshalListOfMaps(result.Items, &replies); err != nil {
 		return nil, err
 	}
+	// replies stored before they recorded their parents only have the key
+	for _, reply := range replies {
+		reply.PostID, reply.CommentID = postID, commentID
+	}
 
 	return replies, nil
 }
 
-func (db *DB) DBCreateReply(reply *Reply) error {
+// DBCreateReply stores reply with the replies to the comment named by its
+// PostID and CommentID, setting its ID to their partition key.
+func (db *DB) DBCreateReply(ctx context.Context, reply *Reply) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	reply.ID = replyKey(reply.PostID, reply.CommentID)
 	item, err := dynamodbattribute.MarshalMap(reply)
 	if err != nil {
 		return err
 	}
-	_, err = db.Svc.PutItem(&dynamodb.PutItemInput{
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		Item:      item,
 		TableName: aws.String("Reply"),
 	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// DBDeleteReply removes the reply made at replyDate to commentID on postID.
+func (db *DB) DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	date, err := dynamodbattribute.Marshal(replyDate)
 	if err != nil {
 		return err
 	}
+	_, err = db.Svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(replyKey(postID, commentID)),
+			},
+			"reply_date": date,
+		},
+		TableName: aws.String("Reply"),
+	})
+	if err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+// DBTombstoneReply clears the text and author of the reply made at replyDate
+// to commentID on postID and marks it deleted, leaving the replies to it in
+// place.
+func (db *DB) DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	date, err := dynamodbattribute.Marshal(replyDate)
+	if err != nil {
+		return err
+	}
+	_, err = db.Svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"id": {
+				S: aws.String(replyKey(postID, commentID)),
+			},
+			"reply_date": date,
+		},
+		ExpressionAttributeNames: map[string]*string{
+			"#id":   aws.String("id"),
+			"#user": aws.String("user"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":deleted": {
+				BOOL: aws.Bool(true),
+			},
+		},
+		UpdateExpression:    aws.String("SET deleted = :deleted REMOVE reply_text, #user, spam"),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		TableName:           aws.String("Reply"),
+	})
+	if isConditionFailed(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return translateError(err)
+	}
 	return nil
 }
+
+// deleteReplies removes every reply made to commentID on postID.
+func (db *DB) deleteReplies(ctx context.Context, postID, commentID string) error {
+	replies, err := db.queryAll(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":v1": {
+				S: aws.String(replyKey(postID, commentID)),
+			},
+		},
+		KeyConditionExpression: aws.String("#id = :v1"),
+		ProjectionExpression:   aws.String("#id, reply_date"),
+		TableName:              aws.String("Reply"),
+	})
+	if err != nil {
+		return err
+	}
+
+	keys := []map[string]*dynamodb.AttributeValue{}
+	for _, reply := range replies {
+		keys = append(keys, keyOf(reply, "id", "reply_date"))
+	}
+	return db.batchDelete(ctx, "Reply", keys)
+}
+
+// replyKey builds the partition key replies to a comment are stored under.
+func replyKey(postID, commentID string) string {
+	return fmt.Sprintf("%s#%s", postID, commentID)
+}
@@ -1,9 +1,12 @@
 package models
 
 import (
+	"context"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -43,7 +46,7 @@ func TestDBGetRepies(t *testing.T) {
 		d := DB{
 			Svc: mockedQuery{Resp: c.Resp},
 		}
-		items, err := d.DBGetReplies("1", "1")
+		items, err := d.DBGetReplies(context.Background(), "1", "1")
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
@@ -58,7 +61,8 @@ func TestDBGetRepies(t *testing.T) {
 func TestDBCreateReply(t *testing.T) {
 	attribute := Reply{
 
-		ID:        "1#1",
+		PostID:    "1",
+		CommentID: "2",
 		ReplyText: "hello world",
 		ReplyDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
 		//User:          nil}
@@ -77,13 +81,17 @@ func TestDBCreateReply(t *testing.T) {
 	}
 
 	for _, c := range cases {
+		put := []map[string]*dynamodb.AttributeValue{}
 		d := DB{
-			Svc: mockedPutItem{Resp: c.Resp},
+			Svc: mockedPutItem{Resp: c.Resp, Items: &put},
 		}
-		err := d.DBCreateReply(&attribute)
+		err := d.DBCreateReply(context.Background(), &attribute)
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
+		if attribute.ID != "1#2" || len(put) != 1 || aws.StringValue(put[0]["id"].S) != "1#2" {
+			t.Errorf("expected reply to be stored under 1#2, got %v", put)
+		}
 	}
 }
 
@@ -99,3 +107,26 @@ func compareReply(a, b *Reply) bool {
 	}
 	return true
 }
+
+func TestDBTombstoneReply(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Err      error
+		Expected error
+	}{
+		{"tombstones the reply", nil, nil},
+		{"missing reply", awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "failed", nil), ErrNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			d := DB{
+				Svc: mockedUpdateItem{Err: c.Err},
+			}
+			err := d.DBTombstoneReply(context.Background(), "1", "2", time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC))
+			if err != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, err)
+			}
+		})
+	}
+}
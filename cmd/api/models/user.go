@@ -1,11 +1,24 @@
 package models
 
 import (
+	"context"
+
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
 
+// Roles a User can hold.
+const (
+	RoleAdmin     = "admin"
+	RoleAuthor    = "author"
+	RoleCommenter = "commenter"
+	RoleBanned    = "banned"
+)
+
+// Roles lists every valid value of User.Role.
+var Roles = []string{RoleAdmin, RoleAuthor, RoleCommenter, RoleBanned}
+
 type User struct {
 	ID          string `json:"id"`
 	DisplayName string `json:"display_name"`
@@ -16,7 +29,10 @@ type User struct {
 	Role        string `json:"role"`
 }
 
-func (db *DB) DBGetUser(userID string) (*User, error) {
+func (db *DB) DBGetUser(ctx context.Context, userID string) (*User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	var user User
 
 	input := &dynamodb.GetItemInput{
@@ -28,11 +44,14 @@ func (db *DB) DBGetUser(userID string) (*User, error) {
 		TableName: aws.String("User"),
 	}
 
-	res, err := db.Svc.GetItem(input)
+	res, err := db.Svc.GetItemWithContext(ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
+	if len(res.Item) == 0 {
+		return nil, ErrNotFound
+	}
 	if err := dynamodbattribute.UnmarshalMap(res.Item, &user); err != nil {
 		return nil, err
 	}
@@ -40,17 +59,62 @@ func (db *DB) DBGetUser(userID string) (*User, error) {
 	return &user, nil
 }
 
-func (db *DB) DBCreateUser(user *User) error {
+// DBGetUserByOwner returns the stored user with the identity of owner, the
+// user whose UID and ProviderID match.
+func (db *DB) DBGetUserByOwner(ctx context.Context, owner *User) (*User, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	key := ownerKey(owner)
+	if key == "" {
+		return nil, ErrNotFound
+	}
+
+	res, err := db.Svc.QueryWithContext(ctx, &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {
+				S: aws.String(key),
+			},
+		},
+		KeyConditionExpression: aws.String("#owner = :owner"),
+		IndexName:              aws.String("owner-index"),
+		TableName:              aws.String("User"),
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	if len(res.Items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var user User
+	if err := dynamodbattribute.UnmarshalMap(res.Items[0], &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (db *DB) DBCreateUser(ctx context.Context, user *User) error {
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
 	item, err := dynamodbattribute.MarshalMap(user)
 	if err != nil {
 		return err
 	}
-	_, err = db.Svc.PutItem(&dynamodb.PutItemInput{
+	// signed in users are looked up by who they are to their identity provider
+	if owner := ownerKey(user); owner != "" {
+		item["owner"] = &dynamodb.AttributeValue{S: aws.String(owner)}
+	}
+	_, err = db.Svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
 		Item:      item,
 		TableName: aws.String("User"),
 	})
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 	return nil
 }
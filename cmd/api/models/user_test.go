@@ -1,8 +1,11 @@
 package models
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 )
@@ -40,7 +43,7 @@ func TestDBGetUser(t *testing.T) {
 		d := DB{
 			Svc: mockedGetItem{Resp: c.Resp},
 		}
-		items, err := d.DBGetUser("1")
+		items, err := d.DBGetUser(context.Background(), "1")
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
@@ -50,6 +53,27 @@ func TestDBGetUser(t *testing.T) {
 	}
 }
 
+func TestDBGetUserByOwner(t *testing.T) {
+	item, _ := dynamodbattribute.MarshalMap(User{ID: "1", UID: "abc", ProviderID: "google.com", Role: RoleAuthor})
+	d := DB{Svc: mockedQuery{Resp: dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{item}}}}
+
+	user, err := d.DBGetUserByOwner(context.Background(), &User{UID: "abc", ProviderID: "google.com"})
+	if err != nil {
+		t.Fatalf("%v, unexpected error", err)
+	}
+	if user.ID != "1" || user.Role != RoleAuthor {
+		t.Errorf("expected user 1, got %v", user)
+	}
+
+	d = DB{Svc: mockedQuery{}}
+	if _, err := d.DBGetUserByOwner(context.Background(), &User{UID: "abc", ProviderID: "google.com"}); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+	if _, err := d.DBGetUserByOwner(context.Background(), &User{}); err != ErrNotFound {
+		t.Errorf("expected %v, got %v", ErrNotFound, err)
+	}
+}
+
 func TestDBCreateUser(t *testing.T) {
 	attribute := User{
 		ID:          "1",
@@ -76,13 +100,32 @@ func TestDBCreateUser(t *testing.T) {
 		d := DB{
 			Svc: mockedPutItem{Resp: c.Resp},
 		}
-		err := d.DBCreateUser(&attribute)
+		err := d.DBCreateUser(context.Background(), &attribute)
 		if err != nil {
 			t.Fatalf("%d, unexpected error", err)
 		}
 	}
 }
 
+func TestDBContext(t *testing.T) {
+	var got aws.Context
+	d := DB{
+		Svc:     mockedContext{Ctx: &got},
+		Timeout: time.Second,
+	}
+
+	d.DBGetUser(context.Background(), "1")
+	if _, ok := got.Deadline(); !ok {
+		t.Errorf("expected the request to carry the configured timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DBGetUser(ctx, "1"); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
 func compareUser(a, b *User) bool {
 	if a.ID != b.ID {
 		return false
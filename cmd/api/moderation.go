@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/corymhall/blog-backend-go/pkg/spam"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+)
+
+// maxModerationItems bounds how many items one bulk moderation request may change.
+const maxModerationItems = 100
+
+// ModerationPolicy decides the status a new comment or reply by user starts
+// in. Items that are not approved wait in the moderation queue.
+type ModerationPolicy func(ctx context.Context, db models.Datastore, user *models.User) (string, error)
+
+// ApproveAll publishes everything straight away.
+func ApproveAll(ctx context.Context, db models.Datastore, user *models.User) (string, error) {
+	return models.StatusApproved, nil
+}
+
+// HoldAll holds everything for moderation, except what moderators write.
+func HoldAll(ctx context.Context, db models.Datastore, user *models.User) (string, error) {
+	if can(user, PermModerate) {
+		return models.StatusApproved, nil
+	}
+	return models.StatusPending, nil
+}
+
+// ApproveReturning holds comments and replies for moderation until their
+// author has had a comment approved. Authors and moderators are trusted
+// from the start.
+func ApproveReturning(ctx context.Context, db models.Datastore, user *models.User) (string, error) {
+	if can(user, PermModerate) || can(user, PermWritePosts) {
+		return models.StatusApproved, nil
+	}
+	returning, err := db.DBHasApprovedComment(ctx, user)
+	if err != nil {
+		return "", err
+	}
+	if returning {
+		return models.StatusApproved, nil
+	}
+	return models.StatusPending, nil
+}
+
+// ModerationPolicies names the policies the -moderation flag accepts.
+var ModerationPolicies = map[string]ModerationPolicy{
+	"none":      ApproveAll,
+	"all":       HoldAll,
+	"returning": ApproveReturning,
+}
+
+// initialStatus returns the status a new comment or reply by user starts in.
+func (env *Env) initialStatus(ctx context.Context, user *models.User) (string, error) {
+	if env.moderation == nil {
+		return ApproveAll(ctx, env.db, user)
+	}
+	return env.moderation(ctx, env.db, user)
+}
+
+// visible reports whether the user ctx belongs to may see an item in status.
+// Only moderators see items that have not been approved.
+func visible(ctx context.Context, status string) bool {
+	return models.IsApproved(status) || can(userFromContext(ctx), PermModerate)
+}
+
+func moderationRouter(env *Env) chi.Router {
+	r := chi.NewRouter()
+	r.Use(Require(PermModerate))
+
+	// ?status= lists another state than pending, e.g. spam
+	r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.GetModerationQueue(w, rq, logger)
+	})
+	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.Moderate(w, rq, logger)
+	})
+
+	return r
+}
+
+func (env *Env) GetModerationQueue(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	status := rq.URL.Query().Get("status")
+	if status == "" {
+		status = models.StatusPending
+	}
+	var v render.Validation
+	v.OneOf("status", status, models.Statuses...)
+	if err := v.Err(); err != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+		return
+	}
+
+	comments, err := env.db.DBGetCommentsByStatus(rq.Context(), status)
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	replies, err := env.db.DBGetRepliesByStatus(rq.Context(), status)
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	render.Render(w, rq, &ModerationQueuePayload{Comments: comments, Replies: replies}, logger)
+}
+
+// Moderate moves every item in the request to the requested status. Items
+// that cannot be moved are reported back without failing the others.
+func (env *Env) Moderate(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	data := &ModerationPayload{}
+	if err := render.Bind(rq, data); err != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+		return
+	}
+
+	resp := &ModerationResultPayload{Failed: []*ModerationFailure{}}
+	for _, item := range data.Items {
+		// the checker learns from the status the write replaced, so it is
+		// not taught what it already knows
+		var s spam.Submission
+		var previous string
+		if item.ReplyDate != nil {
+			reply, err := env.db.DBSetReplyStatus(rq.Context(), item.PostID, item.CommentID, *item.ReplyDate, data.Status)
+			if err != nil {
+				moderationFailed(resp, item, err, logger)
+				continue
+			}
+			s, previous = submission(reply.User, reply.ReplyText), reply.Status
+		} else {
+			comment, err := env.db.DBSetCommentStatus(rq.Context(), item.PostID, item.CommentID, data.Status)
+			if err != nil {
+				moderationFailed(resp, item, err, logger)
+				continue
+			}
+			s, previous = submission(comment.User, comment.CommentText), comment.Status
+		}
+		resp.Updated++
+		env.learnSpam(rq.Context(), item, s, previous, data.Status, logger)
+	}
+
+	render.Render(w, rq, resp, logger)
+}
+
+// moderationFailed reports item back as not moved.
+func moderationFailed(resp *ModerationResultPayload, item ModerationItem, err error, logger zerolog.Logger) {
+	logger.Info().Err(err).Str("post_id", item.PostID).Str("comment_id", item.CommentID).Msg("unable to moderate item")
+	resp.Failed = append(resp.Failed, &ModerationFailure{ModerationItem: item, Error: err.Error()})
+}
+
+type ModerationQueuePayload struct {
+	Comments []*models.Comment `json:"comments"`
+	Replies  []*models.Reply   `json:"replies"`
+}
+
+func (p *ModerationQueuePayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// ModerationItem names a comment, or a reply when ReplyDate is set.
+type ModerationItem struct {
+	PostID    string     `json:"post_id"`
+	CommentID string     `json:"comment_id"`
+	ReplyDate *time.Time `json:"reply_date,omitempty"`
+}
+
+type ModerationPayload struct {
+	Status string           `json:"status"`
+	Items  []ModerationItem `json:"items"`
+}
+
+func (p *ModerationPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (p *ModerationPayload) Validate(v *render.Validation) {
+	v.Required("status", p.Status)
+	v.OneOf("status", p.Status, models.Statuses...)
+	if len(p.Items) == 0 {
+		v.Add("items", "is required")
+	}
+	if len(p.Items) > maxModerationItems {
+		v.Add("items", "must hold at most %d items", maxModerationItems)
+	}
+	for i, item := range p.Items {
+		v.Required(fmt.Sprintf("items[%d].post_id", i), item.PostID)
+		v.Required(fmt.Sprintf("items[%d].comment_id", i), item.CommentID)
+	}
+}
+
+type ModerationFailure struct {
+	ModerationItem
+	Error string `json:"error"`
+}
+
+type ModerationResultPayload struct {
+	Updated int                  `json:"updated"`
+	Failed  []*ModerationFailure `json:"failed"`
+}
+
+func (p *ModerationResultPayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
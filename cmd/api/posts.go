@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/corymhall/blog-backend-go/cmd/api/models"
 	ht "github.com/corymhall/blog-backend-go/pkg/http"
 	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/corymhall/blog-backend-go/pkg/trace"
 	"github.com/go-chi/chi"
 	"github.com/rs/zerolog"
 )
@@ -19,7 +25,7 @@ func postRouter(env *Env) chi.Router {
 		GetPosts(w, rq, logger, env)
 	})
 
-	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+	r.With(Require(PermWritePosts)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
 		logger := ht.Logger(rq)
 		env.CreatePost(w, rq, logger)
 	})
@@ -30,10 +36,17 @@ func postRouter(env *Env) chi.Router {
 			logger := ht.Logger(rq)
 			GetPost(w, rq, logger, env)
 		})
-		r.Put("/", func(w http.ResponseWriter, rq *http.Request) {
+		// authors only change their own posts
+		r.With(Require(PermWritePosts), RequireAuthor()).Put("/", func(w http.ResponseWriter, rq *http.Request) {
 			logger := ht.Logger(rq)
 			UpdatePost(w, rq, logger, env)
 		})
+		r.With(Require(PermWritePosts), RequireAuthor()).Delete("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			DeletePost(w, rq, logger, env)
+		})
+		r.Mount("/comments", postCommentRouter(env))
+		r.Mount("/reactions", reactionRouter(env, postTarget))
 	})
 
 	return r
@@ -46,13 +59,15 @@ func (env *Env) PostCtx(next http.Handler) http.Handler {
 		var err error
 
 		if postID := chi.URLParam(rq, "postID"); postID != "" {
-			post, err = env.db.DBGetPost(postID)
+			ctx, span := trace.Start(rq.Context(), "PostCtx")
+			post, err = env.db.DBGetPost(ctx, postID)
+			span.End()
 		} else {
 			render.Render(w, rq, render.ErrNotFound, logger)
 			return
 		}
 		if err != nil {
-			render.Render(w, rq, render.ErrNotFound, logger)
+			render.Render(w, rq, ErrDatastore(err), logger)
 			return
 		}
 		ctx := context.WithValue(rq.Context(), "post", post)
@@ -62,15 +77,39 @@ func (env *Env) PostCtx(next http.Handler) http.Handler {
 func (env *Env) PostsCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
 		logger := ht.Logger(rq)
-		var posts []*models.Post
+		var page *models.PostPage
 		var err error
 
-		posts, err = env.db.DBGetPosts()
-		if err != nil {
-			render.Render(w, rq, render.ErrNotFound, logger)
-			return
+		q := models.PostQuery{
+			Author: env.author,
+			Cursor: rq.URL.Query().Get("cursor"),
+		}
+		user, byUser := rq.Context().Value("user").(*models.User)
+		if byUser {
+			q.Author = user.DisplayName
+		} else if author := rq.URL.Query().Get("author"); author != "" {
+			q.Author = author
+		}
+		if limit := rq.URL.Query().Get("limit"); limit != "" {
+			if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 1 || q.Limit > models.MaxPostLimit {
+				render.Render(w, rq, render.ErrInvalidRequest(fmt.Errorf("limit must be between 1 and %d", models.MaxPostLimit)), logger)
+				return
+			}
+		}
+
+		if byUser && q.Author == "" {
+			// users without a display name have no posts of their own
+			page = &models.PostPage{Posts: []*models.Post{}}
+		} else {
+			ctx, span := trace.Start(rq.Context(), "PostsCtx")
+			page, err = env.db.DBGetPosts(ctx, q)
+			span.End()
+			if err != nil {
+				render.Render(w, rq, ErrDatastore(err), logger)
+				return
+			}
 		}
-		ctx := context.WithValue(rq.Context(), "posts", posts)
+		ctx := context.WithValue(rq.Context(), "posts", page)
 		next.ServeHTTP(w, rq.WithContext(ctx))
 	})
 }
@@ -84,18 +123,66 @@ func (env *Env) CreatePost(w http.ResponseWriter, rq *http.Request, logger zerol
 	}
 
 	post := data.Post
-	if err := env.db.DBCreatePost(post); err != nil {
-		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+	if post.ID != "" || !post.PostedDate.IsZero() || post.Reactions != nil || post.User != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(errServerAssigned("id, posted_date, reactions and user")), logger)
+		return
+	}
+	// posts are listed under the name of whoever wrote them, only admins
+	// may post as someone else
+	user := currentUser(rq)
+	name := storedName(user)
+	if post.Author == "" {
+		if name == "" {
+			render.Render(w, rq, render.ErrForbidden(errNoName), logger)
+			return
+		}
+		post.Author = name
+	} else if post.Author != name && !can(user, PermWriteAnyPost) {
+		render.Render(w, rq, render.ErrForbidden(errNotAuthor), logger)
+		return
+	}
+	post.User = &models.User{ID: user.ID, UID: user.UID, ProviderID: user.ProviderID, DisplayName: user.DisplayName}
+	post.PostedDate = env.now()
+	post.ID = env.newID(post.PostedDate)
+	if !env.withMedia(w, rq, logger, post) {
+		return
+	}
+
+	if err := env.db.DBCreatePost(rq.Context(), post); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
 		return
 	}
 
 	render.Status(rq, http.StatusCreated)
-	render.Render(w, rq, NewPostPayloadResponse(post, env), logger)
+	render.Render(w, rq, NewPostPayloadResponse(rq.Context(), post, env), logger)
 }
 
+var (
+	errStaleEdit      = errors.New("the post has changed since it was fetched, fetch it again and reapply the edit")
+	errVersionMissing = errors.New("send the version of the post that was edited, or its ETag in If-Match")
+)
+
+// versionUnset marks a post whose version the client did not send.
+const versionUnset = -1
+
 func UpdatePost(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, env *Env) {
 	post := rq.Context().Value("post").(*models.Post)
 
+	// clients send the ETag of the post they edited, so edits made to a
+	// post that has changed since are rejected. Only the version counts,
+	// new comments and reactions do not make an edit stale.
+	if !render.IfMatchVersion(rq, post.Version) {
+		render.Render(w, rq, render.ErrPreconditionFailed(errStaleEdit), logger)
+		return
+	}
+
+	// the key of the post comes from the route, not the request body
+	id, postedDate, version := post.ID, post.PostedDate, post.Version
+
+	// the body is decoded over the stored post, which would otherwise fill
+	// in the stored version and let edits to a post that has changed since
+	// overwrite it
+	post.Version = versionUnset
 	data := &PostPayload{
 		Post: post,
 	}
@@ -105,36 +192,105 @@ func UpdatePost(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger,
 	}
 
 	post = data.Post
-	if err := env.db.DBUpdatePost(post); err != nil {
-		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+	post.ID, post.PostedDate = id, postedDate
+	if post.Version == versionUnset {
+		// If-Match has already checked the edit was made to this version
+		if rq.Header.Get("If-Match") == "" {
+			render.Render(w, rq, render.ErrPreconditionRequired(errVersionMissing), logger)
+			return
+		}
+		post.Version = version
+	}
+	if !env.withMedia(w, rq, logger, post) {
+		return
+	}
+	if err := env.db.DBUpdatePost(rq.Context(), post); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
 		return
 	}
-	render.Render(w, rq, NewPostPayloadResponse(post, env), logger)
+	render.Render(w, rq, NewPostPayloadResponse(rq.Context(), post, env), logger)
+
+}
+
+// withMedia attaches the media post names to it. When that fails it
+// renders the error and returns false.
+func (env *Env) withMedia(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, post *models.Post) bool {
+	err := env.attachMedia(rq.Context(), post)
+	if verr, ok := err.(*render.ValidationError); ok {
+		render.Render(w, rq, render.ErrInvalidRequest(verr), logger)
+		return false
+	}
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return false
+	}
+	return true
+}
 
+func DeletePost(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, env *Env) {
+	post := rq.Context().Value("post").(*models.Post)
+
+	if err := env.db.DBDeletePost(rq.Context(), post); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	render.NoContent(w, rq)
 }
 
 func GetPost(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, env *Env) {
 	post := rq.Context().Value("post").(*models.Post)
 
-	if err := render.Render(w, rq, NewPostPayloadResponse(post, env), logger); err != nil {
+	if err := render.Render(w, rq, NewPostPayloadResponse(rq.Context(), post, env), logger); err != nil {
 		render.Render(w, rq, render.ErrRender(err), logger)
 		return
 	}
 
 }
 func GetPosts(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, env *Env) {
-	posts := rq.Context().Value("posts").([]*models.Post)
+	page := rq.Context().Value("posts").(*models.PostPage)
+
+	if link := pageLinks(rq, page); link != "" {
+		w.Header().Set("Link", link)
+	}
 
-	if err := render.RenderList(w, rq, NewPostListPayloadResponse(posts, env), logger); err != nil {
+	include, err := parseInclude(rq)
+	if err != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+		return
+	}
+
+	if err := render.RenderList(w, rq, NewPostListPayloadResponse(rq.Context(), page.Posts, env, include), logger); err != nil {
 		render.Render(w, rq, render.ErrRender(err), logger)
 		return
 	}
 
 }
 
+// pageLinks builds a Link header pointing at the pages either side of page.
+// The links keep the rest of the request's query string intact.
+func pageLinks(rq *http.Request, page *models.PostPage) string {
+	links := []string{}
+	for _, l := range []struct{ rel, cursor string }{
+		{"next", page.Next},
+		{"prev", page.Prev},
+	} {
+		if l.cursor == "" {
+			continue
+		}
+		u := *rq.URL
+		q := u.Query()
+		q.Set("cursor", l.cursor)
+		u.RawQuery = q.Encode()
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), l.rel))
+	}
+	return strings.Join(links, ", ")
+}
+
 type PostPayload struct {
 	Post     *models.Post      `json:"post"`
 	Comments []*CommentPayload `json:"comments,omitempty"`
+	// Reaction is how the current user reacted to the post, if they did.
+	Reaction string `json:"reaction,omitempty"`
 }
 
 type PostListResponse []*PostPayload
@@ -147,24 +303,140 @@ func (p *PostPayload) Bind(r *http.Request) error {
 	return nil
 }
 
-func NewPostPayloadResponse(post *models.Post, env *Env) *PostPayload {
-	resp := &PostPayload{
-		Post: post,
+// ETagVersion tags the post with its version, so If-Match can be checked
+// against it when the post is edited.
+func (p *PostPayload) ETagVersion() int64 {
+	return p.Post.Version
+}
+
+func (p *PostPayload) Validate(v *render.Validation) {
+	if !v.Present("post", p.Post != nil) {
+		return
 	}
+	v.Required("post.title", p.Post.Title)
+	v.MaxLength("post.title", p.Post.Title, 200)
+	v.Required("post.post_text", p.Post.PostText)
+	v.MaxLength("post.home_text", p.Post.HomeText, 1000)
+	// media is served from wherever the blob store says, which may be the
+	// api itself, and replaces the image_location sent
+	if p.Post.MediaID == "" {
+		v.URL("post.image_location", p.Post.ImageLocation)
+	}
+}
 
-	if resp.Comments == nil {
-		if comments, _ := env.db.DBGetComments(post.ID); comments != nil {
-			resp.Comments = NewCommentListPayloadResponse(comments, env)
-		}
+// NewPostPayloadResponse returns post along with its full comment thread.
+func NewPostPayloadResponse(ctx context.Context, post *models.Post, env *Env) *PostPayload {
+	ctx, span := trace.Start(ctx, "NewPostPayloadResponse")
+	defer span.End()
+
+	resp := &PostPayload{
+		Post: post,
 	}
+	env.expandPosts(ctx, []*PostPayload{resp}, expansion{comments: true, replies: true})
+	env.markReactions(ctx, []*PostPayload{resp}, nil)
 
 	return resp
 }
 
-func NewPostListPayloadResponse(posts []*models.Post, env *Env) []render.Renderer {
+// NewPostListPayloadResponse returns posts with only the relations asked for
+// in include. Without any, posts are returned without their comment threads.
+func NewPostListPayloadResponse(ctx context.Context, posts []*models.Post, env *Env, include expansion) []render.Renderer {
+	ctx, span := trace.Start(ctx, "NewPostListPayloadResponse")
+	defer span.End()
+
 	list := []render.Renderer{}
+	payloads := []*PostPayload{}
 	for _, post := range posts {
-		list = append(list, NewPostPayloadResponse(post, env))
+		resp := &PostPayload{
+			Post: post,
+		}
+		list = append(list, resp)
+		payloads = append(payloads, resp)
 	}
+	env.expandPosts(ctx, payloads, include)
+	env.markReactions(ctx, payloads, nil)
 	return list
 }
+
+// expansion lists which relations of a post are fetched along with it.
+type expansion struct {
+	comments bool
+	replies  bool
+}
+
+// parseInclude reads the comma separated include query parameter, e.g.
+// ?include=comments,replies. Including replies implies comments.
+func parseInclude(rq *http.Request) (expansion, error) {
+	var e expansion
+	include := rq.URL.Query().Get("include")
+	if include == "" {
+		return e, nil
+	}
+	for _, rel := range strings.Split(include, ",") {
+		switch strings.TrimSpace(rel) {
+		case "comments":
+			e.comments = true
+		case "replies":
+			e.comments = true
+			e.replies = true
+		default:
+			return e, fmt.Errorf("cannot include %q, expected comments or replies", rel)
+		}
+	}
+	return e, nil
+}
+
+// maxConcurrentFetches bounds how many datastore calls expanding a list of
+// posts has in flight at once.
+const maxConcurrentFetches = 8
+
+// expandPosts fills in the relations asked for in include on every payload.
+// Comments for each post, and replies for each comment, are fetched
+// concurrently. A relation that fails to load is left empty, and items the
+// user ctx belongs to may not see are left out.
+func (env *Env) expandPosts(ctx context.Context, payloads []*PostPayload, include expansion) {
+	if !include.comments {
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrentFetches)
+	fetch := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			f()
+		}()
+	}
+
+	for _, p := range payloads {
+		p := p
+		fetch(func() {
+			comments, _ := env.db.DBGetComments(ctx, p.Post.ID)
+			if comments == nil {
+				return
+			}
+			p.Comments = []*CommentPayload{}
+			for _, comment := range comments {
+				if !visible(ctx, comment.Status) {
+					continue
+				}
+				c := &CommentPayload{
+					Comment: redactComment(ctx, comment),
+				}
+				p.Comments = append(p.Comments, c)
+				if !include.replies {
+					continue
+				}
+				fetch(func() {
+					if replies, _ := env.db.DBGetReplies(ctx, c.Comment.PostID, c.Comment.ID); replies != nil {
+						c.Replies = NewReplyListPayloadResponse(ctx, replies)
+					}
+				})
+			}
+		})
+	}
+	wg.Wait()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+)
+
+var errAlreadyReacted = errors.New("you already reacted, remove that reaction to pick another")
+
+// reactionTarget returns what a request to a reactionRouter reacts to, and
+// whether the user may see it.
+type reactionTarget func(rq *http.Request) (models.ReactionTarget, bool)
+
+func postTarget(rq *http.Request) (models.ReactionTarget, bool) {
+	return models.PostTarget(rq.Context().Value("post").(*models.Post)), true
+}
+
+func commentTarget(rq *http.Request) (models.ReactionTarget, bool) {
+	comment := rq.Context().Value("comment").(*models.Comment)
+	return models.CommentTarget(comment), visible(rq.Context(), comment.Status)
+}
+
+// reactionRouter serves the current user's reaction to the post or comment
+// target names.
+func reactionRouter(env *Env, target reactionTarget) chi.Router {
+	r := chi.NewRouter()
+	r.Use(Require(PermReact))
+
+	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.React(w, rq, logger, target)
+	})
+	r.Delete("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.Unreact(w, rq, logger, target)
+	})
+
+	return r
+}
+
+// React records the current user's reaction. Users react once to each post
+// or comment; to change their reaction they remove it first.
+func (env *Env) React(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, target reactionTarget) {
+	t, ok := target(rq)
+	if !ok {
+		render.Render(w, rq, render.ErrNotFound, logger)
+		return
+	}
+
+	data := &ReactionPayload{}
+	if err := render.Bind(rq, data); err != nil {
+		render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+		return
+	}
+
+	if err := env.db.DBAddReaction(rq.Context(), t, currentUser(rq), data.Type); err != nil {
+		if err == models.ErrConflict {
+			render.Render(w, rq, render.ErrConflict(errAlreadyReacted), logger)
+			return
+		}
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	render.Status(rq, http.StatusCreated)
+	render.Render(w, rq, data, logger)
+}
+
+// Unreact removes the current user's reaction.
+func (env *Env) Unreact(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger, target reactionTarget) {
+	t, ok := target(rq)
+	if !ok {
+		render.Render(w, rq, render.ErrNotFound, logger)
+		return
+	}
+
+	if err := env.db.DBRemoveReaction(rq.Context(), t, currentUser(rq)); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	render.NoContent(w, rq)
+}
+
+// markReactions sets how the user ctx belongs to reacted to each of posts,
+// the comments included with them, and comments. Reactions only decorate a
+// response, so when they cannot be loaded they are left out.
+func (env *Env) markReactions(ctx context.Context, posts []*PostPayload, comments []*CommentPayload) {
+	user := userFromContext(ctx)
+	if user == nil {
+		return
+	}
+
+	all := append([]*CommentPayload{}, comments...)
+	targets := []models.ReactionTarget{}
+	for _, p := range posts {
+		targets = append(targets, models.PostTarget(p.Post))
+		all = append(all, p.Comments...)
+	}
+	for _, c := range all {
+		targets = append(targets, models.CommentTarget(c.Comment))
+	}
+	if len(targets) == 0 {
+		return
+	}
+
+	kinds, err := env.db.DBGetUserReactions(ctx, user, targets)
+	if err != nil {
+		return
+	}
+	for i, p := range posts {
+		p.Reaction = kinds[i]
+	}
+	for i, c := range all {
+		c.Reaction = kinds[len(posts)+i]
+	}
+}
+
+type ReactionPayload struct {
+	Type string `json:"type"`
+}
+
+func (p *ReactionPayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (p *ReactionPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (p *ReactionPayload) Validate(v *render.Validation) {
+	v.Required("type", p.Type)
+	v.OneOf("type", p.Type, models.ReactionTypes...)
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/corymhall/blog-backend-go/cmd/api/models"
 	ht "github.com/corymhall/blog-backend-go/pkg/http"
@@ -10,17 +13,137 @@ import (
 	"github.com/rs/zerolog"
 )
 
+// replyRouter serves the flat /replies routes, kept for existing clients.
+// New clients should use the routes under
+// /posts/{postID}/comments/{commentID}/replies.
 func replyRouter(env *Env) chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+	r.With(Require(PermWriteComments)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
 		logger := ht.Logger(rq)
 		env.CreateReply(w, rq, logger)
 	})
 
+	// replies are keyed by the time they were made, in RFC 3339 format
+	r.With(Require(PermWriteComments), env.ReplyCtx, RequireOwner(replyOwner, PermModerate)).
+		Delete("/{postID}/{commentID}/{replyDate}", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.DeleteReply(w, rq, logger)
+		})
+
 	return r
 }
 
+// commentReplyRouter serves the replies to the comment named by the postID
+// and commentID url parameters. It runs after CommentCtx.
+func commentReplyRouter(env *Env) chi.Router {
+	r := chi.NewRouter()
+	// replies are hidden along with their comment
+	r.Use(VisibleComment)
+
+	r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.GetReplies(w, rq, logger)
+	})
+	r.With(Require(PermWriteComments)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		env.CreateReply(w, rq, logger)
+	})
+
+	// replies are keyed by the time they were made, in RFC 3339 format
+	r.Route("/{replyDate}", func(r chi.Router) {
+		r.Use(env.ReplyCtx)
+		r.Get("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			GetReply(w, rq, logger)
+		})
+		r.With(Require(PermWriteComments), RequireOwner(replyOwner, PermModerate)).Delete("/", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			env.DeleteReply(w, rq, logger)
+		})
+	})
+
+	return r
+}
+
+// VisibleComment answers 404 for the comment loaded by CommentCtx, and all
+// under it, unless the comment is visible to the user.
+func VisibleComment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		comment := rq.Context().Value("comment").(*models.Comment)
+		if !visible(rq.Context(), comment.Status) {
+			render.Render(w, rq, render.ErrNotFound, ht.Logger(rq))
+			return
+		}
+		next.ServeHTTP(w, rq)
+	})
+}
+
+// ReplyCtx loads the reply named by the postID, commentID and replyDate url
+// parameters into the request context.
+func (env *Env) ReplyCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		logger := ht.Logger(rq)
+		postID := chi.URLParam(rq, "postID")
+		commentID := chi.URLParam(rq, "commentID")
+
+		replyDate, err := time.Parse(time.RFC3339Nano, chi.URLParam(rq, "replyDate"))
+		if err != nil {
+			render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+			return
+		}
+
+		replies, err := env.db.DBGetReplies(rq.Context(), postID, commentID)
+		if err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		for _, reply := range replies {
+			if reply.ReplyDate.Equal(replyDate) {
+				ctx := context.WithValue(rq.Context(), "reply", reply)
+				next.ServeHTTP(w, rq.WithContext(ctx))
+				return
+			}
+		}
+		render.Render(w, rq, render.ErrNotFound, logger)
+	})
+}
+
+// replyOwner returns who made the reply loaded by ReplyCtx.
+func replyOwner(rq *http.Request) *models.User {
+	return rq.Context().Value("reply").(*models.Reply).User
+}
+
+func (env *Env) GetReplies(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	replies, err := env.db.DBGetReplies(rq.Context(), chi.URLParam(rq, "postID"), chi.URLParam(rq, "commentID"))
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	list := []render.Renderer{}
+	for _, reply := range NewReplyListPayloadResponse(rq.Context(), replies) {
+		list = append(list, reply)
+	}
+	if err := render.RenderList(w, rq, list, logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
+func GetReply(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	reply := rq.Context().Value("reply").(*models.Reply)
+	if !visible(rq.Context(), reply.Status) {
+		render.Render(w, rq, render.ErrNotFound, logger)
+		return
+	}
+
+	if err := render.Render(w, rq, NewReplyPayloadResponse(rq.Context(), reply), logger); err != nil {
+		render.Render(w, rq, render.ErrRender(err), logger)
+		return
+	}
+}
+
 func (env *Env) CreateReply(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
 	data := &ReplyPayload{}
 	if err := render.Bind(rq, data); err != nil {
@@ -29,17 +152,146 @@ func (env *Env) CreateReply(w http.ResponseWriter, rq *http.Request, logger zero
 	}
 
 	reply := data.Reply
-	if err := env.db.DBCreateReply(reply); err != nil {
-		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+	if !reply.ReplyDate.IsZero() || reply.Status != "" || reply.ReplyID != "" || reply.Depth != 0 || reply.Deleted {
+		render.Render(w, rq, render.ErrInvalidRequest(errServerAssigned("reply_id, reply_date, depth, deleted and status")), logger)
+		return
+	}
+	// the flat route names the comment in the payload, so it is looked up
+	// here rather than by CommentCtx
+	if _, ok := rq.Context().Value("comment").(*models.Comment); !ok {
+		comments, err := env.db.DBGetComments(rq.Context(), reply.PostID)
+		if err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		comment := findComment(comments, reply.CommentID)
+		if comment == nil || !visible(rq.Context(), comment.Status) {
+			render.Render(w, rq, render.ErrNotFound, logger)
+			return
+		}
+	}
+	reply.ReplyDate = env.now()
+	reply.ReplyID = env.newID(reply.ReplyDate)
+
+	if reply.ParentID != "" {
+		replies, err := env.db.DBGetReplies(rq.Context(), reply.PostID, reply.CommentID)
+		if err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		var v render.Validation
+		parent := findReply(replies, reply.ParentID)
+		switch {
+		case parent == nil || parent.Deleted || !visible(rq.Context(), parent.Status):
+			v.Add("reply.parent_id", "must name a reply to this comment")
+		case parent.Depth >= env.maxDepth():
+			v.Add("reply.parent_id", "is nested too deeply, replies may be at most %d deep", env.maxDepth())
+		default:
+			reply.Depth = parent.Depth + 1
+		}
+		if err := v.Err(); err != nil {
+			render.Render(w, rq, render.ErrInvalidRequest(err), logger)
+			return
+		}
+	}
+	// replies belong to whoever is signed in, whatever the payload says
+	reply.User = currentUser(rq)
+
+	status, err := env.initialStatus(rq.Context(), reply.User)
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+	reply.Spam, reply.Status = env.checkSpam(rq.Context(), reply.User, reply.ReplyText, status, logger)
+
+	if err := env.db.DBCreateReply(rq.Context(), reply); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
 		return
 	}
 
 	render.Status(rq, http.StatusCreated)
-	render.Render(w, rq, NewReplyPayloadResponse(reply), logger)
+	render.Render(w, rq, NewReplyPayloadResponse(rq.Context(), reply), logger)
+}
+
+func (env *Env) DeleteReply(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
+	postID := chi.URLParam(rq, "postID")
+	commentID := chi.URLParam(rq, "commentID")
+	reply := rq.Context().Value("reply").(*models.Reply)
+
+	replies, err := env.db.DBGetReplies(rq.Context(), postID, commentID)
+	if err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	// a reply that was answered is tombstoned so its answers keep their place
+	gone := map[string]bool{}
+	if hasReplies(replies, reply, gone) {
+		if err := env.db.DBTombstoneReply(rq.Context(), postID, commentID, reply.ReplyDate); err != nil {
+			render.Render(w, rq, ErrDatastore(err), logger)
+			return
+		}
+		render.NoContent(w, rq)
+		return
+	}
+
+	if err := env.db.DBDeleteReply(rq.Context(), postID, commentID, reply.ReplyDate); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	// tombstones are only kept for their replies, so they go with the last one
+	gone[reply.ReplyID] = true
+	for parent := findReply(replies, reply.ParentID); parent != nil && parent.Deleted && !hasReplies(replies, parent, gone); parent = findReply(replies, parent.ParentID) {
+		if err := env.db.DBDeleteReply(rq.Context(), postID, commentID, parent.ReplyDate); err != nil {
+			logger.Info().Err(err).Str("reply_id", parent.ReplyID).Msg("unable to remove tombstone")
+			break
+		}
+		gone[parent.ReplyID] = true
+	}
+	render.NoContent(w, rq)
+}
+
+// findComment returns the comment in comments with commentID, or nil.
+func findComment(comments []*models.Comment, commentID string) *models.Comment {
+	for _, comment := range comments {
+		if comment.ID == commentID {
+			return comment
+		}
+	}
+	return nil
+}
+
+// findReply returns the reply in replies with replyID, or nil.
+func findReply(replies []*models.Reply, replyID string) *models.Reply {
+	if replyID == "" {
+		return nil
+	}
+	for _, reply := range replies {
+		if reply.ReplyID == replyID {
+			return reply
+		}
+	}
+	return nil
+}
+
+// hasReplies reports whether any reply in replies answers parent, leaving
+// out those in gone.
+func hasReplies(replies []*models.Reply, parent *models.Reply, gone map[string]bool) bool {
+	if parent.ReplyID == "" {
+		return false
+	}
+	for _, reply := range replies {
+		if reply.ParentID == parent.ReplyID && !gone[reply.ReplyID] {
+			return true
+		}
+	}
+	return false
 }
 
 type ReplyPayload struct {
-	Reply *models.Reply `json:"reply"`
+	Reply   *models.Reply   `json:"reply"`
+	Replies []*ReplyPayload `json:"replies,omitempty"`
 }
 
 type ReplyListResponse []*ReplyPayload
@@ -48,22 +300,83 @@ func (p *ReplyPayload) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+// Bind takes the comment being replied to from the url when there is one.
+// Otherwise it is named by post_id and comment_id, or by the id older
+// clients send, which is the two joined by a "#".
 func (p *ReplyPayload) Bind(r *http.Request) error {
+	if p.Reply == nil {
+		return nil
+	}
+	if postID := chi.URLParam(r, "postID"); postID != "" {
+		p.Reply.PostID = postID
+		p.Reply.CommentID = chi.URLParam(r, "commentID")
+	} else if p.Reply.PostID == "" && p.Reply.CommentID == "" {
+		if i := strings.Index(p.Reply.ID, "#"); i >= 0 {
+			p.Reply.PostID, p.Reply.CommentID = p.Reply.ID[:i], p.Reply.ID[i+1:]
+		}
+	}
 	return nil
 }
 
-func NewReplyPayloadResponse(reply *models.Reply) *ReplyPayload {
+func (p *ReplyPayload) Validate(v *render.Validation) {
+	if !v.Present("reply", p.Reply != nil) {
+		return
+	}
+	v.Required("reply.post_id", p.Reply.PostID)
+	v.Required("reply.comment_id", p.Reply.CommentID)
+	v.Required("reply.reply_text", p.Reply.ReplyText)
+	v.MaxLength("reply.reply_text", p.Reply.ReplyText, 5000)
+}
+
+func NewReplyPayloadResponse(ctx context.Context, reply *models.Reply) *ReplyPayload {
 	resp := &ReplyPayload{
-		Reply: reply,
+		Reply: redactReply(ctx, reply),
 	}
 
 	return resp
 }
 
-func NewReplyListPayloadResponse(replies []*models.Reply) []*ReplyPayload {
+// NewReplyListPayloadResponse arranges replies, oldest first, into threads
+// where each reply holds the replies to it. Replies the user ctx belongs to
+// may not see are left out along with everything below them, and so are
+// tombstones nothing hangs off anymore.
+func NewReplyListPayloadResponse(ctx context.Context, replies []*models.Reply) []*ReplyPayload {
+	known := map[string]bool{}
+	for _, reply := range replies {
+		known[reply.ReplyID] = reply.ReplyID != ""
+	}
+
 	list := []*ReplyPayload{}
+	threads := map[string]*ReplyPayload{}
 	for _, reply := range replies {
-		list = append(list, NewReplyPayloadResponse(reply))
+		if !visible(ctx, reply.Status) {
+			continue
+		}
+		resp := NewReplyPayloadResponse(ctx, reply)
+		// parents are older than their replies, so they are placed first
+		if parent := threads[reply.ParentID]; parent != nil {
+			parent.Replies = append(parent.Replies, resp)
+		} else if known[reply.ParentID] {
+			continue
+		} else {
+			list = append(list, resp)
+		}
+		if reply.ReplyID != "" {
+			threads[reply.ReplyID] = resp
+		}
+	}
+	return pruneTombstones(list)
+}
+
+// pruneTombstones removes the tombstones in list that have no replies left.
+func pruneTombstones(list []*ReplyPayload) []*ReplyPayload {
+	kept := list[:0]
+	for _, resp := range list {
+		resp.Replies = pruneTombstones(resp.Replies)
+		if resp.Reply.Deleted && len(resp.Replies) == 0 {
+			continue
+		}
+		kept = append(kept, resp)
 	}
-	return list
+	return kept
 }
@@ -2,70 +2,306 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	"github.com/corymhall/blog-backend-go/pkg/blob"
+	ht "github.com/corymhall/blog-backend-go/pkg/http"
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/corymhall/blog-backend-go/pkg/spam"
+	"github.com/corymhall/blog-backend-go/pkg/trace"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/valve"
 	"github.com/rs/zerolog"
 )
 
 type mockDynamoDB struct {
-	Posts    []*models.Post
-	Post     *models.Post
-	User     *models.User
+	Posts []*models.Post
+	Post  *models.Post
+	User  *models.User
+	// Users are the users stored by DBCreateUser. testUser is always
+	// stored as well.
+	Users    []*models.User
 	Comments []*models.Comment
 	Comment  *models.Comment
 	Reply    *models.Reply
 	Replies  []*models.Reply
+
+	GetErr     error
+	UpdateErr  error
+	NextCursor string
+	Author     string
+	Deleted    []string
 }
 
-func (mdb *mockDynamoDB) DBGetPosts() ([]*models.Post, error) {
-	return mdb.Posts, nil
+func (mdb *mockDynamoDB) DBGetPosts(ctx context.Context, q models.PostQuery) (*models.PostPage, error) {
+	if q.Cursor == "bad" {
+		return nil, models.ErrInvalidCursor
+	}
+	mdb.Author = q.Author
+	return &models.PostPage{Posts: mdb.Posts, Next: mdb.NextCursor}, nil
 }
 
-func (mdb *mockDynamoDB) DBGetPost(postID string) (*models.Post, error) {
+func (mdb *mockDynamoDB) DBGetPost(ctx context.Context, postID string) (*models.Post, error) {
+	if mdb.GetErr != nil {
+		return nil, mdb.GetErr
+	}
 	return mdb.Post, nil
 }
-func (mdb *mockDynamoDB) DBCreatePost(post *models.Post) error {
+func (mdb *mockDynamoDB) DBCreatePost(ctx context.Context, post *models.Post) error {
 	return nil
 }
 
-func (mdb *mockDynamoDB) DBUpdatePost(post *models.Post) error {
+func (mdb *mockDynamoDB) DBUpdatePost(ctx context.Context, post *models.Post) error {
+	return mdb.UpdateErr
+}
+
+func (mdb *mockDynamoDB) DBDeletePost(ctx context.Context, post *models.Post) error {
+	mdb.Deleted = append(mdb.Deleted, post.ID)
 	return nil
 }
 
-func (mdb *mockDynamoDB) DBGetUser(userID string) (*models.User, error) {
+func (mdb *mockDynamoDB) DBGetUser(ctx context.Context, userID string) (*models.User, error) {
+	if mdb.GetErr != nil {
+		return nil, mdb.GetErr
+	}
 	return mdb.User, nil
 }
 
-func (mdb *mockDynamoDB) DBCreateUser(user *models.User) error {
+func (mdb *mockDynamoDB) DBGetUserByOwner(ctx context.Context, owner *models.User) (*models.User, error) {
+	for _, user := range append(mdb.Users, testUser) {
+		if user.UID == owner.UID && user.ProviderID == owner.ProviderID {
+			return user, nil
+		}
+	}
+	return nil, models.ErrNotFound
+}
+
+func (mdb *mockDynamoDB) DBCreateUser(ctx context.Context, user *models.User) error {
+	for i, stored := range mdb.Users {
+		if stored.ID == user.ID {
+			mdb.Users[i] = user
+			return nil
+		}
+	}
+	mdb.Users = append(mdb.Users, user)
 	return nil
 }
 
-func (mdb *mockDynamoDB) DBGetComments(postID string) ([]*models.Comment, error) {
+func (mdb *mockDynamoDB) DBGetComments(ctx context.Context, postID string) ([]*models.Comment, error) {
 	return mdb.Comments, nil
 }
 
-func (mdb *mockDynamoDB) DBCreateComment(comment *models.Comment) error {
+func (mdb *mockDynamoDB) DBCreateComment(ctx context.Context, comment *models.Comment) error {
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBDeleteComment(ctx context.Context, postID, commentID string) error {
+	mdb.Deleted = append(mdb.Deleted, postID+"#"+commentID)
 	return nil
 }
 
-func (mdb *mockDynamoDB) DBGetReplies(postID, commentID string) ([]*models.Reply, error) {
+func (mdb *mockDynamoDB) DBGetReplies(ctx context.Context, postID, commentID string) ([]*models.Reply, error) {
 	return mdb.Replies, nil
 }
 
-func (mdb *mockDynamoDB) DBCreateReply(reply *models.Reply) error {
+func (mdb *mockDynamoDB) DBCreateReply(ctx context.Context, reply *models.Reply) error {
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBDeleteReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	mdb.Deleted = append(mdb.Deleted, postID+"#"+commentID+"#"+replyDate.Format(time.RFC3339))
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBTombstoneReply(ctx context.Context, postID, commentID string, replyDate time.Time) error {
+	mdb.Deleted = append(mdb.Deleted, "tombstone#"+postID+"#"+commentID+"#"+replyDate.Format(time.RFC3339))
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBAddReaction(ctx context.Context, target models.ReactionTarget, user *models.User, kind string) error {
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBRemoveReaction(ctx context.Context, target models.ReactionTarget, user *models.User) error {
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBGetUserReactions(ctx context.Context, user *models.User, targets []models.ReactionTarget) ([]string, error) {
+	return make([]string, len(targets)), nil
+}
+
+func (mdb *mockDynamoDB) DBGetMedia(ctx context.Context, mediaID string) (*models.Media, error) {
+	return nil, models.ErrNotFound
+}
+
+func (mdb *mockDynamoDB) DBListMedia(ctx context.Context) ([]*models.Media, error) {
+	return []*models.Media{}, nil
+}
+
+func (mdb *mockDynamoDB) DBCreateMedia(ctx context.Context, media *models.Media) error {
+	return nil
+}
+
+func (mdb *mockDynamoDB) DBDeleteMedia(ctx context.Context, mediaID string) error {
 	return nil
 }
 
+func (mdb *mockDynamoDB) DBGetCommentsByStatus(ctx context.Context, status string) ([]*models.Comment, error) {
+	return mdb.Comments, nil
+}
+
+func (mdb *mockDynamoDB) DBGetRepliesByStatus(ctx context.Context, status string) ([]*models.Reply, error) {
+	return mdb.Replies, nil
+}
+
+func (mdb *mockDynamoDB) DBSetCommentStatus(ctx context.Context, postID, commentID, status string) (*models.Comment, error) {
+	for _, comment := range mdb.Comments {
+		if comment.ID == commentID {
+			return comment, nil
+		}
+	}
+	return &models.Comment{ID: commentID, PostID: postID}, nil
+}
+
+func (mdb *mockDynamoDB) DBSetReplyStatus(ctx context.Context, postID, commentID string, replyDate time.Time, status string) (*models.Reply, error) {
+	for _, reply := range mdb.Replies {
+		if reply.ReplyDate.Equal(replyDate) {
+			return reply, nil
+		}
+	}
+	return &models.Reply{PostID: postID, CommentID: commentID, ReplyDate: replyDate}, nil
+}
+
+func (mdb *mockDynamoDB) DBHasApprovedComment(ctx context.Context, user *models.User) (bool, error) {
+	return false, nil
+}
+
+// testClock and testIDs stand in for the clock and id source of created resources.
+func testClock() time.Time {
+	return time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+}
+
+func testIDs(time.Time) string {
+	return "1"
+}
+
+// testKey signs the bearer tokens of authorized test requests. testAuth
+// trusts it and is used by every test handler without an authenticator.
+var testKey, testAuth = newTestAuth()
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "blog"
+)
+
+// testUser is who authorize signs requests as. Every mockDynamoDB stores it,
+// and so does newTestDB.
+var testUser = &models.User{
+	ID:          "1",
+	UID:         "1",
+	ProviderID:  testIssuer,
+	DisplayName: "author",
+	Email:       "author@gmail.com",
+	Role:        models.RoleAdmin,
+}
+
+func newTestAuth() (*ecdsa.PrivateKey, *ht.Authenticator) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": "test",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+		}},
+	})
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(jwks)
+	f.Close()
+
+	keys, err := ht.NewKeySet(f.Name(), 0)
+	if err != nil {
+		panic(err)
+	}
+	return key, ht.NewAuthenticator(ht.AuthConfig{Keys: keys, Issuer: testIssuer, Audience: testAudience})
+}
+
+// authorize adds a bearer token for a test admin to rq.
+func authorize(rq *http.Request) {
+	authorizeAs(rq, testUser.UID)
+}
+
+// authorizeAs adds a bearer token for the user uid to rq. What they may do
+// is up to the role db stores for them, see storeUser.
+func authorizeAs(rq *http.Request, uid string) {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "test"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   uid,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"name":  "author",
+		"email": "author@gmail.com",
+	})
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, _ := ecdsa.Sign(rand.Reader, testKey, digest[:])
+	// each half is padded to 32 bytes, which big.Int does not keep
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+
+	rq.Header.Set("Authorization", "Bearer "+signed+"."+enc.EncodeToString(sig))
+}
+
+// storeUser stores the user uid with role in db, as POST /user would. They
+// go by the name authorizeAs puts in their token.
+func storeUser(db models.Datastore, uid, role string) {
+	db.DBCreateUser(context.Background(), &models.User{ID: uid, UID: uid, ProviderID: testIssuer, Role: role, DisplayName: "author"})
+}
+
+// newTestDB returns an empty MemoryDB but for testUser.
+func newTestDB() *models.MemoryDB {
+	db := models.NewMemoryDB()
+	db.DBCreateUser(context.Background(), testUser)
+	return db
+}
+
 func newTestHandler(env *Env) http.Handler {
+	if env.auth == nil {
+		env.auth = testAuth
+	}
 	valv := valve.New()
 	baseCtx := valv.Context()
 	logger := zerolog.New(os.Stdout)
@@ -78,7 +314,7 @@ func newTestHandler(env *Env) http.Handler {
 }
 
 func TestHandlerHealthCheck(t *testing.T) {
-	handler := newTestHandler(&Env{&mockDynamoDB{}})
+	handler := newTestHandler(&Env{db: &mockDynamoDB{}})
 	t.Run("returns health check", func(t *testing.T) {
 		request, _ := http.NewRequest(http.MethodGet, "/health", nil)
 		response := httptest.NewRecorder()
@@ -105,11 +341,11 @@ func TestGETPosts(t *testing.T) {
 		PostedDate:    time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
 		Author:        "author",
 		Title:         "title",
-		ImageLocation: "location",
+		ImageLocation: "https://example.com/location.jpg",
 		HomeText:      "homeText",
 	}
 	wantedComment := &models.Comment{
-		User:        &models.User{},
+		User:        testUser,
 		ID:          "1",
 		PostID:      "1",
 		CommentText: "Hello",
@@ -133,7 +369,7 @@ func TestGETPosts(t *testing.T) {
 	}
 	handler := newTestHandler(env)
 	t.Run("returns posts", func(t *testing.T) {
-		rq, _ := http.NewRequest(http.MethodGet, "/posts", nil)
+		rq, _ := http.NewRequest(http.MethodGet, "/posts?include=comments", nil)
 		res := httptest.NewRecorder()
 
 		var got []*PostPayload
@@ -149,12 +385,139 @@ func TestGETPosts(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
 }
 
+func TestGETPostsInclude(t *testing.T) {
+	env := &Env{
+		db: &mockDynamoDB{
+			Posts:    []*models.Post{{ID: "1"}, {ID: "2"}},
+			Comments: []*models.Comment{{ID: "1", PostID: "1"}},
+			Replies:  []*models.Reply{{ID: "1#1"}},
+		},
+	}
+	handler := newTestHandler(env)
+
+	cases := []struct {
+		Name     string
+		Path     string
+		Status   int
+		Comments bool
+		Replies  bool
+	}{
+		{"returns bare posts by default", "/posts", http.StatusOK, false, false},
+		{"includes comments", "/posts?include=comments", http.StatusOK, true, false},
+		{"includes replies", "/posts?include=comments,replies", http.StatusOK, true, true},
+		{"rejects unknown relations", "/posts?include=authors", http.StatusBadRequest, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Fatalf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if c.Status != http.StatusOK {
+				return
+			}
+
+			var got []*PostPayload
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("Unable to parse response from server '%s' into slice of Post, '%v'", res.Body, err)
+			}
+			for _, p := range got {
+				if (len(p.Comments) > 0) != c.Comments {
+					t.Errorf("post %s: got comments %v", p.Post.ID, p.Comments)
+				}
+				for _, comment := range p.Comments {
+					if (len(comment.Replies) > 0) != c.Replies {
+						t.Errorf("post %s: got replies %v", p.Post.ID, comment.Replies)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGETPostsPagination(t *testing.T) {
+	env := &Env{
+		db: &mockDynamoDB{
+			Posts:      []*models.Post{{ID: "1"}},
+			NextCursor: "abc",
+		},
+	}
+	handler := newTestHandler(env)
+
+	cases := []struct {
+		Name   string
+		Path   string
+		Status int
+		Link   string
+	}{
+		{"links to the next page", "/posts?limit=1", http.StatusOK, `</posts?cursor=abc&limit=1>; rel="next"`},
+		{"rejects a bad limit", "/posts?limit=0", http.StatusBadRequest, ""},
+		{"rejects a bad cursor", "/posts?cursor=bad", http.StatusBadRequest, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if got := res.Header().Get("Link"); got != c.Link {
+				t.Errorf("got '%s', want '%s'", got, c.Link)
+			}
+		})
+	}
+}
+
+func TestGETPostsByAuthor(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Path        string
+		DisplayName string
+		Author      string
+		Want        string
+	}{
+		{"lists the default author", "/posts", "Display Name", "configured", ""},
+		{"lists the requested author", "/posts?author=someone", "Display Name", "someone", ""},
+		{"lists a user's posts", "/user/1/posts", "Display Name", "Display Name", ""},
+		{"lists nothing for users without a name", "/user/1/posts?author=someone", "", "", "[]"},
+	}
+	for _, c := range cases {
+		db := &mockDynamoDB{
+			User: &models.User{ID: "1", DisplayName: c.DisplayName},
+		}
+		handler := newTestHandler(&Env{db: db, author: "configured"})
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != http.StatusOK {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusOK)
+			}
+			if db.Author != c.Author {
+				t.Errorf("got '%s', want '%s'", db.Author, c.Author)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
 func TestCreatePost(t *testing.T) {
 	wantedPost := &models.Post{
 		ID:            "1",
@@ -162,8 +525,9 @@ func TestCreatePost(t *testing.T) {
 		PostedDate:    time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
 		Author:        "author",
 		Title:         "title",
-		ImageLocation: "location",
+		ImageLocation: "https://example.com/location.jpg",
 		HomeText:      "homeText",
+		User:          &models.User{ID: "1", UID: "1", ProviderID: testIssuer, DisplayName: "author"},
 	}
 	wanted := &PostPayload{
 		Post: wantedPost,
@@ -172,14 +536,19 @@ func TestCreatePost(t *testing.T) {
 		db: &mockDynamoDB{
 			Post: wantedPost,
 		},
+		clock: testClock,
+		ids:   testIDs,
 	}
 
-	postPayload := wanted
+	sent := *wantedPost
+	sent.ID, sent.PostedDate, sent.User = "", time.Time{}, nil
+	postPayload := &PostPayload{Post: &sent}
 
 	jsonPayload, _ := json.Marshal(postPayload)
 	handler := newTestHandler(env)
 	t.Run("creats a post", func(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPost, "/posts", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
 		res := httptest.NewRecorder()
 
 		var got *PostPayload
@@ -195,7 +564,7 @@ func TestCreatePost(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
@@ -208,7 +577,7 @@ func TestUpdatePost(t *testing.T) {
 		PostedDate:    time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
 		Author:        "author",
 		Title:         "title",
-		ImageLocation: "location",
+		ImageLocation: "https://example.com/location.jpg",
 		HomeText:      "homeText",
 	}
 	wanted := &PostPayload{
@@ -226,6 +595,7 @@ func TestUpdatePost(t *testing.T) {
 	handler := newTestHandler(env)
 	t.Run("updates a post", func(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPut, "/posts/1", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
 		res := httptest.NewRecorder()
 
 		var got *PostPayload
@@ -241,10 +611,53 @@ func TestUpdatePost(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
+	t.Run("requires the version edited", func(t *testing.T) {
+		rq, _ := http.NewRequest(http.MethodPut, "/posts/1", bytes.NewBufferString(`{"post": {"title": "title", "post_text": "hello"}}`))
+		authorize(rq)
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, rq)
+
+		if status := res.Code; status != http.StatusPreconditionRequired {
+			t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusPreconditionRequired)
+		}
+	})
+}
+
+func TestUpdatePostConflict(t *testing.T) {
+	storedPost := &models.Post{
+		ID:         "1",
+		PostText:   "hello",
+		PostedDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+		Title:      "title",
+		Version:    2,
+	}
+	env := &Env{
+		db: &mockDynamoDB{
+			Post:      storedPost,
+			UpdateErr: models.ErrConflict,
+		},
+	}
+
+	jsonPayload, _ := json.Marshal(&PostPayload{
+		Post: &models.Post{Title: "stale edit", PostText: "hello", Version: 1},
+	})
+	handler := newTestHandler(env)
+	t.Run("rejects a stale update", func(t *testing.T) {
+		rq, _ := http.NewRequest(http.MethodPut, "/posts/1", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
+		res := httptest.NewRecorder()
+
+		handler.ServeHTTP(res, rq)
+
+		if status := res.Code; status != http.StatusConflict {
+			t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusConflict)
+		}
+	})
 }
 
 func TestGETPost(t *testing.T) {
@@ -254,7 +667,7 @@ func TestGETPost(t *testing.T) {
 		PostedDate:    time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
 		Author:        "author",
 		Title:         "title",
-		ImageLocation: "location",
+		ImageLocation: "https://example.com/location.jpg",
 		HomeText:      "homeText",
 	}
 	wantedComment := &models.Comment{
@@ -299,20 +712,48 @@ func TestGETPost(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
 }
 
+func TestDatastoreErrors(t *testing.T) {
+	cases := []struct {
+		Name   string
+		Path   string
+		Err    error
+		Status int
+	}{
+		{"unknown post", "/posts/1", models.ErrNotFound, http.StatusNotFound},
+		{"unknown user", "/user/1", models.ErrNotFound, http.StatusNotFound},
+		{"throttled post", "/posts/1", models.ErrUnavailable, http.StatusServiceUnavailable},
+		{"throttled user", "/user/1", models.ErrUnavailable, http.StatusServiceUnavailable},
+		{"failed post", "/posts/1", errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		handler := newTestHandler(&Env{db: &mockDynamoDB{GetErr: c.Err}})
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+		})
+	}
+}
+
 func TestGETUser(t *testing.T) {
 	wantedUser := &models.User{
 		ID:          "1",
 		DisplayName: "Display Name",
 		Email:       "user@gmail.com",
-		PhotoURL:    "photourl.com",
+		PhotoURL:    "https://photourl.com",
 		UID:         "12345abcd",
-		Role:        "user",
+		Role:        "commenter",
 	}
 
 	wanted := &UserPayload{
@@ -341,7 +782,7 @@ func TestGETUser(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
@@ -353,9 +794,9 @@ func TestCreateUser(t *testing.T) {
 		ID:          "1",
 		DisplayName: "Display Name",
 		Email:       "user@gmail.com",
-		PhotoURL:    "photourl.com",
+		PhotoURL:    "https://photourl.com",
 		UID:         "12345abcd",
-		Role:        "user",
+		Role:        "commenter",
 	}
 
 	wanted := &UserPayload{
@@ -365,14 +806,19 @@ func TestCreateUser(t *testing.T) {
 		db: &mockDynamoDB{
 			User: wantedUser,
 		},
+		clock: testClock,
+		ids:   testIDs,
 	}
 
-	userPayload := wanted
+	sent := *wantedUser
+	sent.ID = ""
+	userPayload := &UserPayload{User: &sent}
 
 	jsonPayload, _ := json.Marshal(userPayload)
 	handler := newTestHandler(env)
 	t.Run("creats a user", func(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPost, "/user", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
 		res := httptest.NewRecorder()
 
 		var got *UserPayload
@@ -388,20 +834,70 @@ func TestCreateUser(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
 
 }
 
+func TestUpdateUser(t *testing.T) {
+	db := newTestDB()
+	ids := 1
+	handler := newTestHandler(&Env{
+		db:    db,
+		clock: testClock,
+		ids:   func(time.Time) string { ids++; return strconv.Itoa(ids) },
+	})
+
+	user := func(role string) string {
+		return `{"uid": "9", "provider_id": "` + testIssuer + `", "display_name": "nine", "email": "nine@gmail.com", "role": "` + role + `"}`
+	}
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		UID    string
+		Status int
+		Want   string
+	}{
+		{"stores the user", http.MethodPost, "/user", user(models.RoleAuthor), "1", http.StatusCreated, `"id":"2"`},
+		{"user posts", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p"}}`, "9", http.StatusCreated, `"author":"nine"`},
+		{"bans the user", http.MethodPost, "/user", user(models.RoleBanned), "1", http.StatusOK, `"id":"2"`},
+		{"banned user cannot post", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p"}}`, "9", http.StatusForbidden, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			authorizeAs(rq, c.UID)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+
+	if stored, _ := db.DBGetUserByOwner(context.Background(), &models.User{UID: "9", ProviderID: testIssuer}); stored == nil || stored.ID != "2" {
+		t.Errorf("expected the user to be stored once, got %v", stored)
+	}
+}
+
 func TestCreateComment(t *testing.T) {
 	wantedComment := &models.Comment{
-		User:        &models.User{},
+		User:        testUser,
 		ID:          "1",
 		PostID:      "1",
 		CommentText: "Hello",
 		CommentDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+		Status:      models.StatusApproved,
 	}
 	wanted := &CommentPayload{
 		Comment: wantedComment,
@@ -410,14 +906,19 @@ func TestCreateComment(t *testing.T) {
 		db: &mockDynamoDB{
 			Comment: wantedComment,
 		},
+		clock: testClock,
+		ids:   testIDs,
 	}
 
-	commentPayload := wanted
+	sent := *wantedComment
+	sent.ID, sent.CommentDate, sent.User, sent.Status = "", time.Time{}, nil, ""
+	commentPayload := &CommentPayload{Comment: &sent}
 
 	jsonPayload, _ := json.Marshal(commentPayload)
 	handler := newTestHandler(env)
 	t.Run("creats a comment", func(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPost, "/comments", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
 		res := httptest.NewRecorder()
 
 		var got *CommentPayload
@@ -433,7 +934,7 @@ func TestCreateComment(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
 		}
 
 	})
@@ -441,26 +942,38 @@ func TestCreateComment(t *testing.T) {
 
 func TestCreateReply(t *testing.T) {
 	wantedReply := &models.Reply{
-		User:      &models.User{},
-		ID:        "1:1",
+		User:      testUser,
+		ID:        "1#1",
+		PostID:    "1",
+		CommentID: "1",
 		ReplyText: "Hello",
 		ReplyDate: time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC),
+		Status:    models.StatusApproved,
+		ReplyID:   testIDs(testClock()),
 	}
 	wanted := &ReplyPayload{
 		Reply: wantedReply,
 	}
 	env := &Env{
 		db: &mockDynamoDB{
-			Reply: wantedReply,
+			Reply:    wantedReply,
+			Comments: []*models.Comment{{ID: "1", PostID: "1", Status: models.StatusApproved}},
 		},
+		clock: testClock,
+		ids:   testIDs,
 	}
 
-	replyPayload := wanted
+	sent := *wantedReply
+	// older clients name the comment by the reply's id alone
+	sent.ReplyDate, sent.User, sent.Status, sent.ReplyID = time.Time{}, nil, "", ""
+	sent.PostID, sent.CommentID = "", ""
+	replyPayload := &ReplyPayload{Reply: &sent}
 
 	jsonPayload, _ := json.Marshal(replyPayload)
 	handler := newTestHandler(env)
 	t.Run("creats a comment", func(t *testing.T) {
 		rq, _ := http.NewRequest(http.MethodPost, "/replies", bytes.NewBuffer(jsonPayload))
+		authorize(rq)
 		res := httptest.NewRecorder()
 
 		var got *ReplyPayload
@@ -476,8 +989,1066 @@ func TestCreateReply(t *testing.T) {
 		}
 
 		if !reflect.DeepEqual(got, wanted) {
-			t.Errorf("got '%s', want '%s'", got, wanted)
+			t.Errorf("got '%v', want '%v'", got, wanted)
+		}
+
+	})
+}
+
+func TestCreateRejectsServerFields(t *testing.T) {
+	date := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+	cases := []struct {
+		Name    string
+		Path    string
+		Payload interface{}
+	}{
+		{"post id", "/posts", &PostPayload{Post: &models.Post{ID: "1", Title: "title", PostText: "hello"}}},
+		{"post date", "/posts", &PostPayload{Post: &models.Post{PostedDate: date, Title: "title", PostText: "hello"}}},
+		{"user id", "/user", &UserPayload{User: &models.User{ID: "1", DisplayName: "name", Email: "user@gmail.com"}}},
+		{"comment id", "/comments", &CommentPayload{Comment: &models.Comment{ID: "1", PostID: "1", CommentText: "hello"}}},
+		{"comment date", "/comments", &CommentPayload{Comment: &models.Comment{CommentDate: date, PostID: "1", CommentText: "hello"}}},
+		{"reply date", "/replies", &ReplyPayload{Reply: &models.Reply{ReplyDate: date, ID: "1#1", ReplyText: "hello"}}},
+	}
+
+	handler := newTestHandler(&Env{db: &mockDynamoDB{}})
+	for _, c := range cases {
+		jsonPayload, _ := json.Marshal(c.Payload)
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodPost, c.Path, bytes.NewBuffer(jsonPayload))
+			authorize(rq)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCreateValidation(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Path    string
+		Payload string
+		Fields  []string
+	}{
+		{"missing post", "/posts", `{}`, []string{"post"}},
+		{"empty post", "/posts", `{"post": {"image_location": "abcd"}}`, []string{"post.title", "post.post_text", "post.image_location"}},
+		{"empty comment", "/comments", `{"comment": {}}`, []string{"comment.post_id", "comment.comment_text"}},
+		{"missing reply", "/replies", `{}`, []string{"reply"}},
+		{"bad user", "/user", `{"display_name": "name", "email": "not an email", "role": "owner"}`, []string{"email", "role"}},
+	}
+
+	handler := newTestHandler(&Env{db: &mockDynamoDB{}})
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodPost, c.Path, bytes.NewBufferString(c.Payload))
+			authorize(rq)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != http.StatusUnprocessableEntity {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusUnprocessableEntity)
+			}
+
+			var got render.ErrResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("Unable to parse response from server '%s' into ErrResponse, '%v'", res.Body, err)
+			}
+			fields := []string{}
+			for _, f := range got.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, c.Fields) {
+				t.Errorf("got '%v', want '%v'", fields, c.Fields)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Path    string
+		Status  int
+		Deleted []string
+	}{
+		{"deletes a post", "/posts/1", http.StatusNoContent, []string{"1"}},
+		{"deletes a comment", "/comments/1/2", http.StatusNoContent, []string{"1#2"}},
+		{"deletes a reply", "/replies/1/2/2018-11-10T23:00:00Z", http.StatusNoContent, []string{"1#2#2018-11-10T23:00:00Z"}},
+		{"rejects a malformed reply date", "/replies/1/2/yesterday", http.StatusBadRequest, nil},
+		{"missing comment", "/comments/1/3", http.StatusNotFound, nil},
+		{"missing reply", "/replies/1/2/2018-11-11T23:00:00Z", http.StatusNotFound, nil},
+	}
+
+	for _, c := range cases {
+		db := &mockDynamoDB{
+			Post:     &models.Post{ID: "1"},
+			Comments: []*models.Comment{{ID: "2", PostID: "1"}},
+			Replies:  []*models.Reply{{ID: "1#2", ReplyDate: testClock()}},
+		}
+		handler := newTestHandler(&Env{db: db})
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodDelete, c.Path, nil)
+			authorize(rq)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if !reflect.DeepEqual(db.Deleted, c.Deleted) {
+				t.Errorf("got '%v', want '%v'", db.Deleted, c.Deleted)
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Method    string
+		Path      string
+		Authorize bool
+		Status    int
+	}{
+		{"anonymous read", http.MethodGet, "/posts", false, http.StatusOK},
+		{"anonymous create", http.MethodPost, "/posts", false, http.StatusUnauthorized},
+		{"anonymous update", http.MethodPut, "/posts/1", false, http.StatusUnauthorized},
+		{"anonymous delete", http.MethodDelete, "/posts/1", false, http.StatusUnauthorized},
+		{"authorized delete", http.MethodDelete, "/posts/1", true, http.StatusNoContent},
+	}
+
+	for _, c := range cases {
+		db := &mockDynamoDB{Post: &models.Post{ID: "1"}}
+		handler := newTestHandler(&Env{db: db})
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, nil)
+			if c.Authorize {
+				authorize(rq)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if res.Code == http.StatusUnauthorized && len(db.Deleted) != 0 {
+				t.Errorf("unauthorized request deleted '%v'", db.Deleted)
+			}
+		})
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	owner := &models.User{UID: "2", ProviderID: testIssuer}
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		UID    string
+		Role   string
+		Status int
+	}{
+		{"author creates a post", http.MethodPost, "/posts", "1", models.RoleAuthor, http.StatusCreated},
+		{"commenter creates a post", http.MethodPost, "/posts", "1", models.RoleCommenter, http.StatusForbidden},
+		{"commenter updates a post", http.MethodPut, "/posts/1", "1", models.RoleCommenter, http.StatusForbidden},
+		{"commenter deletes a post", http.MethodDelete, "/posts/1", "1", models.RoleCommenter, http.StatusForbidden},
+		{"author updates their post", http.MethodPut, "/posts/1", "2", models.RoleAuthor, http.StatusOK},
+		{"author updates another's post", http.MethodPut, "/posts/1", "3", models.RoleAuthor, http.StatusForbidden},
+		{"author deletes another's post", http.MethodDelete, "/posts/1", "3", models.RoleAuthor, http.StatusForbidden},
+		{"admin deletes another's post", http.MethodDelete, "/posts/1", "1", models.RoleAdmin, http.StatusNoContent},
+		{"banned user comments", http.MethodPost, "/comments", "1", models.RoleBanned, http.StatusForbidden},
+		{"user without a role comments", http.MethodPost, "/comments", "1", "", http.StatusCreated},
+		{"author creates a user", http.MethodPost, "/user", "1", models.RoleAuthor, http.StatusForbidden},
+		{"owner deletes a comment", http.MethodDelete, "/comments/1/2", "2", models.RoleCommenter, http.StatusNoContent},
+		{"other user deletes a comment", http.MethodDelete, "/comments/1/2", "1", models.RoleAuthor, http.StatusForbidden},
+		{"admin deletes a comment", http.MethodDelete, "/comments/1/2", "1", models.RoleAdmin, http.StatusNoContent},
+		{"banned owner deletes a comment", http.MethodDelete, "/comments/1/2", "2", models.RoleBanned, http.StatusForbidden},
+		{"owner deletes a reply", http.MethodDelete, "/replies/1/2/2018-11-10T23:00:00Z", "2", models.RoleCommenter, http.StatusNoContent},
+		{"other user deletes a reply", http.MethodDelete, "/replies/1/2/2018-11-10T23:00:00Z", "1", models.RoleCommenter, http.StatusForbidden},
+	}
+
+	payloads := map[string]string{
+		"/posts":    `{"post": {"title": "title", "post_text": "hello"}}`,
+		"/comments": `{"comment": {"post_id": "1", "comment_text": "hello"}}`,
+		"/user":     `{"display_name": "name", "email": "user@gmail.com"}`,
+		"/posts/1":  `{"post": {"title": "title", "post_text": "hello", "version": 0}}`,
+	}
+
+	for _, c := range cases {
+		db := &mockDynamoDB{
+			Post:     &models.Post{ID: "1", User: owner},
+			Comments: []*models.Comment{{ID: "2", PostID: "1", User: owner}},
+			Replies:  []*models.Reply{{ID: "1#2", ReplyDate: testClock(), User: owner}},
 		}
+		handler := newTestHandler(&Env{db: db, clock: testClock, ids: testIDs})
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(payloads[c.Path]))
+			storeUser(db, c.UID, c.Role)
+			authorizeAs(rq, c.UID)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+		})
+	}
+}
+
+func TestCreateCommentOwner(t *testing.T) {
+	handler := newTestHandler(&Env{db: &mockDynamoDB{}, clock: testClock, ids: testIDs})
+	rq, _ := http.NewRequest(http.MethodPost, "/comments", bytes.NewBufferString(
+		`{"comment": {"post_id": "1", "comment_text": "hello", "user": {"uid": "someone else"}}}`))
+	authorizeAs(rq, "2")
+	res := httptest.NewRecorder()
+
+	handler.ServeHTTP(res, rq)
+
+	var got CommentPayload
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("Unable to parse response from server '%s' into CommentPayload, '%v'", res.Body, err)
+	}
+	if user := got.Comment.User; user == nil || user.UID != "2" || user.ProviderID != testIssuer {
+		t.Errorf("got owner '%v', want the signed in user", user)
+	}
+}
+
+func TestPostAuthors(t *testing.T) {
+	db := newTestDB()
+	ctx := context.Background()
+	db.DBCreateUser(ctx, &models.User{ID: "2", UID: "2", ProviderID: testIssuer, Role: models.RoleAuthor, DisplayName: "author"})
+	// tokens all carry the name "author", which must not make user 3 one
+	db.DBCreateUser(ctx, &models.User{ID: "3", UID: "3", ProviderID: testIssuer, Role: models.RoleAuthor, DisplayName: "other"})
+	db.DBCreateUser(ctx, &models.User{ID: "4", UID: "4", ProviderID: testIssuer, Role: models.RoleAuthor})
+	db.DBCreatePost(ctx, &models.Post{ID: "legacy", Author: "author"})
+	ids := 0
+	handler := newTestHandler(&Env{
+		db:     db,
+		author: "configured",
+		clock:  testClock,
+		ids:    func(time.Time) string { ids++; return strconv.Itoa(ids) },
+	})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		UID    string
+		Status int
+		Want   string
+	}{
+		{"author posts as someone else", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p", "author": "someone"}}`, "2", http.StatusForbidden, ""},
+		{"posts are by their author", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p"}}`, "2", http.StatusCreated, `"author":"author"`},
+		{"author is recorded", http.MethodGet, "/posts/1", "", "", http.StatusOK, `"user":{"id":"2","display_name":"author"`},
+		{"admin posts as someone else", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p", "author": "configured"}}`, "1", http.StatusCreated, `"author":"configured"`},
+		{"author is not chosen by the client", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p", "user": {"uid": "3"}}}`, "3", http.StatusBadRequest, ""},
+		{"other author deletes the post", http.MethodDelete, "/posts/1", "", "3", http.StatusForbidden, ""},
+		{"author deletes the post", http.MethodDelete, "/posts/1", "", "2", http.StatusNoContent, ""},
+		{"token names do not make an author", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p", "author": "author"}}`, "3", http.StatusForbidden, ""},
+		{"authors without a name cannot post", http.MethodPost, "/posts", `{"post": {"title": "t", "post_text": "p"}}`, "4", http.StatusForbidden, `display name`},
+		{"token names do not match legacy posts", http.MethodDelete, "/posts/legacy", "", "3", http.StatusForbidden, ""},
+		{"stored names match legacy posts", http.MethodDelete, "/posts/legacy", "", "2", http.StatusNoContent, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			if c.UID != "" {
+				authorizeAs(rq, c.UID)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
+func TestNestedRoutes(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1"})
+	handler := newTestHandler(&Env{db: db, clock: testClock, ids: testIDs})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Status int
+		Want   string
+	}{
+		{"comments on a post", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "hello"}}`, http.StatusCreated, `"post_id":"1"`},
+		{"comments on a missing post", http.MethodPost, "/posts/2/comments", `{"comment": {"comment_text": "hello"}}`, http.StatusNotFound, ""},
+		{"lists comments", http.MethodGet, "/posts/1/comments", "", http.StatusOK, `"comment_text":"hello"`},
+		{"gets a comment", http.MethodGet, "/posts/1/comments/1", "", http.StatusOK, `"id":"1"`},
+		{"gets a missing comment", http.MethodGet, "/posts/1/comments/2", "", http.StatusNotFound, ""},
+		{"replies to a comment", http.MethodPost, "/posts/1/comments/1/replies", `{"reply": {"reply_text": "hi"}}`, http.StatusCreated, `"id":"1#1"`},
+		{"replies to a missing comment", http.MethodPost, "/posts/1/comments/2/replies", `{"reply": {"reply_text": "hi"}}`, http.StatusNotFound, ""},
+		{"lists replies", http.MethodGet, "/posts/1/comments/1/replies", "", http.StatusOK, `"reply_text":"hi"`},
+		{"gets a reply", http.MethodGet, "/posts/1/comments/1/replies/2018-11-10T23:00:00Z", "", http.StatusOK, `"comment_id":"1"`},
+		{"includes replies with the comment", http.MethodGet, "/posts/1/comments/1", "", http.StatusOK, `"reply_text":"hi"`},
+		{"deletes a reply", http.MethodDelete, "/posts/1/comments/1/replies/2018-11-10T23:00:00Z", "", http.StatusNoContent, ""},
+		{"deleted reply is gone", http.MethodGet, "/posts/1/comments/1/replies/2018-11-10T23:00:00Z", "", http.StatusNotFound, ""},
+		{"deletes a comment", http.MethodDelete, "/posts/1/comments/1", "", http.StatusNoContent, ""},
+		{"deleted comment is gone", http.MethodGet, "/posts/1/comments/1", "", http.StatusNotFound, ""},
+	}
 
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			if c.Method != http.MethodGet {
+				authorize(rq)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
+func TestModeration(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1"})
+	// comments made at the same time replace each other, so time moves on
+	// with every id handed out
+	ids := 0
+	handler := newTestHandler(&Env{
+		db:         db,
+		clock:      func() time.Time { return testClock().Add(time.Duration(ids) * time.Second) },
+		ids:        func(time.Time) string { ids++; return strconv.Itoa(ids) },
+		moderation: ApproveReturning,
 	})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		UID    string
+		Role   string
+		Status int
+		Want   string
+		Hidden string
+	}{
+		{"new commenter is held", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "first"}}`, "2", models.RoleCommenter, http.StatusCreated, `"status":"pending"`, ""},
+		{"status cannot be chosen", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "first", "status": "approved"}}`, "2", models.RoleCommenter, http.StatusBadRequest, "", ""},
+		{"author is trusted", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "second"}}`, "3", models.RoleAuthor, http.StatusCreated, `"status":"approved"`, ""},
+		{"pending comments are hidden", http.MethodGet, "/posts/1/comments", "", "", "", http.StatusOK, `"comment_text":"second"`, `"comment_text":"first"`},
+		{"pending comment is not found", http.MethodGet, "/posts/1/comments/1", "", "", "", http.StatusNotFound, "", ""},
+		{"replies to a pending comment are hidden", http.MethodGet, "/posts/1/comments/1/replies", "", "", "", http.StatusNotFound, "", ""},
+		{"pending comment cannot be replied to", http.MethodPost, "/replies", `{"reply": {"post_id": "1", "comment_id": "1", "reply_text": "hi"}}`, "3", models.RoleAuthor, http.StatusNotFound, "", ""},
+		{"missing comment cannot be replied to", http.MethodPost, "/replies", `{"reply": {"post_id": "1", "comment_id": "9", "reply_text": "hi"}}`, "3", models.RoleAuthor, http.StatusNotFound, "", ""},
+		{"commenter cannot see the queue", http.MethodGet, "/moderation", "", "2", models.RoleCommenter, http.StatusForbidden, "", ""},
+		{"admin sees the queue", http.MethodGet, "/moderation", "", "1", models.RoleAdmin, http.StatusOK, `"comment_text":"first"`, ""},
+		{"admin sees pending comments", http.MethodGet, "/posts/1/comments/1", "", "1", models.RoleAdmin, http.StatusOK, `"status":"pending"`, ""},
+		{"admin sees replies to pending comments", http.MethodGet, "/posts/1/comments/1/replies", "", "1", models.RoleAdmin, http.StatusOK, "", ""},
+		{"rejects a bad status", http.MethodGet, "/moderation?status=live", "", "1", models.RoleAdmin, http.StatusUnprocessableEntity, "", ""},
+		{"approves in bulk", http.MethodPost, "/moderation", `{"status": "approved", "items": [{"post_id": "1", "comment_id": "1"}, {"post_id": "1", "comment_id": "9"}]}`, "1", models.RoleAdmin, http.StatusOK, `"updated":1,"failed":[{"post_id":"1","comment_id":"9"`, ""},
+		{"approved comment is shown", http.MethodGet, "/posts/1/comments/1", "", "", "", http.StatusOK, `"status":"approved"`, ""},
+		{"returning commenter is trusted", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "third"}}`, "2", models.RoleCommenter, http.StatusCreated, `"status":"approved"`, ""},
+		{"empty bulk request", http.MethodPost, "/moderation", `{"status": "spam"}`, "1", models.RoleAdmin, http.StatusUnprocessableEntity, "", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			if c.UID != "" {
+				storeUser(db, c.UID, c.Role)
+				authorizeAs(rq, c.UID)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+			if body := res.Body.String(); c.Hidden != "" && strings.Contains(body, c.Hidden) {
+				t.Errorf("got '%v', want it to leave out '%v'", body, c.Hidden)
+			}
+		})
+	}
+}
+
+// fakeSpam judges text by the verdicts it was given and records what it
+// learns and forgets.
+type fakeSpam struct {
+	verdicts map[string]string
+	learned  map[string]bool
+	err      error
+}
+
+func (f *fakeSpam) Check(ctx context.Context, s spam.Submission) (spam.Result, error) {
+	if f.err != nil {
+		return spam.Result{}, f.err
+	}
+	verdict := f.verdicts[s.Text]
+	if verdict == "" {
+		verdict = spam.Ham
+	}
+	return spam.Result{Score: 0.5, Verdict: verdict, Reasons: []string{"test"}}, nil
+}
+
+func (f *fakeSpam) Learn(ctx context.Context, s spam.Submission, isSpam bool) error {
+	f.learned[s.Text] = isSpam
+	return nil
+}
+
+func (f *fakeSpam) Forget(ctx context.Context, s spam.Submission) error {
+	delete(f.learned, s.Text)
+	return nil
+}
+
+func TestSpam(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1"})
+	ids := 0
+	checker := &fakeSpam{
+		verdicts: map[string]string{"buy now": spam.Spam, "maybe": spam.Unsure},
+		learned:  map[string]bool{},
+	}
+	handler := newTestHandler(&Env{
+		db:         db,
+		clock:      func() time.Time { return testClock().Add(time.Duration(ids) * time.Second) },
+		ids:        func(time.Time) string { ids++; return strconv.Itoa(ids) },
+		moderation: ApproveAll,
+		spam:       checker,
+	})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		UID    string
+		Role   string
+		Status int
+		Want   string
+		Hidden string
+	}{
+		{"ham is approved", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "hello"}}`, "2", models.RoleCommenter, http.StatusCreated, `"status":"approved"`, `"spam"`},
+		{"spam is held as spam", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "buy now"}}`, "2", models.RoleCommenter, http.StatusCreated, `"status":"spam"`, `"verdict"`},
+		{"unsure is held", http.MethodPost, "/posts/1/comments/1/replies", `{"reply": {"reply_text": "maybe"}}`, "2", models.RoleCommenter, http.StatusCreated, `"status":"pending"`, `"verdict"`},
+		{"moderators are not checked", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "buy now"}}`, "1", models.RoleAdmin, http.StatusCreated, `"status":"approved"`, `"verdict"`},
+		{"moderators see the verdict", http.MethodGet, "/moderation?status=spam", "", "1", models.RoleAdmin, http.StatusOK, `"spam":{"score":0.5,"verdict":"spam","reasons":["test"]}`, ""},
+		{"readers do not see the verdict", http.MethodGet, "/posts/1/comments", "", "", "", http.StatusOK, `"comment_text":"hello"`, `"verdict"`},
+		{"moderation teaches the checker", http.MethodPost, "/moderation", `{"status": "approved", "items": [{"post_id": "1", "comment_id": "2"}]}`, "1", models.RoleAdmin, http.StatusOK, `"updated":1`, ""},
+		{"failed moderation teaches nothing", http.MethodPost, "/moderation", `{"status": "spam", "items": [{"post_id": "1", "comment_id": "99"}]}`, "1", models.RoleAdmin, http.StatusOK, `"updated":0`, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			if c.UID != "" {
+				storeUser(db, c.UID, c.Role)
+				authorizeAs(rq, c.UID)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+			if body := res.Body.String(); c.Hidden != "" && strings.Contains(body, c.Hidden) {
+				t.Errorf("got '%v', want it to leave out '%v'", body, c.Hidden)
+			}
+		})
+	}
+
+	if isSpam, ok := checker.learned["buy now"]; !ok || isSpam {
+		t.Errorf("expected the checker to learn the comment is not spam, got %v", checker.learned)
+	}
+
+	// reversing a verdict replaces the lesson, rejecting forgets it
+	moderate := func(status string) {
+		rq, _ := http.NewRequest(http.MethodPost, "/moderation", bytes.NewBufferString(`{"status": "`+status+`", "items": [{"post_id": "1", "comment_id": "2"}]}`))
+		authorize(rq)
+		handler.ServeHTTP(httptest.NewRecorder(), rq)
+	}
+	moderate(models.StatusSpam)
+	if isSpam, ok := checker.learned["buy now"]; !ok || !isSpam {
+		t.Errorf("expected the checker to learn the comment is spam, got %v", checker.learned)
+	}
+	moderate(models.StatusRejected)
+	if _, ok := checker.learned["buy now"]; ok {
+		t.Errorf("expected the checker to forget the comment, got %v", checker.learned)
+	}
+
+	// a failing checker lets comments through
+	checker.err = errors.New("broken")
+	rq, _ := http.NewRequest(http.MethodPost, "/posts/1/comments", bytes.NewBufferString(`{"comment": {"comment_text": "buy now"}}`))
+	authorizeAs(rq, "2")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, rq)
+	if status := res.Code; status != http.StatusCreated {
+		t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusCreated)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := newTestHandler(&Env{
+		db:      newTestDB(),
+		limiter: ht.NewRateLimiter(ht.RateLimitConfig{Write: ht.Limit{Rate: 1, Burst: 1}, Now: testClock}),
+	})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Status int
+		Want   string
+	}{
+		{"reads are not limited", http.MethodGet, http.StatusOK, ""},
+		{"first write", http.MethodPost, http.StatusCreated, ""},
+		{"second write", http.MethodPost, http.StatusTooManyRequests, `"status":"Too many requests."`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, "/posts", bytes.NewBufferString(`{"post": {"title": "t", "post_text": "text"}}`))
+			authorize(rq)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
+func TestRateLimitHealth(t *testing.T) {
+	handler := newTestHandler(&Env{
+		db:      newTestDB(),
+		limiter: ht.NewRateLimiter(ht.RateLimitConfig{Read: ht.Limit{Rate: 1, Burst: 1}, Now: testClock}),
+	})
+
+	cases := []struct {
+		Name   string
+		Path   string
+		Status int
+	}{
+		{"first read", "/posts", http.StatusOK},
+		{"second read", "/posts", http.StatusTooManyRequests},
+		{"health checks are not limited", "/health", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			rq.RemoteAddr = "192.0.2.1:1234"
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+		})
+	}
+}
+
+func TestThreadedReplies(t *testing.T) {
+	db := newTestDB()
+	ctx := context.Background()
+	db.DBCreatePost(ctx, &models.Post{ID: "1"})
+	db.DBCreateComment(ctx, &models.Comment{ID: "1", PostID: "1", Status: models.StatusApproved})
+	ids := 1
+	handler := newTestHandler(&Env{
+		db:            db,
+		clock:         func() time.Time { return testClock().Add(time.Duration(ids) * time.Second) },
+		ids:           func(time.Time) string { ids++; return strconv.Itoa(ids) },
+		maxReplyDepth: 2,
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rq, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		authorize(rq)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, rq)
+		return res
+	}
+
+	cases := []struct {
+		Name   string
+		Body   string
+		Status int
+		Want   string
+	}{
+		{"replies to the comment", `{"reply": {"reply_text": "a"}}`, http.StatusCreated, `"reply_id":"2","depth":0`},
+		{"replies to a reply", `{"reply": {"reply_text": "b", "parent_id": "2"}}`, http.StatusCreated, `"reply_id":"3","parent_id":"2","depth":1`},
+		{"replies at the deepest level", `{"reply": {"reply_text": "c", "parent_id": "3"}}`, http.StatusCreated, `"reply_id":"4","parent_id":"3","depth":2`},
+		{"too deep", `{"reply": {"reply_text": "d", "parent_id": "4"}}`, http.StatusUnprocessableEntity, `"field":"reply.parent_id"`},
+		{"unknown parent", `{"reply": {"reply_text": "d", "parent_id": "99"}}`, http.StatusUnprocessableEntity, `"field":"reply.parent_id"`},
+		{"depth cannot be chosen", `{"reply": {"reply_text": "d", "depth": 1}}`, http.StatusBadRequest, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			res := do(http.MethodPost, "/posts/1/comments/1/replies", c.Body)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+
+	thread := func() []*ReplyPayload {
+		var got []*ReplyPayload
+		res := do(http.MethodGet, "/posts/1/comments/1/replies", "")
+		if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+			t.Fatalf("unable to parse replies '%v'", err)
+		}
+		return got
+	}
+	path := func(replyID string) string {
+		replies, _ := db.DBGetReplies(ctx, "1", "1")
+		for _, reply := range replies {
+			if reply.ReplyID == replyID {
+				return "/posts/1/comments/1/replies/" + reply.ReplyDate.Format(time.RFC3339Nano)
+			}
+		}
+		t.Fatalf("no reply %v", replyID)
+		return ""
+	}
+
+	got := thread()
+	if len(got) != 1 || len(got[0].Replies) != 1 || len(got[0].Replies[0].Replies) != 1 {
+		t.Fatalf("expected one thread three deep, got %v", got)
+	}
+
+	// an answered reply is tombstoned
+	if res := do(http.MethodDelete, path("2"), ""); res.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got '%v' want '%v'", res.Code, http.StatusNoContent)
+	}
+	got = thread()
+	if len(got) != 1 || !got[0].Reply.Deleted || got[0].Reply.ReplyText != "" || got[0].Reply.User != nil || len(got[0].Replies) != 1 {
+		t.Errorf("expected a tombstone holding the thread, got %v", got)
+	}
+
+	// the tombstone goes with the last reply below it
+	do(http.MethodDelete, path("4"), "")
+	do(http.MethodDelete, path("3"), "")
+	if replies, _ := db.DBGetReplies(ctx, "1", "1"); len(replies) != 0 {
+		t.Errorf("expected every reply to be deleted, got %v", replies)
+	}
+	if got = thread(); len(got) != 0 {
+		t.Errorf("expected no replies, got %v", got)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	db := newTestDB()
+	ctx := context.Background()
+	db.DBCreatePost(ctx, &models.Post{ID: "1", PostedDate: testClock()})
+	db.DBCreateComment(ctx, &models.Comment{ID: "1", PostID: "1", CommentDate: testClock(), Status: models.StatusApproved})
+	handler := newTestHandler(&Env{db: db})
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		UID    string
+		Role   string
+		Status int
+		Want   string
+	}{
+		{"must be signed in", http.MethodPost, "/posts/1/reactions", `{"type": "like"}`, "", "", http.StatusUnauthorized, ""},
+		{"banned users cannot react", http.MethodPost, "/posts/1/reactions", `{"type": "like"}`, "2", models.RoleBanned, http.StatusForbidden, ""},
+		{"unknown reaction", http.MethodPost, "/posts/1/reactions", `{"type": "meh"}`, "2", models.RoleCommenter, http.StatusUnprocessableEntity, `"field":"type"`},
+		{"likes a post", http.MethodPost, "/posts/1/reactions", `{"type": "like"}`, "2", models.RoleCommenter, http.StatusCreated, `"type":"like"`},
+		{"one reaction per user", http.MethodPost, "/posts/1/reactions", `{"type": "love"}`, "2", models.RoleCommenter, http.StatusConflict, ""},
+		{"loves a comment", http.MethodPost, "/posts/1/comments/1/reactions", `{"type": "love"}`, "2", models.RoleCommenter, http.StatusCreated, ""},
+		{"others see the counts", http.MethodGet, "/posts/1", "", "", "", http.StatusOK, `"reactions":{"like":1}`},
+		{"user sees their reaction", http.MethodGet, "/posts/1", "", "2", models.RoleCommenter, http.StatusOK, `"reaction":"like"`},
+		{"user sees their comment reaction", http.MethodGet, "/posts/1/comments", "", "2", models.RoleCommenter, http.StatusOK, `"reactions":{"love":1}},"reaction":"love"`},
+		{"removes the reaction", http.MethodDelete, "/posts/1/reactions", "", "2", models.RoleCommenter, http.StatusNoContent, ""},
+		{"nothing left to remove", http.MethodDelete, "/posts/1/reactions", "", "2", models.RoleCommenter, http.StatusNotFound, ""},
+		{"counts cannot be set", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "hi", "reactions": {"like": 100}}}`, "2", models.RoleCommenter, http.StatusBadRequest, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(c.Method, c.Path, bytes.NewBufferString(c.Body))
+			if c.UID != "" {
+				storeUser(db, c.UID, c.Role)
+				authorizeAs(rq, c.UID)
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
+// testUpload returns a multipart body uploading data as the file field,
+// along with its content type.
+func testUpload(data []byte, alt string) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if data != nil {
+		fw, _ := mw.CreateFormFile("file", "upload")
+		fw.Write(data)
+	}
+	mw.WriteField("alt", alt)
+	mw.Close()
+	return &body, mw.FormDataContentType()
+}
+
+func TestMedia(t *testing.T) {
+	root, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(root)
+	env := &Env{
+		db:           newTestDB(),
+		blobs:        blob.NewDir(root, "/media/files/"),
+		clock:        testClock,
+		ids:          testIDs,
+		maxMediaSize: 64 << 10,
+	}
+	handler := newTestHandler(env)
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 640, 480)))
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Data   []byte
+		Body   string
+		Role   string
+		Status int
+		Want   string
+	}{
+		{"must be signed in", http.MethodPost, "/media", img.Bytes(), "", "", http.StatusUnauthorized, ""},
+		{"commenters cannot upload", http.MethodPost, "/media", img.Bytes(), "", models.RoleCommenter, http.StatusForbidden, ""},
+		{"file is required", http.MethodPost, "/media", nil, "", models.RoleAuthor, http.StatusUnprocessableEntity, `"field":"file"`},
+		{"only images", http.MethodPost, "/media", []byte("<html></html>"), "", models.RoleAuthor, http.StatusUnsupportedMediaType, ""},
+		{"not too large", http.MethodPost, "/media", make([]byte, 128<<10), "", models.RoleAuthor, http.StatusRequestEntityTooLarge, `"file may be at most 65536 bytes"`},
+		{"not too large within the form", http.MethodPost, "/media", make([]byte, 96<<10), "", models.RoleAuthor, http.StatusRequestEntityTooLarge, `"file may be at most 65536 bytes"`},
+		{"uploads an image", http.MethodPost, "/media", img.Bytes(), "", models.RoleAuthor, http.StatusCreated,
+			`"url":"/media/files/media/1.png","thumbnail_key":"media/1_thumb.png","thumbnail_url":"/media/files/media/1_thumb.png","content_type":"image/png"`},
+		{"gets the media", http.MethodGet, "/media/1", nil, "", "", http.StatusOK, `"width":640,"height":480,"alt":"a view"`},
+		{"lists the media", http.MethodGet, "/media", nil, "", "", http.StatusOK, `[{"media":{"id":"1"`},
+		{"serves the thumbnail", http.MethodGet, "/media/files/media/1_thumb.png", nil, "", "", http.StatusOK, ""},
+		{"does not list files", http.MethodGet, "/media/files/media/", nil, "", "", http.StatusNotFound, ""},
+		{"posts show media", http.MethodPost, "/posts", nil, `{"post": {"title": "t", "post_text": "p", "media_id": "1"}}`, models.RoleAuthor, http.StatusCreated,
+			`"image_location":"/media/files/media/1.png"`},
+		{"posts keep showing media", http.MethodPut, "/posts/1", nil, `{"post": {"title": "t2", "post_text": "p", "media_id": "1", "version": 0}}`, models.RoleAuthor, http.StatusOK,
+			`"image_location":"/media/files/media/1.png"`},
+		{"posts show uploaded media only", http.MethodPost, "/posts", nil, `{"post": {"title": "t", "post_text": "p", "media_id": "2"}}`, models.RoleAuthor, http.StatusUnprocessableEntity,
+			`"field":"post.media_id"`},
+		{"deletes the media", http.MethodDelete, "/media/1", nil, "", models.RoleAuthor, http.StatusNoContent, ""},
+		{"media is gone", http.MethodGet, "/media/1", nil, "", "", http.StatusNotFound, ""},
+		{"thumbnail is gone", http.MethodGet, "/media/files/media/1_thumb.png", nil, "", "", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			body, contentType := bytes.NewBufferString(c.Body), "application/json"
+			if c.Method == http.MethodPost && c.Path == "/media" {
+				body, contentType = testUpload(c.Data, "a view")
+			}
+			rq, _ := http.NewRequest(c.Method, c.Path, body)
+			rq.Header.Set("Content-Type", contentType)
+			if c.Role != "" {
+				storeUser(env.db, "2", c.Role)
+				authorizeAs(rq, "2")
+			}
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+			if c.Name == "serves the thumbnail" {
+				thumb, err := png.DecodeConfig(res.Body)
+				if err != nil || thumb.Width != 320 || thumb.Height != 240 {
+					t.Errorf("expected a 320x240 thumbnail, got %v %v", thumb, err)
+				}
+			}
+		})
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1", Title: "Title 1", PostedDate: testClock()})
+	handler := newTestHandler(&Env{db: db})
+
+	cases := []struct {
+		Name        string
+		Path        string
+		Accept      string
+		Status      int
+		ContentType string
+		Want        string
+	}{
+		{"json by default", "/posts/1", "", http.StatusOK, "application/json; charset=utf-8", `"title":"Title 1"`},
+		{"xml", "/posts/1", "application/xml", http.StatusOK, "application/xml; charset=utf-8", "<title>Title 1</title>"},
+		{"cbor", "/posts/1", "application/cbor", http.StatusOK, "application/cbor", "\x65title\x67Title 1"},
+		{"errors are negotiated too", "/posts/2", "application/xml", http.StatusNotFound, "application/xml; charset=utf-8", "<status>Resource not found.</status>"},
+		{"nothing acceptable", "/posts/1", "text/html", http.StatusNotAcceptable, "application/json; charset=utf-8", `"status":"Not acceptable."`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, c.Path, nil)
+			rq.Header.Set("Accept", c.Accept)
+			res := httptest.NewRecorder()
+
+			handler.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if ct := res.Header().Get("Content-Type"); ct != c.ContentType {
+				t.Errorf("got content type '%v' want '%v'", ct, c.ContentType)
+			}
+			if body := res.Body.String(); !strings.Contains(body, c.Want) {
+				t.Errorf("got '%v', want it to contain '%v'", body, c.Want)
+			}
+		})
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1", Title: "Title 1", PostText: "Text 1", Author: models.DefaultAuthor, PostedDate: testClock()})
+	handler := newTestHandler(&Env{db: db, cacheControl: defaultCachePolicies})
+
+	serve := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		rq, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		for k, v := range header {
+			rq.Header.Set(k, v)
+		}
+		if method != http.MethodGet {
+			authorize(rq)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, rq)
+		return res
+	}
+
+	res := serve(http.MethodGet, "/posts/1", "", nil)
+	tag := res.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `"v0-`) || res.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("expected an etag of version 0 and a cache policy, got %v", res.Header())
+	}
+	list := serve(http.MethodGet, "/posts", "", nil).Header().Get("ETag")
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Header map[string]string
+		Status int
+	}{
+		{"unchanged post", http.MethodGet, "/posts/1", "", map[string]string{"If-None-Match": tag}, http.StatusNotModified},
+		{"unchanged list", http.MethodGet, "/posts", "", map[string]string{"If-None-Match": list}, http.StatusNotModified},
+		{"stale edit", http.MethodPut, "/posts/1", `{"post": {"title": "Title 2", "post_text": "Text 2"}}`, map[string]string{"If-Match": `"stale"`}, http.StatusPreconditionFailed},
+		{"edit of another version", http.MethodPut, "/posts/1", `{"post": {"title": "Title 2", "post_text": "Text 2"}}`, map[string]string{"If-Match": `"v1-` + tag[4:]}, http.StatusPreconditionFailed},
+		{"comment", http.MethodPost, "/posts/1/comments", `{"comment": {"comment_text": "hi"}}`, nil, http.StatusCreated},
+		{"commented post", http.MethodGet, "/posts/1", "", map[string]string{"If-None-Match": tag}, http.StatusOK},
+		{"comments do not make edits stale", http.MethodPut, "/posts/1", `{"post": {"title": "Title 2", "post_text": "Text 2"}}`, map[string]string{"If-Match": tag}, http.StatusOK},
+		{"same edit again", http.MethodPut, "/posts/1", `{"post": {"title": "Title 3", "post_text": "Text 3"}}`, map[string]string{"If-Match": tag}, http.StatusPreconditionFailed},
+		{"changed list", http.MethodGet, "/posts", "", map[string]string{"If-None-Match": list}, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			res := serve(c.Method, c.Path, c.Body, c.Header)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+		})
+	}
+
+	// the edit is tagged with the version it made
+	if tag := serve(http.MethodGet, "/posts/1", "", nil).Header().Get("ETag"); !strings.HasPrefix(tag, `"v1-`) {
+		t.Errorf("expected an etag of version 1, got %v", tag)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1", Title: "Title 1", PostText: "Text 1", Author: models.DefaultAuthor, PostedDate: testClock()})
+	cache := models.NewCache(m.Instrument(db), models.CacheConfig{TTL: models.DefaultCacheTTLs})
+	m.ObserveCache(cache)
+	handler := newTestHandler(&Env{db: cache, metrics: m})
+
+	for _, path := range []string{"/posts/1", "/posts/1", "/posts/2", "/nope"} {
+		rq, _ := http.NewRequest(http.MethodGet, path, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), rq)
+	}
+
+	rq, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, rq)
+	if status := res.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusOK)
+	}
+
+	body := res.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/posts/{postID}",status="200"} 2`,
+		`http_requests_total{method="GET",route="/posts/{postID}",status="404"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_requests_in_flight 1`,
+		`datastore_request_duration_seconds_count{method="DBGetPost"} 2`,
+		`datastore_errors_total{method="DBGetPost",kind="not_found"} 1`,
+		`datastore_cache_hits_total{method="DBGetPost"} 1`,
+		`datastore_cache_misses_total{method="DBGetPost"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected the metrics to include %s, got\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `route="/posts/1`) {
+		t.Errorf("expected requests to be labeled by route pattern, got\n%s", body)
+	}
+}
+
+// spanRecorder keeps the spans exported to it.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []trace.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	db := newTestDB()
+	db.DBCreatePost(context.Background(), &models.Post{ID: "1", Title: "Title 1", PostText: "Text 1", Author: models.DefaultAuthor, PostedDate: testClock()})
+	rec := &spanRecorder{}
+	tracer := trace.NewTracer(trace.Config{Exporter: rec, SampleRatio: 1})
+	handler := newTestHandler(&Env{db: traceDatastore(db), tracer: tracer})
+
+	rq, _ := http.NewRequest(http.MethodGet, "/posts/1", nil)
+	rq.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, rq)
+	tracer.Close()
+
+	if status := res.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusOK)
+	}
+	if !strings.HasPrefix(res.Header().Get("traceparent"), "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("expected the response to continue the trace, got %q", res.Header().Get("traceparent"))
+	}
+
+	spans := map[string]trace.SpanData{}
+	for _, s := range rec.spans {
+		spans[s.Name] = s
+	}
+	// each span and the span it was started in
+	cases := []struct {
+		Span   string
+		Parent string
+	}{
+		{"PostCtx", "GET /posts/{postID}"},
+		{"DBGetPost", "PostCtx"},
+		{"NewPostPayloadResponse", "GET /posts/{postID}"},
+		{"DBGetComments", "NewPostPayloadResponse"},
+		{"render", "GET /posts/{postID}"},
+	}
+	for _, c := range cases {
+		span, ok := spans[c.Span]
+		if !ok {
+			t.Errorf("expected a %s span, got %v", c.Span, spans)
+			continue
+		}
+		if parent := spans[c.Parent]; span.Parent != parent.SpanContext.SpanID || span.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("expected %s to be within %s", c.Span, c.Parent)
+		}
+	}
+}
+
+// panickingDB panics on every call, as its Datastore is nil.
+type panickingDB struct {
+	models.Datastore
+}
+
+func TestPanicRecovery(t *testing.T) {
+	reported := make(chan ht.Panic, 1)
+	env := &Env{
+		db:        panickingDB{},
+		errorSink: ht.ErrorSinkFunc(func(p ht.Panic) { reported <- p }),
+	}
+	handler := newTestHandler(env)
+
+	rq, _ := http.NewRequest(http.MethodGet, "/posts/1", nil)
+	rq.Header.Set("Accept", "application/xml")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, rq)
+
+	if status := res.Code; status != http.StatusInternalServerError {
+		t.Fatalf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusInternalServerError)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected a JSON body, got %q", res.Body.String())
+	}
+	p := <-reported
+	if p.RequestID == "" || body["request_id"] != p.RequestID || p.URL != "/posts/1" {
+		t.Errorf("expected the response and the report to share the request id, got %v and %+v", body, p)
+	}
 }
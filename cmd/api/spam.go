@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	"github.com/corymhall/blog-backend-go/pkg/spam"
+	"github.com/rs/zerolog"
+)
+
+// SpamChecker scores new comments and replies before they are stored, and
+// learns from what moderators decide about them. Forget undoes Learn when a
+// moderator changes their mind. spam.Filter is the built in implementation.
+type SpamChecker interface {
+	Check(ctx context.Context, s spam.Submission) (spam.Result, error)
+	Learn(ctx context.Context, s spam.Submission, isSpam bool) error
+	Forget(ctx context.Context, s spam.Submission) error
+}
+
+// checkSpam runs the spam checker over text written by user and returns its
+// verdict along with the status the item should be stored in. Spam goes
+// straight to the spam queue and anything the checker is unsure about waits
+// for a moderator. Moderators are not checked. When the checker fails the
+// item keeps status, a broken checker should not stop people commenting.
+func (env *Env) checkSpam(ctx context.Context, user *models.User, text, status string, logger zerolog.Logger) (*models.SpamCheck, string) {
+	if env.spam == nil || can(user, PermModerate) {
+		return nil, status
+	}
+
+	res, err := env.spam.Check(ctx, submission(user, text))
+	if err != nil {
+		logger.Warn().Err(err).Msg("unable to check for spam")
+		return nil, status
+	}
+
+	switch res.Verdict {
+	case spam.Spam:
+		status = models.StatusSpam
+	case spam.Unsure:
+		if status == models.StatusApproved {
+			status = models.StatusPending
+		}
+	}
+	return &models.SpamCheck{Score: res.Score, Verdict: res.Verdict, Reasons: res.Reasons}, status
+}
+
+// learnSpam teaches the spam checker that a moderator moved item, made of s,
+// from previous to status. Only approving and marking as spam teach it
+// anything; rejected items may be off topic without being spam. Moving an
+// item out of either status first forgets what the checker was taught.
+func (env *Env) learnSpam(ctx context.Context, item ModerationItem, s spam.Submission, previous, status string, logger zerolog.Logger) {
+	if env.spam == nil || previous == status {
+		return
+	}
+
+	if teachesSpam(previous) {
+		if err := env.spam.Forget(ctx, s); err != nil {
+			logger.Warn().Err(err).Str("post_id", item.PostID).Str("comment_id", item.CommentID).Msg("unable to untrain spam checker")
+		}
+	}
+	if teachesSpam(status) {
+		if err := env.spam.Learn(ctx, s, status == models.StatusSpam); err != nil {
+			logger.Warn().Err(err).Str("post_id", item.PostID).Str("comment_id", item.CommentID).Msg("unable to train spam checker")
+		}
+	}
+}
+
+// teachesSpam reports whether moving an item to status teaches the spam
+// checker something.
+func teachesSpam(status string) bool {
+	return status == models.StatusSpam || status == models.StatusApproved
+}
+
+func submission(user *models.User, text string) spam.Submission {
+	s := spam.Submission{Text: text}
+	if user != nil {
+		s.Author = user.ProviderID + "#" + user.UID
+	}
+	return s
+}
+
+// redactComment hides the spam verdict of comment from everyone but
+// moderators, so spammers cannot tune their messages against it.
+func redactComment(ctx context.Context, comment *models.Comment) *models.Comment {
+	if comment.Spam == nil || can(userFromContext(ctx), PermModerate) {
+		return comment
+	}
+	c := *comment
+	c.Spam = nil
+	return &c
+}
+
+// redactReply is redactComment for replies.
+func redactReply(ctx context.Context, reply *models.Reply) *models.Reply {
+	if reply.Spam == nil || can(userFromContext(ctx), PermModerate) {
+		return reply
+	}
+	r := *reply
+	r.Spam = nil
+	return &r
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/cmd/api/models"
+	"github.com/corymhall/blog-backend-go/pkg/trace"
+)
+
+// serviceName names the api in the traces it exports.
+const serviceName = "blog-backend-go"
+
+// TracingConfig says where the spans of traced requests are exported to.
+type TracingConfig struct {
+	// Exporter is none, file or otlp.
+	Exporter string
+	// File is where the file exporter appends spans as JSON lines.
+	File string
+	// Endpoint is the URL the otlp exporter posts spans to.
+	Endpoint string
+	// Headers are sent with every otlp export, as comma separated
+	// name=value pairs.
+	Headers string
+	// SampleRatio is the share of traces started by the api recorded.
+	SampleRatio float64
+	// OnError is called with the errors of the exporter.
+	OnError func(error)
+}
+
+// NewTracer returns the tracer cfg asks for, or nil when nothing is traced.
+func NewTracer(cfg TracingConfig) (*trace.Tracer, error) {
+	var exporter trace.Exporter
+	switch cfg.Exporter {
+	case "", "none":
+		return nil, nil
+	case "file":
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exporter = trace.NewJSONLinesExporter(f)
+	case "otlp":
+		headers := map[string]string{}
+		for _, h := range strings.Split(cfg.Headers, ",") {
+			if strings.TrimSpace(h) == "" {
+				continue
+			}
+			i := strings.IndexByte(h, '=')
+			if i < 1 {
+				return nil, fmt.Errorf("expected otlp headers as name=value, got %q", h)
+			}
+			headers[strings.TrimSpace(h[:i])] = strings.TrimSpace(h[i+1:])
+		}
+		exporter = trace.NewOTLPExporter(trace.OTLPConfig{
+			Endpoint:    cfg.Endpoint,
+			Headers:     headers,
+			ServiceName: serviceName,
+		})
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected none, file or otlp", cfg.Exporter)
+	}
+	return trace.NewTracer(trace.Config{
+		Exporter:    exporter,
+		SampleRatio: cfg.SampleRatio,
+		OnError:     cfg.OnError,
+	}), nil
+}
+
+// traceDatastore returns db recording a span for each call to it, within
+// the span of the request that made it.
+func traceDatastore(db models.Datastore) models.Datastore {
+	return &models.Instrumented{Datastore: db, Observe: observeSpan}
+}
+
+func observeSpan(ctx context.Context, method string, start time.Time, err error) {
+	_, span := trace.StartAt(ctx, method, start)
+	span.SetAttribute("db.operation", method)
+	span.SetError(err)
+	span.End()
+}
@@ -14,7 +14,9 @@ import (
 func userRouter(env *Env) chi.Router {
 	r := chi.NewRouter()
 
-	r.Post("/", func(w http.ResponseWriter, rq *http.Request) {
+	// a user's role decides what they may do, so only admins add users.
+	// Posting a user again changes them, e.g. to ban them.
+	r.With(Require(PermWriteUsers)).Post("/", func(w http.ResponseWriter, rq *http.Request) {
 		logger := ht.Logger(rq)
 		env.CreateUser(w, rq, logger)
 	})
@@ -25,6 +27,11 @@ func userRouter(env *Env) chi.Router {
 			logger := ht.Logger(rq)
 			GetUser(w, rq, logger)
 		})
+		// posts are attributed to the author's display name
+		r.With(env.PostsCtx).Get("/posts", func(w http.ResponseWriter, rq *http.Request) {
+			logger := ht.Logger(rq)
+			GetPosts(w, rq, logger, env)
+		})
 	})
 
 	return r
@@ -37,13 +44,13 @@ func (env *Env) UserCtx(next http.Handler) http.Handler {
 		var err error
 
 		if userID := chi.URLParam(rq, "userID"); userID != "" {
-			user, err = env.db.DBGetUser(userID)
+			user, err = env.db.DBGetUser(rq.Context(), userID)
 		} else {
 			render.Render(w, rq, render.ErrNotFound, logger)
 			return
 		}
 		if err != nil {
-			render.Render(w, rq, render.ErrNotFound, logger)
+			render.Render(w, rq, ErrDatastore(err), logger)
 			return
 		}
 		ctx := context.WithValue(rq.Context(), "user", user)
@@ -51,6 +58,8 @@ func (env *Env) UserCtx(next http.Handler) http.Handler {
 	})
 }
 
+// CreateUser stores a user. Each identity is stored once, so a user whose
+// identity is already stored replaces the stored user, keeping its ID.
 func (env *Env) CreateUser(w http.ResponseWriter, rq *http.Request, logger zerolog.Logger) {
 
 	data := &UserPayload{}
@@ -60,12 +69,27 @@ func (env *Env) CreateUser(w http.ResponseWriter, rq *http.Request, logger zerol
 	}
 
 	user := data.User
-	if err := env.db.DBCreateUser(user); err != nil {
-		render.Render(w, rq, render.ErrInternalServerError(err), logger)
+	if user.ID != "" {
+		render.Render(w, rq, render.ErrInvalidRequest(errServerAssigned("id")), logger)
+		return
+	}
+	stored, err := env.db.DBGetUserByOwner(rq.Context(), user)
+	switch err {
+	case nil:
+		user.ID = stored.ID
+	case models.ErrNotFound:
+		user.ID = env.newID(env.now())
+		render.Status(rq, http.StatusCreated)
+	default:
+		render.Render(w, rq, ErrDatastore(err), logger)
+		return
+	}
+
+	if err := env.db.DBCreateUser(rq.Context(), user); err != nil {
+		render.Render(w, rq, ErrDatastore(err), logger)
 		return
 	}
 
-	render.Status(rq, http.StatusCreated)
 	render.Render(w, rq, NewUserPayloadResponse(user), logger)
 }
 
@@ -91,6 +115,20 @@ func (p *UserPayload) Bind(r *http.Request) error {
 	return nil
 }
 
+// Validate checks the user's fields, which sit at the top level of the payload.
+func (p *UserPayload) Validate(v *render.Validation) {
+	user := p.User
+	if user == nil {
+		user = &models.User{}
+	}
+	v.Required("display_name", user.DisplayName)
+	v.MaxLength("display_name", user.DisplayName, 100)
+	v.Required("email", user.Email)
+	v.Email("email", user.Email)
+	v.URL("photo_url", user.PhotoURL)
+	v.OneOf("role", user.Role, models.Roles...)
+}
+
 func NewUserPayloadResponse(user *models.User) *UserPayload {
 	resp := &UserPayload{user}
 
@@ -0,0 +1,108 @@
+package blob
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestDir(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "media")
+	d := NewDir(root, "/media/files/")
+	if _, err := os.Stat(root); !os.IsNotExist(err) {
+		t.Errorf("expected the directory to be created on the first put, got %v", err)
+	}
+	ctx := context.Background()
+
+	if err := d.Put(ctx, "media/1.png", []byte("png"), "image/png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(root, "media", "1.png")); string(b) != "png" {
+		t.Errorf("expected the blob to be written, got %q", b)
+	}
+	if url := d.URL("media/1 2.png"); url != "/media/files/media/1%202.png" {
+		t.Errorf("expected an escaped url, got %v", url)
+	}
+
+	for path, status := range map[string]int{"/media/1.png": http.StatusOK, "/media/": http.StatusNotFound, "/media": http.StatusNotFound, "/": http.StatusNotFound} {
+		res := httptest.NewRecorder()
+		d.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		if res.Code != status {
+			t.Errorf("expected %s to be served with %d, got %d", path, status, res.Code)
+		}
+	}
+
+	for _, key := range []string{"", "../1.png", "media/../../1.png", "/etc/passwd"} {
+		if err := d.Put(ctx, key, nil, "image/png"); err != errInvalidKey {
+			t.Errorf("expected key %q to be refused", key)
+		}
+	}
+
+	if err := d.Delete(ctx, "media/1.png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := d.Delete(ctx, "media/1.png"); err != nil {
+		t.Errorf("expected deleting a missing blob to succeed, got %v", err)
+	}
+}
+
+func TestS3(t *testing.T) {
+	var got *http.Request
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		if strings.Contains(r.URL.Path, "denied") {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+		}
+	}))
+	defer srv.Close()
+
+	s := NewS3(S3Config{
+		Bucket:      "media",
+		Region:      "us-east-2",
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		Endpoint:    srv.URL,
+	})
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "media/1.png", []byte("png"), "image/png"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got.Method != http.MethodPut || got.URL.Path != "/media/media/1.png" || body != "png" {
+		t.Errorf("expected the object to be put, got %v %v %q", got.Method, got.URL.Path, body)
+	}
+	if auth := got.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=id/") {
+		t.Errorf("expected a signed request, got %q", auth)
+	}
+	if got.Header.Get("X-Amz-Content-Sha256") == "" || got.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("expected content headers, got %v", got.Header)
+	}
+
+	if err := s.Delete(ctx, "media/1.png"); err != nil || got.Method != http.MethodDelete {
+		t.Errorf("expected the object to be deleted, got %v %v", got.Method, err)
+	}
+	if err := s.Put(ctx, "denied.png", []byte("png"), "image/png"); err == nil {
+		t.Errorf("expected an error from a failed put")
+	}
+
+	if url := s.URL("media/1.png"); url != srv.URL+"/media/media/1.png" {
+		t.Errorf("unexpected url %v", url)
+	}
+	if url := NewS3(S3Config{Bucket: "media", Region: "us-east-2"}).URL("1.png"); url != "https://media.s3.us-east-2.amazonaws.com/1.png" {
+		t.Errorf("unexpected url %v", url)
+	}
+}
@@ -0,0 +1,137 @@
+// Package blob stores files, such as uploaded images, and hands out the URLs
+// they are served from. Dir keeps them on the local filesystem for
+// development and S3 keeps them in an Amazon S3 bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errInvalidKey = errors.New("blob: invalid key")
+
+// Dir stores blobs as files below a directory. Serve Handler at baseURL for
+// the URLs it hands out to work.
+type Dir struct {
+	root    string
+	baseURL string
+}
+
+// NewDir returns a Dir storing blobs below root. baseURL is where root is
+// served from. root is only created once the first blob is stored, so a
+// Dir can be set up where nothing may be written, as long as nothing is.
+func NewDir(root, baseURL string) *Dir {
+	return &Dir{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// Root returns the directory blobs are stored in.
+func (d *Dir) Root() string {
+	return d.root
+}
+
+// Handler serves the blobs by key, e.g. below baseURL with
+// http.StripPrefix. Directories are not listed, they are not found.
+func (d *Dir) Handler() http.Handler {
+	return http.FileServer(filesOnly{http.Dir(d.root)})
+}
+
+// filesOnly is a file system that hides its directories.
+type filesOnly struct {
+	fs http.FileSystem
+}
+
+func (f filesOnly) Open(name string) (http.File, error) {
+	file, err := f.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+// Put stores data as key. Keys are slash separated paths below the
+// directory. The content type is not kept, files are served with the type
+// their extension implies.
+func (d *Dir) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see half a file
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete removes key. Removing a key that does not exist is not an error.
+func (d *Dir) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// URL returns where key is served from.
+func (d *Dir) URL(key string) string {
+	return d.baseURL + "/" + escapeKey(key)
+}
+
+// path returns the file key is stored in, refusing keys that would escape
+// the directory.
+func (d *Dir) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errInvalidKey
+	}
+	return filepath.Join(d.root, clean), nil
+}
+
+// escapeKey escapes each segment of a slash separated key for use in a URL.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,112 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+)
+
+// S3Config configures an S3 store.
+type S3Config struct {
+	Bucket string
+	Region string
+
+	// Credentials sign requests to S3, e.g. those of an aws session.
+	Credentials *credentials.Credentials
+
+	// Endpoint, when set, is used instead of the bucket's virtual host,
+	// with the bucket in the path. This is for S3 compatible services and
+	// tests.
+	Endpoint string
+
+	// BaseURL is where objects are served from, e.g. a CDN in front of the
+	// bucket. When empty objects are served by S3 itself, so the bucket
+	// must allow public reads.
+	BaseURL string
+
+	// Client sends requests to S3. When nil http.DefaultClient is used.
+	Client *http.Client
+}
+
+// S3 stores blobs as objects in an Amazon S3 bucket. It talks to the S3 REST
+// api directly, with requests signed by the aws signature version 4 signer.
+type S3 struct {
+	cfg    S3Config
+	signer *v4.Signer
+}
+
+// NewS3 returns an S3 store using cfg.
+func NewS3(cfg S3Config) *S3 {
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = cfg.objectBase()
+	}
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	return &S3{cfg: cfg, signer: v4.NewSigner(cfg.Credentials)}
+}
+
+func (cfg S3Config) objectBase() string {
+	if cfg.Endpoint != "" {
+		return strings.TrimSuffix(cfg.Endpoint, "/") + "/" + cfg.Bucket
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com", cfg.Bucket, cfg.Region)
+}
+
+// Put stores data as the object key.
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	rq, err := http.NewRequest(http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", contentType)
+	return s.do(ctx, rq, bytes.NewReader(data))
+}
+
+// Delete removes the object key. Removing an object that does not exist is
+// not an error.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	rq, err := http.NewRequest(http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, rq, nil)
+}
+
+// URL returns where the object key is served from.
+func (s *S3) URL(key string) string {
+	return s.cfg.BaseURL + "/" + escapeKey(key)
+}
+
+func (s *S3) objectURL(key string) string {
+	return s.cfg.objectBase() + "/" + escapeKey(key)
+}
+
+// do signs and sends rq, whose body is also given as body for signing.
+func (s *S3) do(ctx context.Context, rq *http.Request, body io.ReadSeeker) error {
+	rq = rq.WithContext(ctx)
+	if _, err := s.signer.Sign(rq, body, "s3", s.cfg.Region, time.Now()); err != nil {
+		return err
+	}
+
+	res, err := s.cfg.Client.Do(rq)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("blob: s3 %s %s: %s: %s", rq.Method, rq.URL.Path, res.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	return nil
+}
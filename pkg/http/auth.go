@@ -0,0 +1,156 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/pkg/render"
+)
+
+var errNoToken = errors.New("a bearer token is required")
+
+type identityCtxKey struct{}
+
+// Identity is who a verified bearer token was issued to. It says nothing of
+// what they may do, which is up to the api's own record of them.
+type Identity struct {
+	// Subject identifies the user to Provider, the identity provider that
+	// signed them in.
+	Subject  string
+	Provider string
+	Email    string
+	Name     string
+	Picture  string
+}
+
+// AuthConfig configures an Authenticator.
+type AuthConfig struct {
+	// Keys verifies token signatures. When nil every token is rejected.
+	Keys *KeySet
+
+	// Issuer and Audience must match the iss and aud claims. When either is
+	// empty every token is rejected.
+	Issuer   string
+	Audience string
+
+	// Now is used to check token lifetimes. When nil the wall clock is used.
+	Now func() time.Time
+}
+
+// Authenticator is middleware that authenticates requests carrying a JWT
+// bearer token in the Authorization header. Tokens must be signed with RS256
+// or ES256 by a key in the configured KeySet.
+//
+// A request with a valid token gets the token's identity in its context, see
+// CurrentIdentity. Requests without a token may only read: GET, HEAD and OPTIONS
+// pass through anonymously and anything else is rejected with a 401. A token
+// that fails verification is always rejected, whatever the method.
+type Authenticator struct {
+	cfg AuthConfig
+}
+
+// NewAuthenticator returns an Authenticator using cfg.
+func NewAuthenticator(cfg AuthConfig) *Authenticator {
+	return &Authenticator{cfg: cfg}
+}
+
+func (a *Authenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			if safeMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			render.Render(w, r, render.ErrUnauthorized(errNoToken), Logger(r))
+			return
+		}
+
+		id, err := a.authenticate(r.Context(), token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="api", error="invalid_token", error_description=%q`, err.Error()))
+			render.Render(w, r, render.ErrUnauthorized(err), Logger(r))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// authenticate verifies token and returns who it was issued to.
+func (a *Authenticator) authenticate(ctx context.Context, token string) (*Identity, error) {
+	header, claims, signed, sig, err := parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.Keys == nil {
+		return nil, errUnknownKey
+	}
+	key, err := a.cfg.Keys.Key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, signed, sig); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if a.cfg.Now != nil {
+		now = a.cfg.Now()
+	}
+	if err := claims.validate(now, a.cfg.Issuer, a.cfg.Audience); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errMalformedToken
+	}
+
+	provider := claims.Provider
+	if provider == "" {
+		provider = claims.Issuer
+	}
+	return &Identity{
+		Subject:  claims.Subject,
+		Provider: provider,
+		Email:    claims.Email,
+		Name:     claims.Name,
+		Picture:  claims.Picture,
+	}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if len(h) < 7 || !strings.EqualFold(h[:7], "bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(h[7:])
+	return token, token != ""
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// WithIdentity returns a copy of ctx carrying id as the authenticated
+// identity.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityCtxKey{}, id)
+}
+
+// CurrentIdentity returns the identity authenticated by Authenticator, or nil
+// if the request is anonymous.
+func CurrentIdentity(r *http.Request) *Identity {
+	return IdentityFromContext(r.Context())
+}
+
+// IdentityFromContext returns the authenticated identity ctx carries, or nil
+// if there is none.
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityCtxKey{}).(*Identity)
+	return id
+}
@@ -0,0 +1,256 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// jwksFor returns a JSON Web Key Set holding the public halves of keys.
+func jwksFor(keys map[string]crypto.Signer) []byte {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, k := range keys {
+		switch pub := k.Public().(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, N: b64(pub.N.Bytes()), E: b64(big.NewInt(int64(pub.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: b64(pub.X.Bytes()), Y: b64(pub.Y.Bytes())})
+		}
+	}
+	b, _ := json.Marshal(set)
+	return b
+}
+
+func signToken(t *testing.T, kid string, key crypto.Signer, claims interface{}) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(jwtHeader{Alg: alg, Kid: kid})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each half is padded to 32 bytes, which big.Int does not keep
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + b64(sig)
+}
+
+var (
+	testKeysOnce sync.Once
+	testRSAKey   *rsa.PrivateKey
+	testECKey    *ecdsa.PrivateKey
+)
+
+func testKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey) {
+	testKeysOnce.Do(func() {
+		testRSAKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+		testECKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	})
+	return testRSAKey, testECKey
+}
+
+func TestAuthenticator(t *testing.T) {
+	rsaKey, ecKey := testKeys(t)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksFor(map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey}))
+	}))
+	defer srv.Close()
+	keys, err := NewKeySet(srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := NewAuthenticator(AuthConfig{
+		Keys:     keys,
+		Issuer:   "https://issuer.example.com",
+		Audience: "blog",
+		Now:      func() time.Time { return testNow },
+	})
+	claims := func(edit func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://issuer.example.com",
+			"sub":   "123",
+			"aud":   []string{"blog", "other"},
+			"exp":   testNow.Add(time.Hour).Unix(),
+			"name":  "name",
+			"email": "user@gmail.com",
+		}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	cases := []struct {
+		Name   string
+		Method string
+		Token  string
+		Status int
+	}{
+		{"RS256", http.MethodPost, signToken(t, "rsa", rsaKey, claims(nil)), http.StatusOK},
+		{"ES256", http.MethodPost, signToken(t, "ec", ecKey, claims(nil)), http.StatusOK},
+		{"single audience", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { c["aud"] = "blog" })), http.StatusOK},
+		{"anonymous read", http.MethodGet, "", http.StatusOK},
+		{"anonymous write", http.MethodPost, "", http.StatusUnauthorized},
+		{"invalid token on a read", http.MethodGet, "abc", http.StatusUnauthorized},
+		{"malformed", http.MethodPost, "abc.def.ghi", http.StatusUnauthorized},
+		{"expired", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { c["exp"] = testNow.Add(-time.Hour).Unix() })), http.StatusUnauthorized},
+		{"no expiry", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { delete(c, "exp") })), http.StatusUnauthorized},
+		{"not yet valid", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { c["nbf"] = testNow.Add(time.Hour).Unix() })), http.StatusUnauthorized},
+		{"wrong issuer", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), http.StatusUnauthorized},
+		{"wrong audience", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { c["aud"] = "other" })), http.StatusUnauthorized},
+		{"no issuer", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { delete(c, "iss") })), http.StatusUnauthorized},
+		{"no audience", http.MethodPost, signToken(t, "ec", ecKey, claims(func(c map[string]interface{}) { delete(c, "aud") })), http.StatusUnauthorized},
+		{"wrong key", http.MethodPost, signToken(t, "ec", otherKey, claims(nil)), http.StatusUnauthorized},
+		{"key type mismatch", http.MethodPost, signToken(t, "rsa", ecKey, claims(nil)), http.StatusUnauthorized},
+		{"unknown key", http.MethodPost, signToken(t, "gone", ecKey, claims(nil)), http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var signedIn bool
+			handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				signedIn = CurrentIdentity(r) != nil
+			}))
+
+			rq, _ := http.NewRequest(c.Method, "/", nil)
+			if c.Token != "" {
+				rq.Header.Set("Authorization", "Bearer "+c.Token)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, rq)
+
+			if res.Code != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", res.Code, c.Status)
+			}
+			if res.Code == http.StatusUnauthorized && res.Header().Get("WWW-Authenticate") == "" {
+				t.Errorf("missing WWW-Authenticate header")
+			}
+			if want := c.Token != "" && c.Status == http.StatusOK; signedIn != want {
+				t.Errorf("got identity '%v', want '%v'", signedIn, want)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorIdentity(t *testing.T) {
+	_, ecKey := testKeys(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksFor(map[string]crypto.Signer{"ec": ecKey}))
+	}))
+	defer srv.Close()
+	keys, err := NewKeySet(srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := NewAuthenticator(AuthConfig{
+		Keys:     keys,
+		Issuer:   "https://issuer.example.com",
+		Audience: "blog",
+		Now:      func() time.Time { return testNow },
+	})
+
+	token := signToken(t, "ec", ecKey, map[string]interface{}{
+		"iss":     "https://issuer.example.com",
+		"sub":     "123",
+		"aud":     "blog",
+		"exp":     testNow.Add(time.Hour).Unix(),
+		"name":    "name",
+		"email":   "user@gmail.com",
+		"picture": "https://photourl.com",
+	})
+	rq, _ := http.NewRequest(http.MethodPost, "/", nil)
+	rq.Header.Set("Authorization", "Bearer "+token)
+
+	auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := CurrentIdentity(r)
+		if id == nil {
+			t.Fatal("no identity in the request context")
+		}
+		want := Identity{
+			Subject:  "123",
+			Provider: "https://issuer.example.com",
+			Email:    "user@gmail.com",
+			Name:     "name",
+			Picture:  "https://photourl.com",
+		}
+		if *id != want {
+			t.Errorf("got '%+v', want '%+v'", *id, want)
+		}
+	})).ServeHTTP(httptest.NewRecorder(), rq)
+}
+
+func TestKeySetRotation(t *testing.T) {
+	rsaKey, ecKey := testKeys(t)
+	var mu sync.Mutex
+	served := map[string]crypto.Signer{"old": rsaKey}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Write(jwksFor(served))
+	}))
+	defer srv.Close()
+
+	keys, err := NewKeySet(srv.URL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	served = map[string]crypto.Signer{"new": ecKey}
+	mu.Unlock()
+
+	// unknown keys only trigger a reload once minRefetch has passed
+	if _, err := keys.Key(context.Background(), "new"); err != errUnknownKey {
+		t.Errorf("got '%v', want '%v'", err, errUnknownKey)
+	}
+	keys.mu.Lock()
+	keys.fetched = keys.fetched.Add(-2 * minRefetch)
+	keys.mu.Unlock()
+
+	if _, err := keys.Key(context.Background(), "new"); err != nil {
+		t.Errorf("rotated key not picked up: %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "old"); err != errUnknownKey {
+		t.Errorf("got '%v', want '%v'", err, errUnknownKey)
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// DefaultTrustedProxies are the proxies ClientIP trusts unless configured
+// otherwise, those on the same host, e.g. the proxy of Up on Lambda.
+const DefaultTrustedProxies = "127.0.0.1/32,::1/128"
+
+// ParseProxies parses a comma separated list of the networks of trusted
+// proxies, in CIDR notation or as single addresses.
+func ParseProxies(s string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("proxy %q must be an address or network", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %q must be an address or network", p)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ClientIP returns middleware that sets the RemoteAddr of requests made
+// through a trusted proxy to the address of the client they were made for.
+// That is the last address in X-Forwarded-For not of a trusted proxy, or
+// X-Real-IP when there is no X-Forwarded-For. The headers of requests from
+// anyone else are ignored, as clients can send them to pose as someone else.
+// It must come before RateLimiter, which limits anonymous clients by their
+// address.
+func ClientIP(trusted []*net.IPNet) Middleware {
+	isTrusted := func(ip net.IP) bool {
+		for _, n := range trusted {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if ip := net.ParseIP(host); ip == nil || !isTrusted(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			var client net.IP
+			if fwd := r.Header["X-Forwarded-For"]; len(fwd) > 0 {
+				// each proxy appends the address it was called from, so
+				// the addresses right of the client's are all proxies
+				addrs := strings.Split(strings.Join(fwd, ","), ",")
+				for i := len(addrs) - 1; i >= 0; i-- {
+					ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+					if ip == nil {
+						break
+					}
+					client = ip
+					if !isTrusted(ip) {
+						break
+					}
+				}
+			} else {
+				client = net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+			}
+			if client != nil {
+				r.RemoteAddr = client.String()
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseProxies(t *testing.T) {
+	cases := []struct {
+		Proxies  string
+		Expected []string
+		Err      bool
+	}{
+		{"", []string{}, false},
+		{DefaultTrustedProxies, []string{"127.0.0.1/32", "::1/128"}, false},
+		{"10.0.0.0/8, 192.0.2.1", []string{"10.0.0.0/8", "192.0.2.1/32"}, false},
+		{"proxy", nil, true},
+		{"10.0.0.0/33", nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Proxies, func(t *testing.T) {
+			nets, err := ParseProxies(c.Proxies)
+			if (err != nil) != c.Err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if len(nets) != len(c.Expected) {
+				t.Fatalf("expected %v, got %v", c.Expected, nets)
+			}
+			for i, n := range nets {
+				if n.String() != c.Expected[i] {
+					t.Errorf("expected %v, got %v", c.Expected[i], n)
+				}
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := ParseProxies("10.0.0.0/8")
+	var got string
+	handler := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.RemoteAddr
+	}))
+
+	cases := []struct {
+		Name       string
+		RemoteAddr string
+		Forwarded  []string
+		RealIP     string
+		Expected   string
+	}{
+		{"direct", "192.0.2.1:1234", nil, "", "192.0.2.1:1234"},
+		{"untrusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.2", "192.0.2.1:1234"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"192.0.2.1"}, "", "192.0.2.1"},
+		{"chain of proxies", "10.0.0.1:1234", []string{"192.0.2.1, 10.0.0.2"}, "", "192.0.2.1"},
+		{"spoofed entries", "10.0.0.1:1234", []string{"198.51.100.1, 192.0.2.1", "10.0.0.2"}, "", "192.0.2.1"},
+		{"only proxies", "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.2"}, "", "10.0.0.3"},
+		{"malformed entry", "10.0.0.1:1234", []string{"nonsense, 10.0.0.2"}, "", "10.0.0.2"},
+		{"real ip", "10.0.0.1:1234", nil, "192.0.2.1", "192.0.2.1"},
+		{"forwarded for wins", "10.0.0.1:1234", []string{"192.0.2.1"}, "198.51.100.1", "192.0.2.1"},
+		{"no headers", "10.0.0.1:1234", nil, "", "10.0.0.1:1234"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			rq, _ := http.NewRequest(http.MethodGet, "/", nil)
+			rq.RemoteAddr = c.RemoteAddr
+			for _, f := range c.Forwarded {
+				rq.Header.Add("X-Forwarded-For", f)
+			}
+			if c.RealIP != "" {
+				rq.Header.Set("X-Real-IP", c.RealIP)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), rq)
+
+			if got != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, got)
+			}
+		})
+	}
+}
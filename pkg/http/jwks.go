@@ -0,0 +1,187 @@
+package http
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errUnknownKey = errors.New("token signed with an unknown key")
+
+// minRefetch is how long KeySet waits between reloads triggered by tokens
+// signed with a key it does not know, so bogus tokens cannot hammer the
+// key source.
+const minRefetch = time.Minute
+
+// KeySet holds the public keys tokens are verified with, loaded from a JSON
+// Web Key Set. The source is either a local file or an http(s) URL. Keys are
+// reloaded once they are older than the refresh interval, and whenever a
+// token names a key the set does not have yet, which picks up rotated keys
+// without a restart. It is safe for concurrent use.
+type KeySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// NewKeySet loads the key set at source. A refresh of zero only reloads the
+// keys when an unknown key is seen.
+func NewKeySet(source string, refresh time.Duration) (*KeySet, error) {
+	ks := &KeySet{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := ks.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Key returns the public key with the given key id.
+func (ks *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	age := time.Since(ks.fetched)
+	ks.mu.RUnlock()
+
+	stale := ks.refresh > 0 && age > ks.refresh
+	if (ok && !stale) || (!ok && !stale && age < minRefetch) {
+		if !ok {
+			return nil, errUnknownKey
+		}
+		return key, nil
+	}
+
+	if err := ks.load(ctx); err != nil && !ok {
+		return nil, err
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+// load replaces the keys with the current contents of the source. The old
+// keys are kept if the source cannot be read.
+func (ks *KeySet) load(ctx context.Context) error {
+	b, err := ks.read(ctx)
+	if err == nil {
+		var keys map[string]crypto.PublicKey
+		if keys, err = parseJWKS(b); err == nil {
+			ks.mu.Lock()
+			ks.keys = keys
+			ks.fetched = time.Now()
+			ks.mu.Unlock()
+			return nil
+		}
+	}
+
+	// don't retry a broken source on every request
+	ks.mu.Lock()
+	ks.fetched = time.Now()
+	ks.mu.Unlock()
+	return fmt.Errorf("loading keys from %s: %v", ks.source, err)
+}
+
+func (ks *KeySet) read(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(ks.source, "http://") && !strings.HasPrefix(ks.source, "https://") {
+		return ioutil.ReadFile(ks.source)
+	}
+
+	rq, err := http.NewRequest(http.MethodGet, ks.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ks.client.Do(rq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS reads the RSA and P-256 signing keys out of a JSON Web Key Set.
+// Keys of any other type are skipped.
+func parseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, err
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %s is not on P-256", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,158 @@
+package http
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	errMalformedToken = errors.New("malformed token")
+	errUnsupportedAlg = errors.New("unsupported signing algorithm")
+	errBadSignature   = errors.New("invalid token signature")
+	errExpiredToken   = errors.New("token is expired")
+	errNotYetValid    = errors.New("token is not valid yet")
+	errWrongIssuer    = errors.New("token has the wrong issuer")
+	errWrongAudience  = errors.New("token has the wrong audience")
+)
+
+// clockSkew is how far apart our clock and the token issuer's may be.
+const clockSkew = time.Minute
+
+// Claims are the parts of a JWT payload the api cares about.
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Picture  string `json:"picture"`
+	Provider string `json:"provider"`
+}
+
+// audience is the aud claim, which may be a single string or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// parseToken splits a compact JWT and decodes its header and claims without
+// checking anything. The signature is returned along with the signed input
+// so the caller can verify it once it has found the key.
+func parseToken(token string) (*jwtHeader, *Claims, []byte, []byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, nil, errMalformedToken
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, nil, nil, nil, errMalformedToken
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, nil, nil, nil, errMalformedToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, nil, nil, errMalformedToken
+	}
+
+	return &header, &claims, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifySignature checks sig over signed with key, which must be of the type
+// alg calls for. Only RS256 and ES256 are accepted.
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case "RS256":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errUnsupportedAlg
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return errBadSignature
+		}
+		return nil
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().Name != "P-256" {
+			return errUnsupportedAlg
+		}
+		if len(sig) != 64 {
+			return errBadSignature
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return errBadSignature
+		}
+		return nil
+	}
+	return errUnsupportedAlg
+}
+
+// validate checks the time window, issuer and audience of c. Tokens are only
+// accepted from a known issuer for a known audience, so an empty issuer or
+// audience rejects every token.
+func (c *Claims) validate(now time.Time, issuer, aud string) error {
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errExpiredToken
+	}
+	if c.NotBefore != 0 && now.Add(clockSkew).Before(time.Unix(c.NotBefore, 0)) {
+		return errNotYetValid
+	}
+	if issuer == "" || c.Issuer != issuer {
+		return errWrongIssuer
+	}
+	if aud == "" || !c.Audience.contains(aud) {
+		return errWrongAudience
+	}
+	return nil
+}
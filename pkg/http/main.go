@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/corymhall/blog-backend-go/pkg/trace"
 	"github.com/rs/zerolog"
 	"github.com/go-chi/chi/middleware"
 )
@@ -45,7 +46,7 @@ func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 	}
 
 
-	logger := l.Logger.With().
+	fields := l.Logger.With().
 		Str("ts", ts).
 		Str("req_id", req_id).
 		Str("http_scheme", "http").
@@ -53,8 +54,15 @@ func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 		Str("http_method", r.Method).
 		Str("remote_addr", r.RemoteAddr).
 		Str("user_agent", r.UserAgent()).
-		Str("uri", fmt.Sprintf("%s://%s%s", "http", r.Host, r.RequestURI)).
-		Logger()
+		Str("uri", fmt.Sprintf("%s://%s%s", "http", r.Host, r.RequestURI))
+
+	// so the log lines of a request can be found from its trace
+	if sc := trace.FromContext(r.Context()).SpanContext(); sc.IsValid() {
+		fields = fields.
+			Str("trace_id", sc.TraceID.String()).
+			Str("span_id", sc.SpanID.String())
+	}
+	logger := fields.Logger()
 
 	logger.Info().Msg("request started")
 
@@ -80,9 +88,10 @@ func (l *StructuredLoggerEntry) Write(status, bytes int, elapsed time.Duration)
 }
 
 func (l *StructuredLoggerEntry) Panic(v interface{}, stack []byte) {
-	l.Logger.Info().
+	l.Logger.Error().
 		Str("stack", string(stack)).
-		Str("panic", fmt.Sprintf("%+v", v))
+		Str("panic", fmt.Sprintf("%+v", v)).
+		Msg("request panicked")
 }
 
 
@@ -90,7 +99,11 @@ func (l *StructuredLoggerEntry) Panic(v interface{}, stack []byte) {
 // entry.
 // This allows us to do something like
 // logger := Logger(r)
+// Requests that did not go through NewLogger get a logger that discards everything.
 func Logger(r *http.Request) zerolog.Logger {
-	entry := middleware.GetLogEntry(r).(*StructuredLoggerEntry)
+	entry, ok := middleware.GetLogEntry(r).(*StructuredLoggerEntry)
+	if !ok {
+		return zerolog.Nop()
+	}
 	return entry.Logger
 }
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/pkg/metrics"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// HTTPMetrics counts and times requests. Requests are labeled by the chi
+// route pattern they matched, e.g. /posts/{postID}, never by their URL, so
+// IDs in paths do not each get a series of their own.
+type HTTPMetrics struct {
+	requests *metrics.Vec
+	duration *metrics.HistogramVec
+	inFlight *metrics.Value
+}
+
+// unmatchedRoute labels requests that matched no route.
+const unmatchedRoute = "unmatched"
+
+// NewHTTPMetrics registers the request metrics with reg.
+func NewHTTPMetrics(reg *metrics.Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: reg.NewCounter("http_requests_total",
+			"Requests served, by method, route pattern and status.",
+			"method", "route", "status"),
+		duration: reg.NewHistogram("http_request_duration_seconds",
+			"How long requests took to serve, by method, route pattern and status.",
+			metrics.DefBuckets, "method", "route", "status"),
+		inFlight: reg.NewGauge("http_requests_in_flight",
+			"Requests being served.").With(),
+	}
+}
+
+// Handler records the metrics of each request. It must be used by the
+// router the routes are on, or one they are mounted on, so the route
+// pattern is known once the request has been served.
+func (m *HTTPMetrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			// nothing was written, which net/http sends as a 200
+			status = http.StatusOK
+		}
+		labels := []string{method(r), routePattern(r), strconv.Itoa(status)}
+		m.requests.With(labels...).Inc()
+		m.duration.With(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// method returns the method of r, or other for methods clients made up, as
+// they could otherwise add any number of series.
+func method(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return r.Method
+	}
+	return "other"
+}
+
+// routePattern returns the pattern of the route r matched. Subrouters
+// mounted at a path add a trailing slash to the pattern of their root route,
+// which is dropped so a request rejected by a subrouter's middleware, before
+// its routes were searched, is labeled with the same route.
+func routePattern(r *http.Request) string {
+	rctx, ok := r.Context().Value(chi.RouteCtxKey).(*chi.Context)
+	if !ok {
+		return unmatchedRoute
+	}
+	pattern := rctx.RoutePattern()
+	if pattern == "" {
+		return unmatchedRoute
+	}
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	return pattern
+}
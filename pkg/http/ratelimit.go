@@ -0,0 +1,228 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/pkg/render"
+)
+
+var errRateLimited = errors.New("rate limit exceeded, retry later")
+
+// Limit is a token bucket budget: a client may make Burst requests at once,
+// after which its budget refills at Rate requests per second. The zero Limit
+// does not limit anything.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses a limit written as requests per period, e.g. "60/m" for
+// sixty requests a minute. The period is one of s, m or h. Clients may spend
+// the whole budget at once. An empty string or "0" means no limit.
+func ParseLimit(s string) (Limit, error) {
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+	i := strings.Index(s, "/")
+	if i < 0 {
+		return Limit{}, fmt.Errorf("limit %q must be requests per period, e.g. 60/m", s)
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 {
+		return Limit{}, fmt.Errorf("limit %q must start with a number of requests", s)
+	}
+	var period time.Duration
+	switch s[i+1:] {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("limit %q must be per s, m or h", s)
+	}
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}, nil
+}
+
+// Decision is what a RateLimitStore decided about one request.
+type Decision struct {
+	Allowed bool
+	// Remaining is how many requests are left in the budget.
+	Remaining int
+	// Reset is how long until the budget is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, when this
+	// one was not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the token buckets of a RateLimiter. MemoryStore keeps
+// them in process; a store shared between instances of the api, such as one
+// backed by redis, lets them enforce one budget together.
+type RateLimitStore interface {
+	// Take spends a token from the bucket called key, which is created full
+	// if it does not exist yet.
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error)
+}
+
+// sweepInterval is how often a MemoryStore forgets buckets that have
+// refilled, as those are the same as no bucket at all.
+const sweepInterval = time.Minute
+
+// MemoryStore is a RateLimitStore that keeps buckets in memory. It is safe
+// for concurrent use.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will have refilled.
+	full time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.swept) > sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	burst := float64(limit.Burst)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+
+	var d Decision
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = seconds((1 - b.tokens) / limit.Rate)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = seconds((burst - b.tokens) / limit.Rate)
+	b.full = now.Add(d.Reset)
+	return d, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// RateLimitConfig configures a RateLimiter.
+type RateLimitConfig struct {
+	// Read limits GET, HEAD and OPTIONS requests and Write everything else.
+	// Each client has a separate budget for both.
+	Read  Limit
+	Write Limit
+
+	// Store keeps the budgets. When nil they are kept in memory.
+	Store RateLimitStore
+
+	// Now is used to refill budgets. When nil the wall clock is used.
+	Now func() time.Time
+}
+
+// RateLimiter is middleware that limits how many requests each client may
+// make, using a token bucket per client. Clients are told about their budget
+// in RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and a
+// request over budget is rejected with a 429 and a Retry-After header.
+//
+// Signed in users are limited by who they are, anyone else by their IP
+// address, taken from the request's RemoteAddr. Behind a proxy, put
+// ClientIP in front so that is the client's address. It must come after
+// Authenticator to know who is signed in.
+//
+// When the store fails requests are let through, so an outage of a shared
+// store does not take the api down with it.
+type RateLimiter struct {
+	cfg RateLimitConfig
+}
+
+// NewRateLimiter returns a RateLimiter using cfg.
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	if cfg.Store == nil {
+		cfg.Store = NewMemoryStore()
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &RateLimiter{cfg: cfg}
+}
+
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, limit := "write", l.cfg.Write
+		if safeMethod(r.Method) {
+			class, limit = "read", l.cfg.Read
+		}
+		if limit.Burst <= 0 || limit.Rate <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := l.cfg.Store.Take(r.Context(), class+":"+clientKey(r), limit, l.cfg.Now())
+		if err != nil {
+			logger := Logger(r)
+			logger.Warn().Err(err).Msg("unable to check rate limit")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", ceilSeconds(d.Reset))
+		if !d.Allowed {
+			h.Set("Retry-After", ceilSeconds(d.RetryAfter))
+			render.Render(w, r, render.ErrTooManyRequests(errRateLimited), Logger(r))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies who made r for rate limiting.
+func clientKey(r *http.Request) string {
+	if id := CurrentIdentity(r); id != nil {
+		return "user:" + id.Provider + "#" + id.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
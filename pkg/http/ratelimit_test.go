@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	cases := []struct {
+		Limit    string
+		Expected Limit
+		Err      bool
+	}{
+		{"", Limit{}, false},
+		{"0", Limit{}, false},
+		{"60/m", Limit{Rate: 1, Burst: 60}, false},
+		{"10/s", Limit{Rate: 10, Burst: 10}, false},
+		{"60", Limit{}, true},
+		{"x/m", Limit{}, true},
+		{"60/d", Limit{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Limit, func(t *testing.T) {
+			limit, err := ParseLimit(c.Limit)
+			if (err != nil) != c.Err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if limit != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, limit)
+			}
+		})
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (Decision, error) {
+	return Decision{}, errors.New("store is down")
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := testNow
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read:  Limit{Rate: 1, Burst: 2},
+		Write: Limit{Rate: 0.5, Burst: 1},
+		Now:   func() time.Time { return now },
+	})
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method, addr string, id *Identity) *httptest.ResponseRecorder {
+		rq, _ := http.NewRequest(method, "/posts", nil)
+		rq.RemoteAddr = addr
+		if id != nil {
+			rq = rq.WithContext(WithIdentity(rq.Context(), id))
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, rq)
+		return res
+	}
+
+	cases := []struct {
+		Name      string
+		Method    string
+		Addr      string
+		Identity  *Identity
+		Advance   time.Duration
+		Status    int
+		Remaining string
+		Retry     string
+	}{
+		{"first read", http.MethodGet, "1.2.3.4:1000", nil, 0, http.StatusOK, "1", ""},
+		{"second read", http.MethodGet, "1.2.3.4:1001", nil, 0, http.StatusOK, "0", ""},
+		{"read over budget", http.MethodGet, "1.2.3.4:1002", nil, 0, http.StatusTooManyRequests, "0", "1"},
+		{"writes have their own budget", http.MethodPost, "1.2.3.4:1003", nil, 0, http.StatusOK, "0", ""},
+		{"write over budget", http.MethodPost, "1.2.3.4:1004", nil, 0, http.StatusTooManyRequests, "0", "2"},
+		{"other clients are not limited", http.MethodGet, "5.6.7.8:1000", nil, 0, http.StatusOK, "1", ""},
+		{"users are limited by who they are", http.MethodGet, "1.2.3.4:1005", &Identity{Subject: "1"}, 0, http.StatusOK, "1", ""},
+		{"budget refills", http.MethodGet, "1.2.3.4:1006", nil, time.Second, http.StatusOK, "0", ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			now = now.Add(c.Advance)
+			res := do(c.Method, c.Addr, c.Identity)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if remaining := res.Header().Get("RateLimit-Remaining"); remaining != c.Remaining {
+				t.Errorf("expected %v remaining, got %v", c.Remaining, remaining)
+			}
+			if retry := res.Header().Get("Retry-After"); retry != c.Retry {
+				t.Errorf("expected Retry-After %v, got %v", c.Retry, retry)
+			}
+		})
+	}
+}
+
+func TestRateLimiterFailOpen(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{
+		Read:  Limit{Rate: 1, Burst: 1},
+		Store: failingStore{},
+	})
+	handler := limiter.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rq, _ := http.NewRequest(http.MethodGet, "/posts", nil)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, rq)
+
+	if status := res.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, http.StatusOK)
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s := NewMemoryStore()
+	limit := Limit{Rate: 1, Burst: 1}
+	s.Take(context.Background(), "a", limit, testNow)
+	s.Take(context.Background(), "b", limit, testNow.Add(2*sweepInterval))
+
+	if _, ok := s.buckets["a"]; ok {
+		t.Errorf("expected the refilled bucket to be forgotten")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Errorf("expected the bucket in use to be kept")
+	}
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/corymhall/blog-backend-go/pkg/render"
+	"github.com/go-chi/chi/middleware"
+)
+
+// Panic is a panic recovered from while serving a request.
+type Panic struct {
+	RequestID string
+	Method    string
+	URL       string
+	Value     interface{}
+	Stack     []byte
+	Time      time.Time
+}
+
+// ErrorSink is told about each panic, e.g. to pass it on to an error
+// tracker. Report is called while the request is being served, so it should
+// not block.
+type ErrorSink interface {
+	Report(p Panic)
+}
+
+// ErrorSinkFunc adapts a func to an ErrorSink.
+type ErrorSinkFunc func(p Panic)
+
+func (f ErrorSinkFunc) Report(p Panic) { f(p) }
+
+// Recoverer recovers from panics in the handlers it wraps. The panic and its
+// stack are logged with the request's log entry, and reported to sink unless
+// it is nil. The client is sent a 500 with the request ID, as JSON whatever
+// it accepts, so it can be matched with the logs. It must be used after
+// middleware.RequestID and NewLogger.
+func Recoverer(sink ErrorSink) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					// net/http aborts the response quietly
+					panic(v)
+				}
+
+				stack := debug.Stack()
+				if entry := middleware.GetLogEntry(r); entry != nil {
+					entry.Panic(v, stack)
+				}
+				reqID := middleware.GetReqID(r.Context())
+				if sink != nil {
+					sink.Report(Panic{
+						RequestID: reqID,
+						Method:    r.Method,
+						URL:       r.URL.String(),
+						Value:     v,
+						Stack:     stack,
+						Time:      time.Now().UTC(),
+					})
+				}
+
+				// once the header is out the client gets what was sent
+				if ww.Status() != 0 {
+					return
+				}
+				e := render.ErrPanic(reqID)
+				e.Render(ww, r)
+				render.JSON(ww, r, e, Logger(r))
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
+
+// WebhookSink posts each panic as JSON to a URL, e.g. an incoming webhook of
+// a chat or error tracking service.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+	// OnError is called when a panic could not be posted. When nil the
+	// error is ignored.
+	OnError func(error)
+}
+
+// NewWebhookSink returns a sink posting to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// webhookPanic is how a panic is posted by WebhookSink.
+type webhookPanic struct {
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+	Time      time.Time `json:"time"`
+}
+
+// Report posts p in the background.
+func (s *WebhookSink) Report(p Panic) {
+	body, _ := json.Marshal(webhookPanic{
+		RequestID: p.RequestID,
+		Method:    p.Method,
+		URL:       p.URL,
+		Panic:     fmt.Sprintf("%+v", p.Value),
+		Stack:     string(p.Stack),
+		Time:      p.Time,
+	})
+	go func() {
+		if err := s.post(body); err != nil && s.OnError != nil {
+			s.OnError(err)
+		}
+	}()
+}
+
+func (s *WebhookSink) post(body []byte) error {
+	res, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("unable to report panic: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unable to report panic: %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/rs/zerolog"
+)
+
+func TestRecoverer(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Handler http.HandlerFunc
+		Status  int
+		Body    bool
+	}{
+		{"panic", func(w http.ResponseWriter, r *http.Request) { panic("boom") }, http.StatusInternalServerError, true},
+		{"panic after writing", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("partial"))
+			panic("boom")
+		}, http.StatusOK, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			var logs bytes.Buffer
+			var reported []Panic
+			r := chi.NewRouter()
+			r.Use(middleware.RequestID)
+			r.Use(NewLogger(zerolog.New(&logs)))
+			r.Use(Recoverer(ErrorSinkFunc(func(p Panic) { reported = append(reported, p) })))
+			r.Get("/", c.Handler)
+
+			rq, _ := http.NewRequest(http.MethodGet, "/", nil)
+			rq.Header.Set("Accept", "application/xml")
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, rq)
+
+			if status := res.Code; status != c.Status {
+				t.Errorf("handler returned wrong status code: got '%v' want '%v'", status, c.Status)
+			}
+			if len(reported) != 1 || reported[0].Value != "boom" || reported[0].RequestID == "" || len(reported[0].Stack) == 0 {
+				t.Fatalf("expected the panic to be reported, got %+v", reported)
+			}
+
+			if c.Body {
+				var body map[string]interface{}
+				if err := json.Unmarshal(res.Body.Bytes(), &body); err != nil {
+					t.Fatalf("expected a JSON body, got %q", res.Body.String())
+				}
+				if body["request_id"] != reported[0].RequestID || body["status"] != "Internal server error." {
+					t.Errorf("expected a 500 with the request id, got %v", body)
+				}
+			}
+
+			if !strings.Contains(logs.String(), `"message":"request panicked"`) || !strings.Contains(logs.String(), `"panic":"boom"`) ||
+				!strings.Contains(logs.String(), `"req_id":"`+reported[0].RequestID+`"`) {
+				t.Errorf("expected the panic to be logged with the request, got %s", logs.String())
+			}
+		})
+	}
+}
+
+func TestRecovererAbort(t *testing.T) {
+	handler := Recoverer(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected the abort to be passed on to net/http, got %v", v)
+		}
+	}()
+	rq, _ := http.NewRequest(http.MethodGet, "/", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), rq)
+}
+
+func TestWebhookSink(t *testing.T) {
+	posted := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posted <- body
+	}))
+	defer srv.Close()
+
+	NewWebhookSink(srv.URL).Report(Panic{RequestID: "host/abc-1", Method: "GET", URL: "/posts/1", Value: "boom", Stack: []byte("stack")})
+	select {
+	case body := <-posted:
+		var p webhookPanic
+		json.Unmarshal(body, &p)
+		if p.RequestID != "host/abc-1" || p.Panic != "boom" || p.URL != "/posts/1" || p.Stack != "stack" {
+			t.Errorf("unexpected report %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the panic to be posted")
+	}
+}
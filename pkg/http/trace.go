@@ -0,0 +1,48 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/corymhall/blog-backend-go/pkg/trace"
+	"github.com/go-chi/chi/middleware"
+)
+
+// traceparentHeader carries a W3C trace context.
+const traceparentHeader = "traceparent"
+
+// Tracing starts a server span for each request with t. Requests with a
+// traceparent header continue the caller's trace, and every response has
+// the traceparent of its span, so a slow response can be looked up. Like
+// HTTPMetrics spans are named by route pattern, so it must be used by the
+// router the routes are on, or one they are mounted on.
+func Tracing(t *trace.Tracer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// a malformed traceparent starts a trace of our own
+			parent, _ := trace.ParseTraceparent(r.Header.Get(traceparentHeader))
+			ctx, span := t.Start(r.Context(), "HTTP "+method(r), trace.KindServer, parent)
+			defer span.End()
+			w.Header().Set(traceparentHeader, span.SpanContext().Traceparent())
+
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := routePattern(r)
+			if route != unmatchedRoute {
+				span.SetName(method(r) + " " + route)
+			}
+			span.SetAttribute("http.method", r.Method)
+			span.SetAttribute("http.route", route)
+			span.SetAttribute("http.target", r.URL.Path)
+			span.SetAttribute("http.status_code", status)
+			if status >= http.StatusInternalServerError {
+				span.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+			}
+		})
+	}
+}
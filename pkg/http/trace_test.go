@@ -0,0 +1,71 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/corymhall/blog-backend-go/pkg/trace"
+	"github.com/go-chi/chi"
+	"github.com/rs/zerolog"
+)
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []trace.SpanData
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []trace.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	rec := &spanRecorder{}
+	tracer := trace.NewTracer(trace.Config{Exporter: rec, SampleRatio: 1})
+	var logs bytes.Buffer
+
+	r := chi.NewRouter()
+	r.Use(Tracing(tracer))
+	r.Use(NewLogger(zerolog.New(&logs)))
+	r.Get("/posts/{postID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	rq, _ := http.NewRequest(http.MethodGet, "/posts/1", nil)
+	rq.Header.Set("traceparent", parent)
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, rq)
+	tracer.Close()
+
+	if len(rec.spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(rec.spans))
+	}
+	span := rec.spans[0]
+	if span.Name != "GET /posts/{postID}" || span.Kind != trace.KindServer || span.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("expected a server span named by route within the caller's, got %+v", span)
+	}
+	if span.Attributes["http.status_code"] != 500 || span.Error == "" {
+		t.Errorf("expected the failed response to fail the span, got %v %q", span.Attributes, span.Error)
+	}
+
+	sc, err := trace.ParseTraceparent(res.Header().Get("traceparent"))
+	if err != nil || sc != span.SpanContext {
+		t.Errorf("expected the traceparent of the span, got %q", res.Header().Get("traceparent"))
+	}
+
+	line := strings.SplitN(logs.String(), "\n", 2)[0]
+	var fields map[string]interface{}
+	json.Unmarshal([]byte(line), &fields)
+	if fields["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || fields["span_id"] != span.SpanContext.SpanID.String() {
+		t.Errorf("expected the log lines to have the trace, got %s", line)
+	}
+}
@@ -0,0 +1,359 @@
+// Package metrics keeps counters, gauges and histograms and exposes them in
+// the Prometheus text format, so they can be scraped without pulling in the
+// Prometheus client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets suit latencies of requests to the api and its datastore, in
+// seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector writes the samples of one metric family.
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and serves them to Prometheus. Metric names must
+// be unique within a registry.
+type Registry struct {
+	mu         sync.Mutex
+	names      map[string]bool
+	collectors []collector
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names[name] {
+		panic("metrics: " + name + " is already registered")
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every metric in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves every metric in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, rq *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// family is what metrics of every type share: a name, help and the names
+// of their labels.
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (f *family) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+}
+
+// key joins label values into the key of their series.
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got values %v", f.name, f.labels, values))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats the labels of a sample, with extra appended, e.g. the
+// le of a histogram bucket.
+func (f *family) labelPairs(values []string, extra ...string) string {
+	pairs := []string{}
+	for i, l := range f.labels {
+		pairs = append(pairs, l+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Value is a single number a counter or gauge keeps.
+type Value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Add adds d to the value. Counters must only be increased.
+func (v *Value) Add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+// Inc adds one to the value.
+func (v *Value) Inc() { v.Add(1) }
+
+// Dec takes one from the value, which is only meaningful for gauges.
+func (v *Value) Dec() { v.Add(-1) }
+
+// Set sets the value, which is only meaningful for gauges.
+func (v *Value) Set(f float64) {
+	v.mu.Lock()
+	v.v = f
+	v.mu.Unlock()
+}
+
+func (v *Value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Vec is a counter or gauge with a series for each combination of its
+// label values.
+type Vec struct {
+	family
+
+	mu     sync.Mutex
+	series map[string]*Value
+	values map[string][]string
+}
+
+func (r *Registry) newVec(typ, name, help string, labels []string) *Vec {
+	v := &Vec{
+		family: family{name: name, help: help, typ: typ, labels: labels},
+		series: map[string]*Value{},
+		values: map[string][]string{},
+	}
+	r.register(name, v)
+	return v
+}
+
+// NewCounter registers a counter, e.g. requests_total, with a series for
+// each combination of the values of labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Vec {
+	return r.newVec("counter", name, help, labels)
+}
+
+// NewGauge registers a gauge, e.g. requests_in_flight, with a series for
+// each combination of the values of labels.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Vec {
+	return r.newVec("gauge", name, help, labels)
+}
+
+// With returns the series of the label values, given in the order the
+// labels were registered in.
+func (v *Vec) With(values ...string) *Value {
+	key := v.key(values)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &Value{}
+		v.series[key] = s
+		v.values[key] = append([]string{}, values...)
+	}
+	return s
+}
+
+func (v *Vec) write(w *bufio.Writer) {
+	v.mu.Lock()
+	keys := sortedKeys(v.values)
+	samples := make([]float64, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		samples[i], values[i] = v.series[k].get(), v.values[k]
+	}
+	v.mu.Unlock()
+
+	v.writeHeader(w)
+	for i := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, v.labelPairs(values[i]), formatFloat(samples[i]))
+	}
+}
+
+// Histogram counts observations, e.g. latencies, into buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	upper   []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records a single observation.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.buckets) {
+		h.buckets[i]++
+	}
+	h.count++
+	h.sum += v
+}
+
+// HistogramVec is a histogram with a series for each combination of its
+// label values.
+type HistogramVec struct {
+	family
+	upper []float64
+
+	mu     sync.Mutex
+	series map[string]*Histogram
+	values map[string][]string
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets,
+// which must be sorted, and a series for each combination of the values of
+// labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		family: family{name: name, help: help, typ: "histogram", labels: labels},
+		upper:  buckets,
+		series: map[string]*Histogram{},
+		values: map[string][]string{},
+	}
+	r.register(name, h)
+	return h
+}
+
+// With returns the series of the label values, given in the order the
+// labels were registered in.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	key := h.key(values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &Histogram{upper: h.upper, buckets: make([]uint64, len(h.upper))}
+		h.series[key] = s
+		h.values[key] = append([]string{}, values...)
+	}
+	return s
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	keys := sortedKeys(h.values)
+	series := make([]*Histogram, len(keys))
+	values := make([][]string, len(keys))
+	for i, k := range keys {
+		series[i], values[i] = h.series[k], h.values[k]
+	}
+	h.mu.Unlock()
+
+	h.writeHeader(w)
+	for i, s := range series {
+		s.mu.Lock()
+		buckets, count, sum := append([]uint64{}, s.buckets...), s.count, s.sum
+		s.mu.Unlock()
+
+		// buckets are cumulative
+		var n uint64
+		for j, upper := range h.upper {
+			n += buckets[j]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values[i], "le", formatFloat(upper)), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(values[i], "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(values[i]), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(values[i]), count)
+	}
+}
+
+// funcVec reads its samples from elsewhere each time it is scraped.
+type funcVec struct {
+	family
+	collect func(emit func(v float64, values ...string))
+}
+
+// NewCounterFunc registers a counter kept elsewhere, e.g. by a cache. Each
+// scrape calls collect, which emits the value of each series along with
+// its label values.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) {
+	r.register(name, &funcVec{
+		family:  family{name: name, help: help, typ: "counter", labels: labels},
+		collect: collect,
+	})
+}
+
+func (f *funcVec) write(w *bufio.Writer) {
+	type sample struct {
+		key    string
+		values []string
+		v      float64
+	}
+	samples := []sample{}
+	f.collect(func(v float64, values ...string) {
+		samples = append(samples, sample{f.key(values), values, v})
+	})
+	sort.Slice(samples, func(i, j int) bool { return samples[i].key < samples[j].key })
+
+	f.writeHeader(w)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(s.values), formatFloat(s.v))
+	}
+}
+
+// sortedKeys returns the keys of series, sorted so scrapes list series in
+// the same order every time.
+func sortedKeys(series map[string][]string) []string {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests served.", "route", "status")
+	inFlight := reg.NewGauge("requests_in_flight", "Requests being served.")
+	latency := reg.NewHistogram("request_duration_seconds", "How long requests took.", []float64{0.1, 1}, "route")
+	reg.NewCounterFunc("hits_total", "Cache hits.", []string{"method"}, func(emit func(float64, ...string)) {
+		emit(3, "get")
+		emit(1, "add")
+	})
+
+	requests.With("/posts/{postID}", "200").Inc()
+	requests.With("/posts/{postID}", "200").Add(2)
+	requests.With(`/a"b\`, "404").Inc()
+	inFlight.With().Inc()
+	inFlight.With().Inc()
+	inFlight.With().Dec()
+	latency.With("/posts").Observe(0.05)
+	latency.With("/posts").Observe(0.1)
+	latency.With("/posts").Observe(0.5)
+	latency.With("/posts").Observe(2)
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a\"b\\",status="404"} 1
+requests_total{route="/posts/{postID}",status="200"} 3
+# HELP requests_in_flight Requests being served.
+# TYPE requests_in_flight gauge
+requests_in_flight 1
+# HELP request_duration_seconds How long requests took.
+# TYPE request_duration_seconds histogram
+request_duration_seconds_bucket{route="/posts",le="0.1"} 2
+request_duration_seconds_bucket{route="/posts",le="1"} 3
+request_duration_seconds_bucket{route="/posts",le="+Inf"} 4
+request_duration_seconds_sum{route="/posts"} 2.65
+request_duration_seconds_count{route="/posts"} 4
+# HELP hits_total Cache hits.
+# TYPE hits_total counter
+hits_total{method="add"} 1
+hits_total{method="get"} 3
+`
+	var buf bytes.Buffer
+	if err := reg.Write(&buf); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, got)
+	}
+
+	res := httptest.NewRecorder()
+	reg.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := res.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" || res.Body.String() != want {
+		t.Errorf("expected the metrics to be served as text, got %v", ct)
+	}
+}
+
+func TestRegistryRejects(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("requests_total", "Requests served.", "route")
+
+	for name, f := range map[string]func(){
+		"duplicate name":  func() { reg.NewGauge("requests_total", "Again.") },
+		"missing label":   func() { c.With() },
+		"too many labels": func() { c.With("a", "b") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// CBOR major types, see RFC 7049.
+const (
+	cborUint   = 0 << 5
+	cborNegint = 1 << 5
+	cborText   = 3 << 5
+	cborArray  = 4 << 5
+	cborMap    = 5 << 5
+	cborSimple = 7 << 5
+
+	cborFalse   = cborSimple | 20
+	cborTrue    = cborSimple | 21
+	cborNull    = cborSimple | 22
+	cborFloat64 = cborSimple | 27
+)
+
+// encodeCBOR writes v as CBOR with the same fields as its JSON. Numbers
+// that are whole become integers and the rest floats. Map keys are sorted,
+// so the same value always encodes the same way.
+//
+// zerolog has a CBOR encoder but it is internal to zerolog, so this one
+// covers the handful of types JSON decodes to.
+func encodeCBOR(w io.Writer, v interface{}) error {
+	g, err := generic(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(appendCBOR(nil, g))
+	return err
+}
+
+func appendCBOR(dst []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(dst, cborNull)
+	case bool:
+		if v {
+			return append(dst, cborTrue)
+		}
+		return append(dst, cborFalse)
+	case string:
+		dst = appendCBORHead(dst, cborText, uint64(len(v)))
+		return append(dst, v...)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			if n < 0 {
+				return appendCBORHead(dst, cborNegint, uint64(-1-n))
+			}
+			return appendCBORHead(dst, cborUint, uint64(n))
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendCBORHead(dst, cborUint, n)
+		}
+		f, _ := v.Float64()
+		return appendUint64(append(dst, cborFloat64), math.Float64bits(f))
+	case []interface{}:
+		dst = appendCBORHead(dst, cborArray, uint64(len(v)))
+		for _, item := range v {
+			dst = appendCBOR(dst, item)
+		}
+		return dst
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		dst = appendCBORHead(dst, cborMap, uint64(len(v)))
+		for _, k := range keys {
+			dst = appendCBOR(dst, k)
+			dst = appendCBOR(dst, v[k])
+		}
+		return dst
+	}
+	return append(dst, cborNull)
+}
+
+// appendCBORHead appends the head of a data item of the major type, whose
+// argument is n: the value of an integer or the length of anything else.
+func appendCBORHead(dst []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(dst, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(dst, major|24, byte(n))
+	case n <= math.MaxUint16:
+		var b [2]byte
+		binary.BigEndian.PutUint16(b[:], uint16(n))
+		return append(append(dst, major|25), b[:]...)
+	case n <= math.MaxUint32:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(n))
+		return append(append(dst, major|26), b[:]...)
+	}
+	return appendUint64(append(dst, major|27), n)
+}
+
+// appendUint64 appends n in big endian order.
+func appendUint64(dst []byte, n uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n)
+	return append(dst, b[:]...)
+}
@@ -12,6 +12,10 @@ type ErrResponse struct {
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
 	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+
+	Fields []FieldError `json:"fields,omitempty"` // every invalid field of a rejected payload
+
+	RequestID string `json:"request_id,omitempty"` // lets a failed request be found in the logs
 }
 
 
@@ -23,8 +27,12 @@ func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// returns a Renderer object that represents an invalid request error
+// returns a Renderer object that represents an invalid request error.
+// A *ValidationError from Bind is rendered as a 422 listing each invalid field.
 func ErrInvalidRequest(err error) Renderer {
+	if verr, ok := err.(*ValidationError); ok {
+		return ErrValidation(verr)
+	}
 	return &ErrResponse{
 		Err: err,
 		HTTPStatusCode: 400,
@@ -33,6 +41,16 @@ func ErrInvalidRequest(err error) Renderer {
 	}
 }
 
+// returns a Renderer object that lists the fields of a payload that failed validation
+func ErrValidation(err *ValidationError) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 422,
+		StatusText:     "Validation failed.",
+		Fields:         err.Fields,
+	}
+}
+
 // returns a Renderer object that represents an error rendering the response
 func ErrRender(err error) Renderer {
 	return &ErrResponse{
@@ -52,5 +70,125 @@ func ErrInternalServerError(err error) Renderer {
 	}
 }
 
+// returns a Renderer object that represents a request whose handler panicked.
+// What went wrong is only logged, the request id lets it be found.
+func ErrPanic(requestID string) Renderer {
+	return &ErrResponse{
+		HTTPStatusCode: 500,
+		StatusText:     "Internal server error.",
+		ErrorText:      "the request could not be completed",
+		RequestID:      requestID,
+	}
+}
+
+// returns a Renderer object that represents a request without valid credentials
+func ErrUnauthorized(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 401,
+		StatusText:     "Unauthorized.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a signed in user who may not do
+// what they asked
+func ErrForbidden(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 403,
+		StatusText:     "Forbidden.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a write that lost a race with
+// another update to the same resource
+func ErrConflict(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		StatusText:     "Resource conflict.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a dependency that cannot serve
+// the request right now. The client may retry later.
+func ErrServiceUnavailable(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 503,
+		StatusText:     "Service unavailable.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a client that sent more requests
+// than it is allowed to. The client may retry once its budget refills.
+func ErrTooManyRequests(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 429,
+		StatusText:     "Too many requests.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a request body larger than the
+// server accepts
+func ErrRequestEntityTooLarge(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 413,
+		StatusText:     "Request entity too large.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents content of a type the server
+// does not accept
+func ErrUnsupportedMediaType(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 415,
+		StatusText:     "Unsupported media type.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a request whose precondition,
+// e.g. If-Match, does not hold for the current state of the resource
+func ErrPreconditionFailed(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 412,
+		StatusText:     "Precondition failed.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a conditional request sent
+// without the condition, e.g. an update without the version it was made to
+func ErrPreconditionRequired(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 428,
+		StatusText:     "Precondition required.",
+		ErrorText:      err.Error(),
+	}
+}
+
+// returns a Renderer object that represents a client that accepts none of
+// the media types responses are available in
+func ErrNotAcceptable(err error) Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 406,
+		StatusText:     "Not acceptable.",
+		ErrorText:      err.Error(),
+	}
+}
+
 var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
 
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	CacheControlCtxKey = &contextKey{"CacheControl"}
+)
+
+// CacheControl returns middleware that sends policy as the Cache-Control
+// header of the successful responses of the routes it wraps, e.g.
+// "public, max-age=60". Error responses are sent without one.
+func CacheControl(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy != "" {
+				r = r.WithContext(context.WithValue(r.Context(), CacheControlCtxKey, policy))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Versioned is implemented by payloads of resources with a version that
+// every edit bumps. Their entity tags start with the version, so edits can
+// be checked against it with IfMatchVersion, while the rest of the tag
+// still changes with anything else the payload holds, e.g. comments.
+type Versioned interface {
+	ETagVersion() int64
+}
+
+// IfMatch reports whether the If-Match precondition of r holds for a
+// resource currently tagged etag. It holds when r has no If-Match header.
+func IfMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return matchETag(header, etag, false)
+}
+
+// IfMatchVersion reports whether the If-Match precondition of r holds for
+// a Versioned resource currently at version, that is whether it has a tag
+// of that version. It holds when r has no If-Match header.
+func IfMatchVersion(r *http.Request, version int64) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	prefix := `"v` + strconv.FormatInt(version, 10) + "-"
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.HasPrefix(tag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns a strong entity tag for v encoded as b. Each encoding of a
+// resource gets its own tag, as they differ byte for byte.
+func etag(v interface{}, b []byte) string {
+	sum := sha256.Sum256(b)
+	if versioned, ok := v.(Versioned); ok {
+		return `"v` + strconv.FormatInt(versioned.ETagVersion(), 10) + "-" + hex.EncodeToString(sum[:16]) + `"`
+	}
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// notModified reports whether r already has the representation tagged
+// etag, so a GET can be answered with a 304.
+func notModified(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	header := r.Header.Get("If-None-Match")
+	return header != "" && matchETag(header, etag, true)
+}
+
+// matchETag reports whether the comma separated list of entity tags in
+// header has etag, or is "*". Weak comparison ignores the W/ prefix, which
+// If-None-Match uses; If-Match needs strong comparison.
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestMatchETag(t *testing.T) {
+	cases := []struct {
+		Header string
+		Weak   bool
+		Match  bool
+	}{
+		{`"a"`, false, true},
+		{`"b", "a"`, false, true},
+		{`*`, false, true},
+		{`"b"`, false, false},
+		{`W/"a"`, true, true},
+		{`W/"a"`, false, false},
+	}
+
+	for _, c := range cases {
+		if got := matchETag(c.Header, `"a"`, c.Weak); got != c.Match {
+			t.Errorf("%q weak %v: expected %v, got %v", c.Header, c.Weak, c.Match, got)
+		}
+	}
+}
+
+func TestConditionalResponses(t *testing.T) {
+	handler := CacheControl("private, no-cache")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			Render(w, r, ErrNotFound, zerolog.Nop())
+			return
+		}
+		Render(w, r, &testPayload{Name: "a"}, zerolog.Nop())
+	}))
+
+	serve := func(method, path string, header map[string]string) *httptest.ResponseRecorder {
+		rq := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			rq.Header.Set(k, v)
+		}
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, rq)
+		return res
+	}
+
+	res := serve(http.MethodGet, "/", nil)
+	tag := res.Header().Get("ETag")
+	if len(tag) != 34 || res.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("expected a strong etag and a cache policy, got %v", res.Header())
+	}
+	if xml := serve(http.MethodGet, "/", map[string]string{"Accept": "application/xml"}); xml.Header().Get("ETag") == tag {
+		t.Errorf("expected each encoding to have its own etag")
+	}
+
+	res = serve(http.MethodGet, "/", map[string]string{"If-None-Match": tag})
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 || res.Header().Get("ETag") != tag {
+		t.Errorf("expected an empty 304, got %v %q", res.Code, res.Body.String())
+	}
+	if res = serve(http.MethodGet, "/", map[string]string{"If-None-Match": `"stale"`}); res.Code != http.StatusOK {
+		t.Errorf("expected a 200 for a stale etag, got %v", res.Code)
+	}
+	if res = serve(http.MethodPut, "/", map[string]string{"If-None-Match": tag}); res.Code != http.StatusOK {
+		t.Errorf("expected only gets to be answered with a 304, got %v", res.Code)
+	}
+
+	res = serve(http.MethodGet, "/missing", nil)
+	if res.Header().Get("ETag") != "" || res.Header().Get("Cache-Control") != "" {
+		t.Errorf("expected errors to be neither validated nor cached, got %v", res.Header())
+	}
+}
+
+type versionedPayload struct {
+	Name    string `json:"name"`
+	Version int64  `json:"version"`
+}
+
+func (p *versionedPayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func (p *versionedPayload) ETagVersion() int64 {
+	return p.Version
+}
+
+func TestVersionedETag(t *testing.T) {
+	tag := func(p *versionedPayload) string {
+		res := httptest.NewRecorder()
+		Render(res, httptest.NewRequest(http.MethodGet, "/", nil), p, zerolog.Nop())
+		return res.Header().Get("ETag")
+	}
+
+	a, b := tag(&versionedPayload{Name: "a", Version: 3}), tag(&versionedPayload{Name: "b", Version: 3})
+	if !strings.HasPrefix(a, `"v3-`) || !strings.HasPrefix(b, `"v3-`) || a == b {
+		t.Fatalf("expected distinct etags of version 3, got %v and %v", a, b)
+	}
+
+	cases := []struct {
+		Header string
+		Match  bool
+	}{
+		{"", true},
+		{"*", true},
+		{a, true},
+		{b, true},
+		{`"v30-` + a[4:], false},
+		{`"stale", ` + b, true},
+		{`"stale"`, false},
+		{`W/` + a, false},
+	}
+	for _, c := range cases {
+		rq := httptest.NewRequest(http.MethodPut, "/", nil)
+		if c.Header != "" {
+			rq.Header.Set("If-Match", c.Header)
+		}
+		if got := IfMatchVersion(rq, 3); got != c.Match {
+			t.Errorf("expected %v for %q, got %v", c.Match, c.Header, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder writes v to w in a single media type.
+type Encoder func(w io.Writer, v interface{}) error
+
+type encoding struct {
+	mediaType   string
+	contentType string
+	encode      Encoder
+}
+
+// jsonEncoding is used when there is nothing to negotiate, e.g. to tell a
+// client none of the registered encodings are acceptable.
+var jsonEncoding = encoding{"application/json", "application/json; charset=utf-8", encodeJSON}
+
+var (
+	encodingsMu sync.RWMutex
+	// encodings are in order of preference, the first is used when the
+	// client accepts anything.
+	encodings = []encoding{
+		jsonEncoding,
+		{"application/xml", "application/xml; charset=utf-8", encodeXML},
+		{"text/xml", "text/xml; charset=utf-8", encodeXML},
+		{"application/cbor", "application/cbor", encodeCBOR},
+	}
+)
+
+// RegisterEncoder makes Respond able to answer in mediaType, e.g.
+// "application/yaml", sending contentType as the Content-Type header.
+// Registering a media type again replaces its encoder.
+func RegisterEncoder(mediaType, contentType string, enc Encoder) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	mediaType = strings.ToLower(mediaType)
+	for i, e := range encodings {
+		if e.mediaType == mediaType {
+			encodings[i] = encoding{mediaType, contentType, enc}
+			return
+		}
+	}
+	encodings = append(encodings, encoding{mediaType, contentType, enc})
+}
+
+// Negotiate returns the media type responses to r are encoded in, picked
+// from the registered encoders by r's Accept header. ok is false when r
+// accepts none of them.
+func Negotiate(r *http.Request) (mediaType string, ok bool) {
+	e, ok := negotiate(r)
+	return e.mediaType, ok
+}
+
+// mediaTypes returns the media types responses may be encoded in.
+func mediaTypes() []string {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+
+	types := []string{}
+	for _, e := range encodings {
+		types = append(types, e.mediaType)
+	}
+	return types
+}
+
+// negotiate returns the encoding the client prefers. Without an Accept
+// header the first encoding is used. Ties go to the earlier encoding.
+func negotiate(r *http.Request) (encoding, bool) {
+	encodingsMu.RLock()
+	defer encodingsMu.RUnlock()
+
+	header := strings.Join(r.Header["Accept"], ",")
+	if strings.TrimSpace(header) == "" {
+		return encodings[0], true
+	}
+	ranges := parseAccept(header)
+
+	var best encoding
+	bestQ := 0.0
+	for _, e := range encodings {
+		if q := quality(ranges, e.mediaType); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+// acceptRange is a single media range of an Accept header, e.g. text/*;q=0.5.
+type acceptRange struct {
+	typ, subtype string
+	q            float64
+}
+
+func parseAccept(header string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		slash := strings.IndexByte(mediaType, '/')
+		if slash < 0 {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil || q < 0 || q > 1 {
+				continue
+			}
+		}
+		ranges = append(ranges, acceptRange{mediaType[:slash], mediaType[slash+1:], q})
+	}
+	return ranges
+}
+
+// quality returns how much ranges accept mediaType. The most specific range
+// that matches decides, so "*/*, text/xml;q=0" refuses text/xml.
+func quality(ranges []acceptRange, mediaType string) float64 {
+	slash := strings.IndexByte(mediaType, '/')
+	typ, subtype := mediaType[:slash], mediaType[slash+1:]
+
+	q, specificity := 0.0, -1
+	for _, r := range ranges {
+		s := -1
+		switch {
+		case r.typ == typ && r.subtype == subtype:
+			s = 2
+		case r.typ == typ && r.subtype == "*":
+			s = 1
+		case r.typ == "*" && r.subtype == "*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+func encodeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(true)
+	return enc.Encode(v)
+}
+
+// generic returns v as the maps, slices, strings, json.Numbers, bools and
+// nils it marshals to as JSON. Encoders for other formats work from it, so
+// every format has the same fields, named by the payloads' json tags.
+func generic(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+
+	var g interface{}
+	if err := dec.Decode(&g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		Accept   string
+		Expected string
+	}{
+		{"", "application/json"},
+		{"*/*", "application/json"},
+		{"application/xml", "application/xml"},
+		{"text/html, application/xhtml+xml, application/xml;q=0.9, */*;q=0.8", "application/xml"},
+		{"application/cbor, application/json;q=0.5", "application/cbor"},
+		{"application/*;q=0.5, application/cbor", "application/cbor"},
+		{"text/*", "text/xml"},
+		{"*/*, application/json;q=0", "application/xml"},
+		{"APPLICATION/JSON", "application/json"},
+		{"text/html", ""},
+		{"application/json;q=0", ""},
+	}
+
+	for _, c := range cases {
+		rq := httptest.NewRequest(http.MethodGet, "/", nil)
+		rq.Header.Set("Accept", c.Accept)
+		got, ok := Negotiate(rq)
+		if !ok {
+			got = ""
+		}
+		if got != c.Expected {
+			t.Errorf("%q: expected %q, got %q", c.Accept, c.Expected, got)
+		}
+	}
+}
+
+type testPayload struct {
+	Name    string         `json:"name"`
+	Count   int            `json:"count"`
+	Score   float64        `json:"score"`
+	Tags    []string       `json:"tags"`
+	Counts  map[string]int `json:"counts,omitempty"`
+	Hidden  string         `json:"-"`
+	Missing *testPayload   `json:"missing"`
+}
+
+func (p *testPayload) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func TestEncoders(t *testing.T) {
+	v := &testPayload{Name: "a<b", Count: -2, Score: 1.5, Tags: []string{"x"}, Counts: map[string]int{"like": 300, "1st": 1}, Hidden: "h"}
+
+	var buf bytes.Buffer
+	if err := encodeXML(&buf, v); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	want := xmlHeader + `<response><count>-2</count><counts><entry key="1st">1</entry><like>300</like></counts>` +
+		`<name>a&lt;b</name><score>1.5</score><tags><item>x</item></tags></response>` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("expected xml %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	if err := encodeCBOR(&buf, v); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	cbor := []byte{0xa6,
+		0x65, 'c', 'o', 'u', 'n', 't', 0x21,
+		0x66, 'c', 'o', 'u', 'n', 't', 's', 0xa2,
+		0x63, '1', 's', 't', 0x01,
+		0x64, 'l', 'i', 'k', 'e', 0x19, 0x01, 0x2c,
+		0x67, 'm', 'i', 's', 's', 'i', 'n', 'g', 0xf6,
+		0x64, 'n', 'a', 'm', 'e', 0x63, 'a', '<', 'b',
+		0x65, 's', 'c', 'o', 'r', 'e', 0xfb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0x64, 't', 'a', 'g', 's', 0x81, 0x61, 'x',
+	}
+	if got := buf.Bytes(); !bytes.Equal(got, cbor) {
+		t.Errorf("expected cbor % x, got % x", cbor, got)
+	}
+}
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>` + "\n"
+
+func TestRespondNegotiates(t *testing.T) {
+	cases := []struct {
+		Accept      string
+		Status      int
+		ContentType string
+		Want        string
+	}{
+		{"", http.StatusCreated, "application/json; charset=utf-8", `"name":"a"`},
+		{"application/xml", http.StatusCreated, "application/xml; charset=utf-8", "<name>a</name>"},
+		{"application/cbor", http.StatusCreated, "application/cbor", "\x64name\x61a"},
+		{"text/html", http.StatusNotAcceptable, "application/json; charset=utf-8", "application/json, application/xml, text/xml, application/cbor"},
+	}
+
+	for _, c := range cases {
+		rq := httptest.NewRequest(http.MethodPost, "/", nil)
+		rq.Header.Set("Accept", c.Accept)
+		res := httptest.NewRecorder()
+
+		Status(rq, http.StatusCreated)
+		Render(res, rq, &testPayload{Name: "a"}, zerolog.Nop())
+
+		if res.Code != c.Status {
+			t.Errorf("%q: expected status %v, got %v", c.Accept, c.Status, res.Code)
+		}
+		if ct := res.Header().Get("Content-Type"); ct != c.ContentType {
+			t.Errorf("%q: expected content type %q, got %q", c.Accept, c.ContentType, ct)
+		}
+		if res.Header().Get("Vary") != "Accept" {
+			t.Errorf("%q: expected responses to vary by accept", c.Accept)
+		}
+		if body := res.Body.String(); !strings.Contains(body, c.Want) {
+			t.Errorf("%q: got %q, want it to contain %q", c.Accept, body, c.Want)
+		}
+	}
+}
@@ -16,12 +16,18 @@ type Binder interface {
 	Bind(r *http.Request) error
 }
 
+// Bind decodes the request body into v, calls the Bind methods of v and its
+// fields, and finally runs v's validation rules if it is a Validator.
 func Bind(r *http.Request, v Binder) error {
 	if err := Decode(r, v); err != nil {
 		return err
 	}
 
-	return binder(r, v)
+	if err := binder(r, v); err != nil {
+		return err
+	}
+
+	return validate(v)
 }
 
 func Render(w http.ResponseWriter, r *http.Request, v Renderer, l zerolog.Logger) error {
@@ -2,11 +2,12 @@ package render
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"net/http"
-	"context"
+	"strings"
 
+	"github.com/corymhall/blog-backend-go/pkg/trace"
 	"github.com/rs/zerolog"
 )
 
@@ -21,44 +22,78 @@ func Status(r *http.Request, status int) {
 	*r = *r.WithContext(context.WithValue(r.Context(), StatusCtxKey, status))
 }
 
+// Respond encodes v in the media type the client asks for in its Accept
+// header, see Negotiate. When the client accepts none that are registered,
+// a 406 listing them is sent as JSON instead.
 func Respond(w http.ResponseWriter, r *http.Request, v interface{}, logger zerolog.Logger) {
 	entry := logger.Info()
+	w.Header().Add("Vary", "Accept")
+
+	e, acceptable := negotiate(r)
+	if !acceptable {
+		entry.Msg(fmt.Sprintf("http error: not acceptable: %s", r.Header.Get("Accept")))
+		err := fmt.Errorf("responses are available as %s", strings.Join(mediaTypes(), ", "))
+		Status(r, http.StatusNotAcceptable)
+		write(w, r, ErrNotAcceptable(err), jsonEncoding, logger)
+		return
+	}
+
 	if err, ok := v.(error); ok {
 
 		// we set a default error status response code if one hasn't been set.
 		status, ok := r.Context().Value(StatusCtxKey).(int)
 		if !ok {
 			w.WriteHeader(400)
-		} 
+		}
 
 		// we log the error
 		entry.Msg(fmt.Sprintf("http error: %s (code=%d)", err, status))
 
-		JSON(w, r, v, logger)
+		write(w, r, v, e, logger)
 		return
 	}
-	JSON(w, r, v, logger)
+	write(w, r, v, e, logger)
 }
 
+// JSON responds with v encoded as JSON, whatever the client accepts.
 func JSON(w http.ResponseWriter, r *http.Request, v interface{}, logger zerolog.Logger) {
+	write(w, r, v, jsonEncoding, logger)
+}
+
+func write(w http.ResponseWriter, r *http.Request, v interface{}, e encoding, logger zerolog.Logger) {
+	_, span := trace.Start(r.Context(), "render")
+	defer span.End()
+	span.SetAttribute("content_type", e.contentType)
+
 	buf := &bytes.Buffer{}
-	enc := json.NewEncoder(buf)
-	enc.SetEscapeHTML(true)
 
-	if err := enc.Encode(v); err != nil {
+	if err := e.encode(buf, v); err != nil {
 		Respond(w, r, ErrInternalServerError(err), logger)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	if status, ok := r.Context().Value(StatusCtxKey).(int); ok {
+	status, ok := r.Context().Value(StatusCtxKey).(int)
+	if _, failed := v.(error); !failed && (!ok || status == http.StatusOK) {
+		// only successful responses are validated and cached
+		tag := etag(v, buf.Bytes())
+		w.Header().Set("ETag", tag)
+		if policy, ok := r.Context().Value(CacheControlCtxKey).(string); ok {
+			w.Header().Set("Cache-Control", policy)
+		}
+		if notModified(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", e.contentType)
+	if ok {
 		w.WriteHeader(status)
 	}
 
 	w.Write(buf.Bytes())
 }
 
-
 // NoContent returns a HTTP 204 "No Content" response.
 func NoContent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(204)
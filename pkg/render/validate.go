@@ -0,0 +1,127 @@
+package render
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+// Validator is implemented by request payloads that check their own fields.
+// Bind calls Validate after the payload has been decoded and bound, and
+// fails the request if any violation was recorded.
+type Validator interface {
+	Validate(v *Validation)
+}
+
+// FieldError describes a single field that failed validation. Field is the
+// path to the offending value in the JSON body, e.g. "post.title".
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned by Bind when a payload has one or more invalid
+// fields. It carries every violation so clients can fix them all at once.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, f := range e.Fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.Field, f.Message))
+	}
+	return "invalid fields: " + strings.Join(msgs, "; ")
+}
+
+// Validation collects the violations found while validating a payload.
+// Each rule takes the JSON path of the field it checks. Rules other than
+// Required pass on empty values, so optional fields only need the format
+// rules and required ones add Required as well.
+type Validation struct {
+	fields []FieldError
+}
+
+// Add records a violation of field.
+func (v *Validation) Add(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Present checks that a nested object was sent and reports whether it was, so
+// callers can skip the rules for its fields.
+func (v *Validation) Present(field string, present bool) bool {
+	if !present {
+		v.Add(field, "is required")
+	}
+	return present
+}
+
+// Required checks that value is not blank.
+func (v *Validation) Required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.Add(field, "is required")
+	}
+}
+
+// MaxLength checks that value is no more than n characters long.
+func (v *Validation) MaxLength(field, value string, n int) {
+	if utf8.RuneCountInString(value) > n {
+		v.Add(field, "must be at most %d characters", n)
+	}
+}
+
+// Email checks that value is a bare email address.
+func (v *Validation) Email(field, value string) {
+	if value == "" {
+		return
+	}
+	if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+		v.Add(field, "must be an email address")
+	}
+}
+
+// URL checks that value is an absolute http or https URL.
+func (v *Validation) URL(field, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.Add(field, "must be an http or https URL")
+	}
+}
+
+// OneOf checks that value is one of allowed.
+func (v *Validation) OneOf(field, value string, allowed ...string) {
+	if value == "" {
+		return
+	}
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.Add(field, "must be one of %s", strings.Join(allowed, ", "))
+}
+
+// Err returns a *ValidationError holding every violation recorded so far, or
+// nil if there were none.
+func (v *Validation) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: v.fields}
+}
+
+// validate runs the payload's own validation rules, if it has any.
+func validate(v interface{}) error {
+	val, ok := v.(Validator)
+	if !ok {
+		return nil
+	}
+	var vd Validation
+	val.Validate(&vd)
+	return vd.Err()
+}
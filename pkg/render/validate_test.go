@@ -0,0 +1,33 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidation(t *testing.T) {
+	cases := []struct {
+		Name  string
+		Check func(v *Validation)
+		Valid bool
+	}{
+		{"required", func(v *Validation) { v.Required("f", " ") }, false},
+		{"max length", func(v *Validation) { v.MaxLength("f", strings.Repeat("é", 3), 3) }, true},
+		{"too long", func(v *Validation) { v.MaxLength("f", "abcd", 3) }, false},
+		{"email", func(v *Validation) { v.Email("f", "user@email.com") }, true},
+		{"named email", func(v *Validation) { v.Email("f", "User <user@email.com>") }, false},
+		{"url", func(v *Validation) { v.URL("f", "https://example.com/a.jpg") }, true},
+		{"relative url", func(v *Validation) { v.URL("f", "/images/a.jpg") }, false},
+		{"enum", func(v *Validation) { v.OneOf("f", "b", "a", "b") }, true},
+		{"not in enum", func(v *Validation) { v.OneOf("f", "c", "a", "b") }, false},
+		{"optional", func(v *Validation) { v.Email("f", ""); v.URL("f", ""); v.OneOf("f", "", "a") }, true},
+	}
+
+	for _, c := range cases {
+		var v Validation
+		c.Check(&v)
+		if err := v.Err(); (err == nil) != c.Valid {
+			t.Errorf("%s: expected valid %v, got %v", c.Name, c.Valid, err)
+		}
+	}
+}
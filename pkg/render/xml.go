@@ -0,0 +1,100 @@
+package render
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+	"unicode"
+)
+
+// encodeXML writes v as XML with the same fields as its JSON. The document
+// element is <response>, objects become elements named by their keys and
+// each value of an array becomes an <item>. Keys that are not valid XML
+// names become <entry key="...">. Null values are left out.
+func encodeXML(w io.Writer, v interface{}) error {
+	g, err := generic(v)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	if err := writeXML(enc, "response", g); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+func writeXML(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !xmlName(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v[k] == nil {
+				continue
+			}
+			if err := writeXML(enc, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := writeXML(enc, "item", item); err != nil {
+				return err
+			}
+		}
+	case string:
+		if err := enc.EncodeToken(xml.CharData(v)); err != nil {
+			return err
+		}
+	case json.Number:
+		if err := enc.EncodeToken(xml.CharData(v.String())); err != nil {
+			return err
+		}
+	case bool:
+		if err := enc.EncodeToken(xml.CharData(strconv.FormatBool(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// xmlName reports whether name can be used as an element name. Names that
+// start with xml are reserved, so they are not used either.
+func xmlName(name string) bool {
+	if name == "" || len(name) >= 3 && (name[0]|0x20) == 'x' && (name[1]|0x20) == 'm' && (name[2]|0x20) == 'l' {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || r == '_':
+		case i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,155 @@
+package spam
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// minTraining is how many spam and how many ham submissions the model must
+// have learnt from before its scores are used.
+const minTraining = 5
+
+// model is a naive Bayes classifier over the words of a submission. It
+// counts in how many spam and ham submissions each word appeared, and
+// remembers which submissions it learnt from so a lesson can be undone.
+type model struct {
+	SpamDocs int            `json:"spam_docs"`
+	HamDocs  int            `json:"ham_docs"`
+	Spam     map[string]int `json:"spam"`
+	Ham      map[string]int `json:"ham"`
+	// Learnt maps the digest of each submission learnt from to whether it
+	// was spam.
+	Learnt map[string]bool `json:"learnt"`
+}
+
+func newModel() *model {
+	return &model{Spam: map[string]int{}, Ham: map[string]int{}, Learnt: map[string]bool{}}
+}
+
+// loadModel reads the model saved at path, or returns an empty model if
+// nothing was saved there yet.
+func loadModel(path string) (*model, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newModel(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := newModel()
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	if m.Learnt == nil {
+		// saved before lessons could be undone
+		m.Learnt = map[string]bool{}
+	}
+	return m, nil
+}
+
+// save writes the model to path. The file is replaced in one step so a
+// crash never leaves half a model behind.
+func (m *model) save(path string) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// learn counts the tokens of the submission with digest as spam or ham. A
+// submission learnt before as the other is forgotten first, and one learnt
+// before as the same is not counted twice. It reports whether the model
+// changed.
+func (m *model) learn(digest string, tokens []string, spam bool) bool {
+	if was, ok := m.Learnt[digest]; ok {
+		if was == spam {
+			return false
+		}
+		m.forget(digest, tokens)
+	}
+
+	counts := m.Ham
+	if spam {
+		counts = m.Spam
+		m.SpamDocs++
+	} else {
+		m.HamDocs++
+	}
+	for _, token := range tokens {
+		counts[token]++
+	}
+	m.Learnt[digest] = spam
+	return true
+}
+
+// forget undoes learning the submission with digest. It reports whether the
+// model had learnt from it.
+func (m *model) forget(digest string, tokens []string) bool {
+	spam, ok := m.Learnt[digest]
+	if !ok {
+		return false
+	}
+
+	counts := m.Ham
+	if spam {
+		counts = m.Spam
+		m.SpamDocs--
+	} else {
+		m.HamDocs--
+	}
+	for _, token := range tokens {
+		if counts[token]--; counts[token] <= 0 {
+			delete(counts, token)
+		}
+	}
+	delete(m.Learnt, digest)
+	return true
+}
+
+// classify returns the probability that a submission made of tokens is
+// spam, and whether the model has learnt enough for it to mean anything.
+// Words the model has never seen are ignored.
+func (m *model) classify(tokens []string) (float64, bool) {
+	if m.SpamDocs < minTraining || m.HamDocs < minTraining {
+		return 0, false
+	}
+
+	total := float64(m.SpamDocs + m.HamDocs)
+	spam := math.Log(float64(m.SpamDocs) / total)
+	ham := math.Log(float64(m.HamDocs) / total)
+	for _, token := range tokens {
+		s, h := m.Spam[token], m.Ham[token]
+		if s == 0 && h == 0 {
+			continue
+		}
+		// Laplace smoothing keeps words seen in only one class from
+		// deciding on their own
+		spam += math.Log(float64(s+1) / float64(m.SpamDocs+2))
+		ham += math.Log(float64(h+1) / float64(m.HamDocs+2))
+	}
+
+	return clamp(1 / (1 + math.Exp(ham-spam))), true
+}
+
+// clamp keeps a probability away from 0 and 1 so the model is never
+// entirely sure.
+func clamp(p float64) float64 {
+	return math.Max(0.01, math.Min(0.99, p))
+}
@@ -0,0 +1,278 @@
+// Package spam scores user submitted text, such as blog comments, for how
+// likely it is to be spam. Filter combines a few heuristics with a naive
+// Bayes model trained on the decisions moderators make.
+package spam
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Verdicts a Filter reaches.
+const (
+	Ham    = "ham"
+	Unsure = "unsure"
+	Spam   = "spam"
+)
+
+// Submission is a piece of text to check.
+type Submission struct {
+	// Author identifies who sent the text. It may be empty.
+	Author string
+	Text   string
+}
+
+// Result is what a Filter concluded about a Submission.
+type Result struct {
+	// Score is how likely the submission is to be spam, from 0 to 1.
+	Score   float64
+	Verdict string
+	// Reasons lists the heuristics that fired, for moderators to review.
+	Reasons []string
+}
+
+// Config configures a Filter. The zero value is usable.
+type Config struct {
+	// Blocked lists words and phrases that mark a submission as spam.
+	// Matching ignores case.
+	Blocked []string
+
+	// MaxLinks is how many links a submission may hold before it looks like
+	// spam. Defaults to 2.
+	MaxLinks int
+
+	// DuplicateWindow is how long an identical submission is remembered, so
+	// resubmitting it looks like spam. Defaults to ten minutes.
+	DuplicateWindow time.Duration
+
+	// SpamThreshold and UnsureThreshold are the scores from which a
+	// submission is judged spam, or left for a moderator to decide.
+	// They default to 0.9 and 0.5.
+	SpamThreshold   float64
+	UnsureThreshold float64
+
+	// ModelPath is the file the naive Bayes model is loaded from and saved
+	// to after every lesson. When empty the model only lives in memory.
+	ModelPath string
+
+	// Now is used to expire remembered submissions. When nil the wall clock
+	// is used.
+	Now func() time.Time
+}
+
+// Filter is the built in spam checker. It is safe for concurrent use.
+type Filter struct {
+	cfg     Config
+	blocked []string
+
+	mu    sync.Mutex
+	model *model
+	seen  map[string]time.Time
+}
+
+// minDuplicateLength is the length from which identical submissions count
+// as duplicates, so short replies such as "thanks!" may be repeated.
+const minDuplicateLength = 20
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+`)
+
+// NewFilter returns a Filter using cfg, loading its model from cfg.ModelPath
+// if that file exists.
+func NewFilter(cfg Config) (*Filter, error) {
+	if cfg.MaxLinks == 0 {
+		cfg.MaxLinks = 2
+	}
+	if cfg.DuplicateWindow == 0 {
+		cfg.DuplicateWindow = 10 * time.Minute
+	}
+	if cfg.SpamThreshold == 0 {
+		cfg.SpamThreshold = 0.9
+	}
+	if cfg.UnsureThreshold == 0 {
+		cfg.UnsureThreshold = 0.5
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	f := &Filter{
+		cfg:   cfg,
+		model: newModel(),
+		seen:  map[string]time.Time{},
+	}
+	for _, word := range cfg.Blocked {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			f.blocked = append(f.blocked, word)
+		}
+	}
+	if cfg.ModelPath != "" {
+		m, err := loadModel(cfg.ModelPath)
+		if err != nil {
+			return nil, err
+		}
+		f.model = m
+	}
+	return f, nil
+}
+
+// Check scores s. The heuristics and the model each give a score and the
+// highest wins. The model only has a say once it has learnt from enough
+// spam and ham.
+func (f *Filter) Check(ctx context.Context, s Submission) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	text := strings.ToLower(s.Text)
+
+	for _, word := range f.blocked {
+		if strings.Contains(text, word) {
+			res.add(1, fmt.Sprintf("contains blocked word %q", word))
+			break
+		}
+	}
+
+	if links := len(linkPattern.FindAllString(s.Text, -1)); links > 0 {
+		words := len(strings.Fields(s.Text))
+		switch {
+		case links > f.cfg.MaxLinks:
+			res.add(0.95, fmt.Sprintf("holds %d links", links))
+		case float64(links)/float64(words) > 0.25:
+			res.add(0.7, "is mostly links")
+		}
+	}
+
+	f.mu.Lock()
+	if f.duplicate(s) {
+		res.add(0.95, "was just submitted")
+	}
+	if p, ok := f.model.classify(tokenize(s.Text)); ok && p > res.Score {
+		res.Score = p
+	}
+	f.mu.Unlock()
+
+	switch {
+	case res.Score >= f.cfg.SpamThreshold:
+		res.Verdict = Spam
+	case res.Score >= f.cfg.UnsureThreshold:
+		res.Verdict = Unsure
+	default:
+		res.Verdict = Ham
+	}
+	return res, nil
+}
+
+// Learn trains the model with a submission a moderator judged to be spam or
+// not, and saves the model if it has a ModelPath. Learning a submission
+// again replaces the earlier lesson.
+func (f *Filter) Learn(ctx context.Context, s Submission, spam bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.model.learn(digest(s), tokenize(s.Text), spam) {
+		return nil
+	}
+	return f.save()
+}
+
+// Forget undoes what the model learnt from a submission, e.g. when a
+// moderator reverses their verdict. Submissions it never learnt from are
+// ignored.
+func (f *Filter) Forget(ctx context.Context, s Submission) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.model.forget(digest(s), tokenize(s.Text)) {
+		return nil
+	}
+	return f.save()
+}
+
+// save saves the model if it has a ModelPath. f.mu must be held.
+func (f *Filter) save() error {
+	if f.cfg.ModelPath == "" {
+		return nil
+	}
+	return f.model.save(f.cfg.ModelPath)
+}
+
+func (r *Result) add(score float64, reason string) {
+	if score > r.Score {
+		r.Score = score
+	}
+	r.Reasons = append(r.Reasons, reason)
+}
+
+// duplicate reports whether the same text was submitted within the
+// duplicate window, and remembers s. f.mu must be held.
+func (f *Filter) duplicate(s Submission) bool {
+	normal := strings.Join(strings.Fields(strings.ToLower(s.Text)), " ")
+	if len(normal) < minDuplicateLength {
+		return false
+	}
+
+	now := f.cfg.Now()
+	for key, at := range f.seen {
+		if now.Sub(at) > f.cfg.DuplicateWindow {
+			delete(f.seen, key)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(normal))
+	key := hex.EncodeToString(sum[:])
+	_, dup := f.seen[key]
+	f.seen[key] = now
+	return dup
+}
+
+// digest identifies s among the submissions the model learnt from.
+func digest(s Submission) string {
+	sum := sha256.Sum256([]byte(s.Author + "\n" + s.Text))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenize splits text into the distinct lower case words the model counts.
+// Links are reduced to their host, which says more than the full url.
+func tokenize(text string) []string {
+	set := map[string]bool{}
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		host := strings.ToLower(link)
+		host = strings.TrimPrefix(strings.TrimPrefix(host, "http://"), "https://")
+		if i := strings.IndexAny(host, "/?#"); i >= 0 {
+			host = host[:i]
+		}
+		set["link:"+host] = true
+	}
+	text = linkPattern.ReplaceAllString(text, " ")
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if n := len(word); n > 1 && n <= 30 {
+			set[word] = true
+		}
+	}
+
+	tokens := make([]string, 0, len(set))
+	for token := range set {
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
@@ -0,0 +1,135 @@
+package spam
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Text     string
+		Expected string
+	}{
+		{"plain comment", "Great post, thanks for writing it up!", Ham},
+		{"blocked word", "Cheap VIAGRA for everyone", Spam},
+		{"too many links", "see http://a.com http://b.com http://c.com", Spam},
+		{"mostly links", "look www.example.com", Unsure},
+		{"one link in prose", "I wrote about this too at https://example.com/post if you are interested", Ham},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			f, err := NewFilter(Config{Blocked: []string{"viagra"}})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			res, err := f.Check(context.Background(), Submission{Text: c.Text})
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if res.Verdict != c.Expected {
+				t.Errorf("expected %v, got %v (%v)", c.Expected, res.Verdict, res)
+			}
+		})
+	}
+}
+
+func TestCheckDuplicate(t *testing.T) {
+	now := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+	f, _ := NewFilter(Config{Now: func() time.Time { return now }})
+	s := Submission{Text: "This is the very same comment, again"}
+
+	if res, _ := f.Check(context.Background(), s); res.Verdict != Ham {
+		t.Errorf("expected the first submission to be ham, got %v", res)
+	}
+	if res, _ := f.Check(context.Background(), s); res.Verdict != Spam {
+		t.Errorf("expected a duplicate to be spam, got %v", res)
+	}
+
+	now = now.Add(time.Hour)
+	if res, _ := f.Check(context.Background(), s); res.Verdict != Ham {
+		t.Errorf("expected the submission to be forgotten, got %v", res)
+	}
+}
+
+func TestLearn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spam")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "model.json")
+	f, err := NewFilter(Config{ModelPath: path, DuplicateWindow: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	ctx := context.Background()
+	probe := Submission{Text: "buy cheap watches online today"}
+
+	for i := 0; i < minTraining; i++ {
+		if res, _ := f.Check(ctx, probe); res.Verdict != Ham {
+			t.Fatalf("expected an untrained model to stay quiet, got %v", res)
+		}
+		f.Learn(ctx, Submission{Text: "buy cheap watches and bags online", Author: strconv.Itoa(i)}, true)
+		f.Learn(ctx, Submission{Text: "nice write up on go interfaces", Author: strconv.Itoa(i)}, false)
+	}
+
+	if res, _ := f.Check(ctx, probe); res.Verdict != Spam {
+		t.Errorf("expected the trained model to flag spam, got %v", res)
+	}
+	if res, _ := f.Check(ctx, Submission{Text: "interfaces in go are nice"}); res.Verdict != Ham {
+		t.Errorf("expected the trained model to pass ham, got %v", res)
+	}
+
+	// a new filter picks up where the last one stopped
+	f, err = NewFilter(Config{ModelPath: path})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if res, _ := f.Check(ctx, probe); res.Verdict != Spam {
+		t.Errorf("expected the saved model to flag spam, got %v", res)
+	}
+}
+
+func TestForget(t *testing.T) {
+	f, _ := NewFilter(Config{DuplicateWindow: time.Nanosecond})
+	ctx := context.Background()
+	probe := Submission{Text: "buy cheap watches online today"}
+	lesson := Submission{Text: "buy cheap watches and bags online"}
+
+	for i := 0; i < minTraining; i++ {
+		f.Learn(ctx, Submission{Text: "nice write up on go interfaces", Author: strconv.Itoa(i)}, false)
+		f.Learn(ctx, Submission{Text: "buy cheap watches and bags online", Author: strconv.Itoa(i)}, true)
+	}
+	// learning the same submission again counts it once
+	f.Learn(ctx, lesson, true)
+	f.Learn(ctx, lesson, true)
+	if f.model.SpamDocs != minTraining+1 {
+		t.Errorf("expected %d spam lessons, got %d", minTraining+1, f.model.SpamDocs)
+	}
+	if res, _ := f.Check(ctx, probe); res.Verdict != Spam {
+		t.Fatalf("expected the trained model to flag spam, got %v", res)
+	}
+
+	// a reversed verdict moves the lesson
+	f.Learn(ctx, lesson, false)
+	if f.model.SpamDocs != minTraining || f.model.HamDocs != minTraining+1 {
+		t.Errorf("expected the lesson to move to ham, got %d spam and %d ham", f.model.SpamDocs, f.model.HamDocs)
+	}
+
+	f.Forget(ctx, lesson)
+	if f.model.SpamDocs != minTraining || f.model.HamDocs != minTraining {
+		t.Errorf("expected the lesson to be forgotten, got %d spam and %d ham", f.model.SpamDocs, f.model.HamDocs)
+	}
+	// forgetting what was never learnt changes nothing
+	f.Forget(ctx, probe)
+	if f.model.SpamDocs != minTraining || f.model.HamDocs != minTraining {
+		t.Errorf("expected the model to be unchanged, got %d spam and %d ham", f.model.SpamDocs, f.model.HamDocs)
+	}
+}
@@ -0,0 +1,63 @@
+// Package thumbnail scales images down using only the standard library.
+package thumbnail
+
+import (
+	"image"
+)
+
+// Fit returns src scaled down to fit within max by max pixels, keeping its
+// aspect ratio. Images that already fit are copied at their own size. Each
+// pixel of the result is the average of the source pixels it covers, which
+// keeps thin lines and fine detail from aliasing away.
+func Fit(src image.Image, max int) *image.RGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > max || sh > max {
+		if sw >= sh {
+			dw, dh = max, sh*max/sw
+		} else {
+			dw, dh = sw*max/sh, max
+		}
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := span(y, sh, dh)
+		for x := 0; x < dw; x++ {
+			x0, x1 := span(x, sw, dw)
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// span returns the source pixels that destination pixel i covers when size
+// source pixels are scaled to scaled. It always covers at least one.
+func span(i, size, scaled int) (int, int) {
+	start, end := i*size/scaled, (i+1)*size/scaled
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
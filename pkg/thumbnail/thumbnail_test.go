@@ -0,0 +1,46 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestFit(t *testing.T) {
+	cases := []struct {
+		Name          string
+		Width, Height int
+		Max           int
+		Expected      image.Point
+	}{
+		{"landscape", 400, 200, 100, image.Pt(100, 50)},
+		{"portrait", 200, 400, 100, image.Pt(50, 100)},
+		{"already fits", 40, 20, 100, image.Pt(40, 20)},
+		{"very thin", 1000, 1, 100, image.Pt(100, 1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(10, 10, 10+c.Width, 10+c.Height))
+			got := Fit(src, c.Max).Bounds().Size()
+			if got != c.Expected {
+				t.Errorf("expected %v, got %v", c.Expected, got)
+			}
+		})
+	}
+}
+
+func TestFitAverages(t *testing.T) {
+	// black and white stripes average to grey
+	src := image.NewGray(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x += 2 {
+		for y := 0; y < 4; y++ {
+			src.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+
+	got := Fit(src, 2).RGBAAt(0, 0)
+	if got.R < 126 || got.R > 128 || got.A != 255 {
+		t.Errorf("expected grey, got %v", got)
+	}
+}
@@ -0,0 +1,265 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JSONLinesExporter writes each span as a line of JSON, which suits looking
+// at traces locally, e.g. with jq.
+type JSONLinesExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLinesExporter returns an exporter writing to w, e.g. a file opened
+// for appending.
+func NewJSONLinesExporter(w io.Writer) *JSONLinesExporter {
+	return &JSONLinesExporter{w: w}
+}
+
+// jsonSpan is how a span is written by JSONLinesExporter.
+type jsonSpan struct {
+	TraceID    string                 `json:"trace_id"`
+	SpanID     string                 `json:"span_id"`
+	ParentID   string                 `json:"parent_span_id,omitempty"`
+	Name       string                 `json:"name"`
+	Kind       string                 `json:"kind"`
+	Start      time.Time              `json:"start"`
+	End        time.Time              `json:"end"`
+	DurationMS float64                `json:"duration_ms"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Export writes spans, one per line.
+func (e *JSONLinesExporter) Export(ctx context.Context, spans []SpanData) error {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	for _, s := range spans {
+		js := jsonSpan{
+			TraceID:    s.SpanContext.TraceID.String(),
+			SpanID:     s.SpanContext.SpanID.String(),
+			Name:       s.Name,
+			Kind:       s.Kind.String(),
+			Start:      s.Start.UTC(),
+			End:        s.End.UTC(),
+			DurationMS: float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+			Attributes: s.Attributes,
+			Error:      s.Error,
+		}
+		if s.Parent.IsValid() {
+			js.ParentID = s.Parent.String()
+		}
+		if err := enc.Encode(js); err != nil {
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+// DefaultOTLPEndpoint is where a local OpenTelemetry collector takes traces.
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// OTLPConfig configures an OTLPExporter.
+type OTLPConfig struct {
+	// Endpoint is the URL spans are posted to. When empty
+	// DefaultOTLPEndpoint is used.
+	Endpoint string
+
+	// Headers are sent with every export, e.g. the api key of a tracing
+	// service.
+	Headers map[string]string
+
+	// ServiceName names the api in the traces it exports.
+	ServiceName string
+
+	// Client sends the exports. When nil http.DefaultClient is used.
+	Client *http.Client
+}
+
+// OTLPExporter posts spans to an OpenTelemetry collector, or any service
+// that takes OTLP over http, encoded as JSON.
+type OTLPExporter struct {
+	cfg OTLPConfig
+}
+
+// NewOTLPExporter returns an exporter using cfg.
+func NewOTLPExporter(cfg OTLPConfig) *OTLPExporter {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = DefaultOTLPEndpoint
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	return &OTLPExporter{cfg: cfg}
+}
+
+// The OTLP/JSON encoding of a request to export spans, see
+// https://github.com/open-telemetry/opentelemetry-proto. IDs are hex and
+// 64 bit integers are strings, as the protobuf JSON mapping has them.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpStatus struct {
+		Message string `json:"message,omitempty"`
+		Code    int    `json:"code"`
+	}
+)
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+
+	otlpStatusError = 2
+)
+
+// otlpScopeName names what recorded the spans.
+const otlpScopeName = "github.com/corymhall/blog-backend-go/pkg/trace"
+
+// Export posts spans to the endpoint.
+func (e *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: otlpScopeName}}
+	for _, s := range spans {
+		scope.Spans = append(scope.Spans, otlpEncodeSpan(s))
+	}
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]interface{}{"service.name": e.cfg.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	rq, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	rq = rq.WithContext(ctx)
+	rq.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		rq.Header.Set(k, v)
+	}
+	res, err := e.cfg.Client.Do(rq)
+	if err != nil {
+		return fmt.Errorf("unable to export spans: %v", err)
+	}
+	defer res.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unable to export spans: %s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func otlpEncodeSpan(s SpanData) otlpSpan {
+	out := otlpSpan{
+		TraceID:           s.SpanContext.TraceID.String(),
+		SpanID:            s.SpanContext.SpanID.String(),
+		Name:              s.Name,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.Attributes),
+	}
+	if s.Parent.IsValid() {
+		out.ParentSpanID = s.Parent.String()
+	}
+	switch s.Kind {
+	case KindServer:
+		out.Kind = otlpKindServer
+	case KindClient:
+		out.Kind = otlpKindClient
+	default:
+		out.Kind = otlpKindInternal
+	}
+	if s.Error != "" {
+		out.Status = &otlpStatus{Code: otlpStatusError, Message: s.Error}
+	}
+	return out
+}
+
+// otlpAttributes encodes attrs sorted by key. Values of types OTLP has no
+// counterpart for are sent as strings.
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	kvs := []otlpKeyValue{}
+	for _, k := range keys {
+		var v otlpValue
+		switch a := attrs[k].(type) {
+		case bool:
+			v.BoolValue = &a
+		case int:
+			i := strconv.Itoa(a)
+			v.IntValue = &i
+		case int64:
+			i := strconv.FormatInt(a, 10)
+			v.IntValue = &i
+		case float64:
+			v.DoubleValue = &a
+		case string:
+			v.StringValue = &a
+		default:
+			s := fmt.Sprint(a)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: v})
+	}
+	return kvs
+}
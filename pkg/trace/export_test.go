@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSpans() []SpanData {
+	start := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+	root, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	child := root
+	child.SpanID = SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	return []SpanData{
+		{Name: "GET /posts/{postID}", SpanContext: root, Kind: KindServer, Start: start, End: start.Add(20 * time.Millisecond),
+			Attributes: map[string]interface{}{"http.status_code": 500, "http.route": "/posts/{postID}"}, Error: "500 Internal Server Error"},
+		{Name: "DBGetPost", SpanContext: child, Parent: root.SpanID, Start: start.Add(time.Millisecond), End: start.Add(3 * time.Millisecond)},
+	}
+}
+
+func TestJSONLinesExporter(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewJSONLinesExporter(&buf).Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line for each span, got %q", buf.String())
+	}
+	want := []string{
+		`{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"00f067aa0ba902b7","name":"GET /posts/{postID}","kind":"server","start":"2018-11-10T23:00:00Z","end":"2018-11-10T23:00:00.02Z","duration_ms":20,"attributes":{"http.route":"/posts/{postID}","http.status_code":500},"error":"500 Internal Server Error"}`,
+		`{"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736","span_id":"0102030405060708","parent_span_id":"00f067aa0ba902b7","name":"DBGetPost","kind":"internal","start":"2018-11-10T23:00:00.001Z","end":"2018-11-10T23:00:00.003Z","duration_ms":2}`,
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("expected\n%s\ngot\n%s", want[i], lines[i])
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body []byte
+	var header http.Header
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		header = r.Header
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := NewOTLPExporter(OTLPConfig{Endpoint: srv.URL, Headers: map[string]string{"X-Api-Key": "secret"}, ServiceName: "blog"})
+	if err := e.Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("X-Api-Key") != "secret" {
+		t.Errorf("expected JSON with the configured headers, got %v", header)
+	}
+
+	var req otlpRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		t.Fatalf("unable to decode export %s: %v", body, err)
+	}
+	rs := req.ResourceSpans[0]
+	if kv := rs.Resource.Attributes[0]; kv.Key != "service.name" || *kv.Value.StringValue != "blog" {
+		t.Errorf("expected the service name, got %s", body)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %s", body)
+	}
+	server, db := spans[0], spans[1]
+	if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Kind != otlpKindServer || server.ParentSpanID != "" ||
+		server.StartTimeUnixNano != "1541890800000000000" || server.Status == nil || server.Status.Code != otlpStatusError {
+		t.Errorf("unexpected server span %+v", server)
+	}
+	if kv := server.Attributes[1]; kv.Key != "http.status_code" || *kv.Value.IntValue != "500" {
+		t.Errorf("expected integer attributes as strings, got %+v", server.Attributes)
+	}
+	if db.ParentSpanID != "00f067aa0ba902b7" || db.Kind != otlpKindInternal || db.Status != nil {
+		t.Errorf("unexpected datastore span %+v", db)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := e.Export(context.Background(), testSpans()); err == nil {
+		t.Errorf("expected a failed export to return an error")
+	}
+}
+
+func TestTracerExportErrors(t *testing.T) {
+	failed := make(chan error, 1)
+	tracer := NewTracer(Config{
+		Exporter:    exporterFunc(func([]SpanData) error { return errors.New("collector down") }),
+		SampleRatio: 1,
+		OnError:     func(err error) { failed <- err },
+	})
+	_, span := tracer.Start(context.Background(), "request", KindServer, SpanContext{})
+	span.End()
+	tracer.Flush()
+
+	select {
+	case err := <-failed:
+		if err.Error() != "collector down" {
+			t.Errorf("unexpected error %v", err)
+		}
+	default:
+		t.Errorf("expected the export error to be reported")
+	}
+	tracer.Close()
+}
+
+type exporterFunc func([]SpanData) error
+
+func (f exporterFunc) Export(ctx context.Context, spans []SpanData) error { return f(spans) }
@@ -0,0 +1,458 @@
+// Package trace records spans of the work done for each request and sends
+// them to an Exporter. Traces are continued from, and handed on with, W3C
+// traceparent headers, see https://www.w3.org/TR/trace-context/.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, every span of one request shares it.
+type TraceID [16]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+
+// IsValid reports whether t is set, an all zero ID is invalid.
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+
+// SpanID identifies a span within its trace.
+type SpanID [8]byte
+
+func (s SpanID) String() string { return hex.EncodeToString(s[:]) }
+
+// IsValid reports whether s is set, an all zero ID is invalid.
+func (s SpanID) IsValid() bool { return s != SpanID{} }
+
+// SpanContext is what is handed on to identify a span, e.g. in a
+// traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled is whether the trace is being recorded.
+	Sampled bool
+}
+
+// IsValid reports whether both IDs of sc are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent formats sc as a traceparent header.
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+var errTraceparent = errors.New("malformed traceparent")
+
+// ParseTraceparent parses a traceparent header. Headers of versions after 00
+// are read as far as version 00 goes, as the spec asks.
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+	if len(h) < 55 || h[2] != '-' || h[35] != '-' || h[52] != '-' {
+		return sc, errTraceparent
+	}
+	version := h[:2]
+	switch {
+	case !isLowerHex(version) || version == "ff":
+		return sc, errTraceparent
+	case version == "00" && len(h) != 55:
+		return sc, errTraceparent
+	case len(h) > 55 && h[55] != '-':
+		return sc, errTraceparent
+	}
+	if !isLowerHex(h[3:35]) || !isLowerHex(h[36:52]) || !isLowerHex(h[53:55]) {
+		return sc, errTraceparent
+	}
+	hex.Decode(sc.TraceID[:], []byte(h[3:35]))
+	hex.Decode(sc.SpanID[:], []byte(h[36:52]))
+	var flags [1]byte
+	hex.Decode(flags[:], []byte(h[53:55]))
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, errTraceparent
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Kind says what a span measures.
+type Kind int
+
+const (
+	// KindInternal spans measure work within the api.
+	KindInternal Kind = iota
+	// KindServer spans measure serving a request.
+	KindServer
+	// KindClient spans measure a request made to another service.
+	KindClient
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+// SpanData is a finished span, as exporters are handed it.
+type SpanData struct {
+	Name        string
+	SpanContext SpanContext
+	// Parent is the span this one was started in, zero for the first span
+	// of a trace.
+	Parent     SpanID
+	Kind       Kind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	// Error is the message of the error that failed the span, if any.
+	Error string
+}
+
+// Span is work being timed. A nil *Span does nothing, so code may start
+// spans whether or not the request is traced.
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx holding s.
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, s)
+}
+
+// FromContext returns the span ctx holds, or nil when there is none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Start starts a span within the span ctx holds. When ctx holds none the
+// work is not traced, and the nil span returned does nothing.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return StartAt(ctx, name, time.Time{})
+}
+
+// StartAt is Start for work that began at start, e.g. a call whose span is
+// only recorded once it has returned. A zero start means now.
+func StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	s := parent.tracer.newSpan(name, KindInternal, parent.SpanContext(), start)
+	return ContextWithSpan(ctx, s), s
+}
+
+// SpanContext returns what identifies s.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// SetName renames s, e.g. once the route a request matched is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+// SetAttribute records a fact about the work, value should be a string,
+// bool, integer or float.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = map[string]interface{}{}
+	}
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks s as failed with err. A nil err changes nothing.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Error = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes s and hands it to the exporter, if its trace is sampled.
+// Only the first call does anything.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
+
+// Exporter sends finished spans somewhere they can be looked at.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Config configures a Tracer.
+type Config struct {
+	Exporter Exporter
+
+	// SampleRatio is the share of traces started here that are recorded,
+	// from 0 for none to 1 for all. Traces continued from a traceparent
+	// follow the decision of whoever started them.
+	SampleRatio float64
+
+	// BatchSize is how many spans are exported at once. When zero
+	// DefaultBatchSize is used.
+	BatchSize int
+
+	// FlushInterval is the longest a span waits to be exported. When zero
+	// DefaultFlushInterval is used.
+	FlushInterval time.Duration
+
+	// QueueSize is how many spans may wait to be exported. Spans ended
+	// while the queue is full are dropped rather than slowing requests
+	// down. When zero DefaultQueueSize is used.
+	QueueSize int
+
+	// OnError is called with the errors of the exporter. When nil they are
+	// ignored.
+	OnError func(error)
+
+	// Now returns the time spans start and end at. When nil time.Now is
+	// used.
+	Now func() time.Time
+}
+
+const (
+	DefaultBatchSize     = 512
+	DefaultFlushInterval = 5 * time.Second
+	DefaultQueueSize     = 2048
+)
+
+// exportTimeout bounds how long a single export may take.
+const exportTimeout = 10 * time.Second
+
+// Tracer starts traces and exports their spans in batches, in the
+// background. It is safe for concurrent use.
+type Tracer struct {
+	cfg Config
+
+	queue chan SpanData
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewTracer returns a tracer exporting to cfg.Exporter. It must be closed
+// for the last spans to be exported.
+func NewTracer(cfg Config) *Tracer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	t := &Tracer{
+		cfg:   cfg,
+		queue: make(chan SpanData, cfg.QueueSize),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start starts a span of the given kind. It continues the trace of parent,
+// e.g. one parsed from a traceparent header, or starts a trace when parent
+// is not valid.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, parent SpanContext) (context.Context, *Span) {
+	if !parent.IsValid() {
+		id := newTraceID()
+		parent = SpanContext{TraceID: id, Sampled: t.sample(id)}
+	}
+	s := t.newSpan(name, kind, parent, time.Time{})
+	return ContextWithSpan(ctx, s), s
+}
+
+// sample decides whether the trace id starts is recorded. It goes by the
+// low bits of id, which are random.
+func (t *Tracer) sample(id TraceID) bool {
+	switch {
+	case t.cfg.SampleRatio >= 1:
+		return true
+	case t.cfg.SampleRatio <= 0:
+		return false
+	}
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.cfg.SampleRatio*(1<<64))
+}
+
+func (t *Tracer) newSpan(name string, kind Kind, parent SpanContext, start time.Time) *Span {
+	if start.IsZero() {
+		start = t.now()
+	}
+	return &Span{
+		tracer: t,
+		data: SpanData{
+			Name: name,
+			SpanContext: SpanContext{
+				TraceID: parent.TraceID,
+				SpanID:  newSpanID(),
+				Sampled: parent.Sampled,
+			},
+			Parent: parent.SpanID,
+			Kind:   kind,
+			Start:  start,
+		},
+	}
+}
+
+func (t *Tracer) now() time.Time {
+	if t.cfg.Now != nil {
+		return t.cfg.Now()
+	}
+	return time.Now()
+}
+
+func (t *Tracer) enqueue(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+	}
+}
+
+// Flush exports every span ended so far.
+func (t *Tracer) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+		<-flushed
+	case <-t.done:
+	}
+}
+
+// Close exports the spans that are left and stops exporting. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Close() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batch := []SpanData{}
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		err := t.cfg.Exporter.Export(ctx, batch)
+		cancel()
+		if err != nil && t.cfg.OnError != nil {
+			t.cfg.OnError(err)
+		}
+		batch = []SpanData{}
+	}
+	// drain exports everything queued
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				if batch = append(batch, data); len(batch) >= t.cfg.BatchSize {
+					export()
+				}
+			default:
+				export()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case data := <-t.queue:
+			if batch = append(batch, data); len(batch) >= t.cfg.BatchSize {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			drain()
+			close(flushed)
+		case <-t.stop:
+			drain()
+			return
+		}
+	}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recorder keeps the spans exported to it.
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	cases := []struct {
+		Name    string
+		Header  string
+		Valid   bool
+		Sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"later version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-what-comes-next", true, true},
+		{"later version without more", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"extra after version 00", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"upper case", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"bad separator", "00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, c := range cases {
+		sc, err := ParseTraceparent(c.Header)
+		if valid := err == nil; valid != c.Valid {
+			t.Errorf("%s: expected valid to be %v, got %v", c.Name, c.Valid, err)
+			continue
+		}
+		if !c.Valid {
+			continue
+		}
+		if sc.Sampled != c.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+			t.Errorf("%s: got %+v", c.Name, sc)
+		}
+		if c.Header[:2] == "00" && sc.Traceparent() != c.Header {
+			t.Errorf("%s: expected %s to format as it was parsed, got %s", c.Name, c.Header, sc.Traceparent())
+		}
+	}
+}
+
+func TestTracer(t *testing.T) {
+	rec := &recorder{}
+	tracer := NewTracer(Config{Exporter: rec, SampleRatio: 1})
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := tracer.Start(context.Background(), "request", KindServer, parent)
+	ctx, child := Start(ctx, "lookup")
+	_, grandchild := Start(ctx, "query")
+	grandchild.SetError(errors.New("not found"))
+	grandchild.End()
+	child.SetAttribute("id", 1)
+	child.End()
+	root.End()
+	root.End()
+	tracer.Close()
+
+	if len(rec.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(rec.spans))
+	}
+	query, lookup, request := rec.spans[0], rec.spans[1], rec.spans[2]
+	for _, s := range rec.spans {
+		if s.SpanContext.TraceID != parent.TraceID {
+			t.Errorf("expected %s to continue trace %s, got %s", s.Name, parent.TraceID, s.SpanContext.TraceID)
+		}
+	}
+	if request.Parent != parent.SpanID || lookup.Parent != request.SpanContext.SpanID || query.Parent != lookup.SpanContext.SpanID {
+		t.Errorf("expected each span to be within the one before it, got %v %v %v", request.Parent, lookup.Parent, query.Parent)
+	}
+	if request.Kind != KindServer || lookup.Kind != KindInternal {
+		t.Errorf("expected a server span with internal spans in it, got %v %v", request.Kind, lookup.Kind)
+	}
+	if query.Error != "not found" || lookup.Attributes["id"] != 1 {
+		t.Errorf("expected the error and attributes to be recorded, got %q %v", query.Error, lookup.Attributes)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Ratio    float64
+		Parent   string
+		Expected int
+	}{
+		{"all", 1, "", 2},
+		{"none", 0, "", 0},
+		{"caller sampled", 0, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", 2},
+		{"caller did not sample", 1, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", 0},
+	}
+
+	for _, c := range cases {
+		rec := &recorder{}
+		tracer := NewTracer(Config{Exporter: rec, SampleRatio: c.Ratio})
+		parent, _ := ParseTraceparent(c.Parent)
+		ctx, root := tracer.Start(context.Background(), "request", KindServer, parent)
+		_, child := Start(ctx, "lookup")
+		child.End()
+		root.End()
+		tracer.Close()
+
+		if len(rec.spans) != c.Expected {
+			t.Errorf("%s: expected %d spans, got %d", c.Name, c.Expected, len(rec.spans))
+		}
+		if !root.SpanContext().IsValid() || root.SpanContext().Sampled != (c.Expected > 0) {
+			t.Errorf("%s: expected a traceparent to hand on either way, got %s", c.Name, root.SpanContext().Traceparent())
+		}
+	}
+}
+
+func TestUntraced(t *testing.T) {
+	ctx, span := Start(context.Background(), "lookup")
+	span.SetAttribute("id", 1)
+	span.SetError(errors.New("failed"))
+	span.End()
+	if span != nil || FromContext(ctx) != nil {
+		t.Errorf("expected no span outside of a trace, got %v", span)
+	}
+}
@@ -0,0 +1,83 @@
+// Package ulid generates Universally Unique Lexicographically Sortable
+// Identifiers. A ULID is a 48 bit millisecond timestamp followed by 80 bits
+// of randomness, written as 26 characters of Crockford's base32, so sorting
+// the strings sorts them by creation time.
+package ulid
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Generator makes ULIDs from a source of entropy. IDs made within the same
+// millisecond reuse the previous random part incremented by one, so they still
+// sort in the order they were made. It is safe for concurrent use.
+type Generator struct {
+	mu      sync.Mutex
+	entropy io.Reader
+	lastMs  uint64
+	last    [10]byte
+}
+
+// New returns a Generator reading randomness from entropy. A nil entropy uses
+// crypto/rand.
+func New(entropy io.Reader) *Generator {
+	if entropy == nil {
+		entropy = rand.Reader
+	}
+	return &Generator{entropy: entropy}
+}
+
+// Make returns a new ULID for the time t.
+func (g *Generator) Make(t time.Time) string {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+
+	g.mu.Lock()
+	if ms == g.lastMs {
+		// increment the 80 bit random part as a big endian number
+		for i := len(g.last) - 1; i >= 0; i-- {
+			g.last[i]++
+			if g.last[i] != 0 {
+				break
+			}
+		}
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.last[:]); err != nil {
+			g.mu.Unlock()
+			panic("ulid: reading entropy: " + err.Error())
+		}
+		g.lastMs = ms
+	}
+
+	var id [16]byte
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], ms)
+	copy(id[:6], ts[2:])
+	copy(id[6:], g.last[:])
+	g.mu.Unlock()
+
+	return encode(id)
+}
+
+// encode writes the 128 bits of id as 26 base32 characters. The 130 bits that
+// takes are made up by two leading zero bits.
+func encode(id [16]byte) string {
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for j := 0; j < 5; j++ {
+			v <<= 1
+			bit := i*5 + j - 2
+			if bit >= 0 && id[bit/8]&(0x80>>uint(bit%8)) != 0 {
+				v |= 1
+			}
+		}
+		out[i] = encoding[v]
+	}
+	return string(out[:])
+}
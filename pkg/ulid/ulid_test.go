@@ -0,0 +1,28 @@
+package ulid
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMake(t *testing.T) {
+	g := New(bytes.NewReader(make([]byte, 20)))
+	ts := time.Date(2018, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	first := g.Make(ts)
+	if first != "01CVZXMYC00000000000000000" {
+		t.Errorf("got '%s'", first)
+	}
+
+	// within the same millisecond ids keep increasing
+	second := g.Make(ts)
+	if second <= first {
+		t.Errorf("expected '%s' to sort after '%s'", second, first)
+	}
+
+	later := g.Make(ts.Add(time.Millisecond))
+	if later <= second {
+		t.Errorf("expected '%s' to sort after '%s'", later, second)
+	}
+}
@@ -48,6 +48,37 @@ resource "aws_dynamodb_table" "comments" {
     name = "comment_date"
     type = "S"
   }
+
+  attribute {
+    name = "status"
+    type = "S"
+  }
+
+  attribute {
+    name = "owner"
+    type = "S"
+  }
+
+  # the moderation queue
+  global_secondary_index {
+    name            = "status-comment_date-index"
+    hash_key        = "status"
+    range_key       = "comment_date"
+    read_capacity   = 5
+    write_capacity  = 5
+    projection_type = "ALL"
+  }
+
+  # finds returning commenters
+  global_secondary_index {
+    name               = "owner-comment_date-index"
+    hash_key           = "owner"
+    range_key          = "comment_date"
+    read_capacity      = 5
+    write_capacity     = 5
+    projection_type    = "INCLUDE"
+    non_key_attributes = ["status"]
+  }
 }
 
 resource "aws_dynamodb_table" "reply" {
@@ -67,6 +98,21 @@ resource "aws_dynamodb_table" "reply" {
     name = "reply_date"
     type = "S"
   }
+
+  attribute {
+    name = "status"
+    type = "S"
+  }
+
+  # the moderation queue
+  global_secondary_index {
+    name            = "status-reply_date-index"
+    hash_key        = "status"
+    range_key       = "reply_date"
+    read_capacity   = 5
+    write_capacity  = 5
+    projection_type = "ALL"
+  }
 }
 
 resource "aws_dynamodb_table" "user" {
@@ -80,4 +126,53 @@ resource "aws_dynamodb_table" "user" {
     name = "id"
     type = "S"
   }
+
+  attribute {
+    name = "owner"
+    type = "S"
+  }
+
+  # finds the stored user, and so the role, of whoever signed a request
+  global_secondary_index {
+    name            = "owner-index"
+    hash_key        = "owner"
+    read_capacity   = 5
+    write_capacity  = 5
+    projection_type = "ALL"
+  }
+}
+
+# one item per user and post or comment they reacted to, the counts are
+# kept on the post or comment itself
+resource "aws_dynamodb_table" "reactions" {
+  name           = "Reactions"
+  billing_mode   = "PROVISIONED"
+  read_capacity  = 5
+  write_capacity = 5
+  hash_key       = "target"
+  range_key      = "owner"
+
+  attribute {
+    name = "target"
+    type = "S"
+  }
+
+  attribute {
+    name = "owner"
+    type = "S"
+  }
+}
+
+# uploaded images, their bytes are kept in the media bucket
+resource "aws_dynamodb_table" "media" {
+  name           = "Media"
+  billing_mode   = "PROVISIONED"
+  read_capacity  = 5
+  write_capacity = 5
+  hash_key       = "id"
+
+  attribute {
+    name = "id"
+    type = "S"
+  }
 }
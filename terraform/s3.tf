@@ -7,3 +7,14 @@ resource "aws_s3_bucket" "tfstate" {
     Provisioner = "Terraform"
   }
 }
+
+# uploaded images and their thumbnails, served to readers directly
+resource "aws_s3_bucket" "media" {
+  bucket = "${var.project}-media-${var.region}"
+  acl    = "public-read"
+
+  tags {
+    Name        = "${var.project}-media-${var.region}"
+    Provisioner = "Terraform"
+  }
+}